@@ -3,10 +3,13 @@ package backtracedb
 import (
 	"backtraceDB/internal/db"
 	"backtraceDB/internal/schema"
+	"backtraceDB/internal/table"
+	"backtraceDB/internal/wal"
 	"math/rand"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 )
 
 func generateStockData(count int) []map[string]any {
@@ -32,7 +35,7 @@ func SetupTableforBenchmark(count int) (*db.DB, schema.Schema, error) {
 	dbName := "benchmark_test_db"
 	os.RemoveAll(filepath.Join("_data_internal", dbName))
 
-	database, err := db.Open(dbName)
+	database, err := db.Open(dbName, db.Options{})
 	if err != nil {
 		return nil, schema.Schema{}, err
 	}
@@ -78,7 +81,8 @@ func BenchmarkCreation(b *testing.B) {
 
 		for i := 0; i < b.N; i++ {
 			b.StopTimer()
-
+			// the catalog would otherwise hand back the table from the previous iteration
+			os.RemoveAll(filepath.Join("_data_internal", "benchmark_test_db"))
 			b.StartTimer()
 
 			s := schema.Schema{
@@ -92,24 +96,26 @@ func BenchmarkCreation(b *testing.B) {
 				},
 			}
 
-			database, err := db.Open("benchmark_test_db")
+			database, err := db.Open("benchmark_test_db", db.Options{})
 			if err != nil {
 				b.Fatal(err)
 			}
-			defer database.Close()
 
 			tbl, err := database.CreateTable(s)
 			if err != nil {
+				database.Close()
 				b.Fatal(err)
 			}
 			tbl.MaxBlockSize = 1000
 
 			for _, row := range stockData {
 				if err := tbl.AppendRow(row); err != nil {
+					database.Close()
 					b.Fatal(err)
 				}
 			}
 
+			database.Close()
 		}
 	})
 
@@ -121,7 +127,7 @@ func BenchmarkCreation(b *testing.B) {
 		for i := 0; i < b.N; i++ {
 			os.RemoveAll(filepath.Join("_data_internal", "benchmark_test_db"))
 
-			database, err := db.Open("benchmark_test_db")
+			database, err := db.Open("benchmark_test_db", db.Options{})
 			if err != nil {
 				b.Fatal(err)
 			}
@@ -159,6 +165,94 @@ func BenchmarkCreation(b *testing.B) {
 	})
 }
 
+// BenchmarkBatchCreation ingests the same rows as BenchmarkCreation through the batch
+// APIs, which validate once and write one WAL record per batch.
+func BenchmarkBatchCreation(b *testing.B) {
+
+	rowCount := 10_000
+	batchSize := 1000
+	stockData := generateStockData(rowCount)
+
+	columns := make([]map[string]any, 0, rowCount/batchSize)
+	for start := 0; start < rowCount; start += batchSize {
+		batch := stockData[start : start+batchSize]
+		ts := make([]int64, len(batch))
+		symbols := make([]string, len(batch))
+		prices := make([]float64, len(batch))
+		volumes := make([]int64, len(batch))
+		for i, row := range batch {
+			ts[i] = row["timestamp"].(int64)
+			symbols[i] = row["symbol"].(string)
+			prices[i] = row["price"].(float64)
+			volumes[i] = row["volume"].(int64)
+		}
+		columns = append(columns, map[string]any{"timestamp": ts, "symbol": symbols, "price": prices, "volume": volumes})
+	}
+
+	s := schema.Schema{
+		Name:       "Testing",
+		TimeColumn: "timestamp",
+		Columns: []schema.Column{
+			{Name: "timestamp", Type: schema.Int64},
+			{Name: "symbol", Type: schema.String},
+			{Name: "price", Type: schema.Float64},
+			{Name: "volume", Type: schema.Int64},
+		},
+	}
+
+	run := func(b *testing.B, ingest func(tbl *table.Table) error) {
+		b.SetBytes(int64(rowCount))
+		defer os.RemoveAll(filepath.Join("_data_internal", "benchmark_test_db"))
+		b.ResetTimer()
+
+		for i := 0; i < b.N; i++ {
+			os.RemoveAll(filepath.Join("_data_internal", "benchmark_test_db"))
+
+			database, err := db.Open("benchmark_test_db", db.Options{})
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			tbl, err := database.CreateTable(s)
+			if err != nil {
+				database.Close()
+				b.Fatal(err)
+			}
+			tbl.UseDiskStorage = true
+			tbl.MaxBlockSize = 1000
+
+			if err := ingest(tbl); err != nil {
+				database.Close()
+				b.Fatal(err)
+			}
+
+			database.Close()
+		}
+	}
+
+	b.Run("InDisk-Parquet-AppendBatch", func(b *testing.B) {
+		run(b, func(tbl *table.Table) error {
+			for start := 0; start < rowCount; start += batchSize {
+				if err := tbl.AppendBatch(stockData[start : start+batchSize]); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+
+	b.Run("InDisk-Parquet-AppendColumns", func(b *testing.B) {
+		run(b, func(tbl *table.Table) error {
+			for _, cols := range columns {
+				if err := tbl.AppendColumns(cols); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+}
+
 func BenchmarkRetreivalSpeed(b *testing.B) {
 	rowCount := 10_000
 	stockData := generateStockData(rowCount)
@@ -177,7 +271,7 @@ func BenchmarkRetreivalSpeed(b *testing.B) {
 	b.Run("Memory-Parquet", func(b *testing.B) {
 		b.SetBytes(int64(rowCount))
 		dbPath := "bench_mem_retreival"
-		database, _ := db.Open(dbPath)
+		database, _ := db.Open(dbPath, db.Options{})
 		defer os.RemoveAll(filepath.Join("_data_internal", dbPath))
 		defer database.Close()
 
@@ -200,10 +294,45 @@ func BenchmarkRetreivalSpeed(b *testing.B) {
 		}
 	})
 
+	b.Run("Memory-Parquet-NextBatch", func(b *testing.B) {
+		b.SetBytes(int64(rowCount))
+		dbPath := "bench_mem_batch_retreival"
+		database, _ := db.Open(dbPath, db.Options{})
+		defer os.RemoveAll(filepath.Join("_data_internal", dbPath))
+		defer database.Close()
+
+		tbl, _ := database.CreateTable(s)
+		tbl.MaxBlockSize = 1000
+		tbl.UseDiskStorage = false
+
+		for _, r := range stockData {
+			_ = tbl.AppendRow(r)
+		}
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			r := tbl.Reader()
+			sum := 0.0
+			for {
+				batch, ok := r.NextBatch()
+				if !ok {
+					break
+				}
+				prices, _ := batch.Float64("price")
+				for j, selected := range batch.Selection {
+					if selected {
+						sum += prices[j]
+					}
+				}
+			}
+			_ = sum
+		}
+	})
+
 	b.Run("InDisk-Parquet-With-WAL", func(b *testing.B) {
 		b.SetBytes(int64(rowCount))
 		dbPath := "bench_disk_retreival"
-		database, _ := db.Open(dbPath)
+		database, _ := db.Open(dbPath, db.Options{})
 		defer os.RemoveAll(filepath.Join("_data_internal", dbPath))
 		defer database.Close()
 
@@ -243,7 +372,7 @@ func BenchmarkLoadSpeedFromDisk(b *testing.B) {
 	}
 
 	dbPath := "bench_load_speed"
-	database, _ := db.Open(dbPath)
+	database, _ := db.Open(dbPath, db.Options{})
 	defer os.RemoveAll(filepath.Join("_data_internal", dbPath))
 
 	tbl, _ := database.CreateTable(s)
@@ -259,7 +388,7 @@ func BenchmarkLoadSpeedFromDisk(b *testing.B) {
 
 	for i := 0; i < b.N; i++ {
 		// Measure just the discovery + initial reading
-		dbNew, _ := db.Open(dbPath)
+		dbNew, _ := db.Open(dbPath, db.Options{})
 
 		// This measures discovery (naming convention parsing)
 		tblNew, _ := dbNew.OpenTable(s)
@@ -293,7 +422,10 @@ func BenchmarkRetreivalSpeedWithFilter(b *testing.B) {
 		},
 	}
 
-	database, err := db.Open("benchmark_test_db")
+	os.RemoveAll(filepath.Join("_data_internal", "benchmark_test_db"))
+	defer os.RemoveAll(filepath.Join("_data_internal", "benchmark_test_db"))
+
+	database, err := db.Open("benchmark_test_db", db.Options{})
 	if err != nil {
 		b.Fatal(err)
 	}
@@ -399,3 +531,63 @@ func BenchmarkRetreivalSpeedWithFilter(b *testing.B) {
 		}
 	})
 }
+
+// BenchmarkWALSync compares the WAL durability policies with several goroutines
+// appending to the same table, the way concurrent feeds ingest.
+func BenchmarkWALSync(b *testing.B) {
+	s := schema.Schema{
+		Name:       "SyncBench",
+		TimeColumn: "timestamp",
+		Columns: []schema.Column{
+			{Name: "timestamp", Type: schema.Int64},
+			{Name: "symbol", Type: schema.String},
+			{Name: "price", Type: schema.Float64},
+			{Name: "volume", Type: schema.Int64},
+		},
+	}
+
+	policies := []struct {
+		name   string
+		policy wal.SyncPolicy
+	}{
+		{"None", wal.SyncPolicy{Mode: wal.SyncNone}},
+		{"Always", wal.SyncPolicy{Mode: wal.SyncAlways}},
+		{"EveryN-100", wal.SyncPolicy{Mode: wal.SyncEveryN, N: 100}},
+		{"Interval-10ms", wal.SyncPolicy{Mode: wal.SyncInterval, Interval: 10 * time.Millisecond}},
+		{"GroupCommit", wal.SyncPolicy{Mode: wal.SyncGroupCommit}},
+	}
+
+	for _, p := range policies {
+		b.Run(p.name, func(b *testing.B) {
+			database, err := db.Open("bench_wal_sync", db.Options{
+				Root:           b.TempDir(),
+				UseDiskStorage: true,
+				MaxBlockSize:   100_000,
+				Sync:           p.policy,
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer database.Close()
+
+			tbl, err := database.CreateTable(s)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			b.SetParallelism(8)
+			b.ResetTimer()
+
+			b.RunParallel(func(pb *testing.PB) {
+				// every goroutine writes the same tick so the time ordering always holds
+				row := map[string]any{"timestamp": int64(1673628000000), "symbol": "AAPL", "price": 150.0, "volume": int64(10)}
+				for pb.Next() {
+					if err := tbl.AppendRow(row); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
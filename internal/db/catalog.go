@@ -0,0 +1,144 @@
+package db
+
+import (
+	"backtraceDB/internal/schema"
+	"backtraceDB/internal/table"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const catalogFile = "catalog.json"
+
+// catalogEntry is everything needed to reopen a table without the caller
+// supplying its schema again.
+type catalogEntry struct {
+	Schema         schema.Schema `json:"schema"`
+	MaxBlockSize   int           `json:"max_block_size"`
+	UseDiskStorage bool          `json:"use_disk_storage"`
+	OutOfOrder     bool          `json:"out_of_order,omitempty"`
+
+	// Partitions lists the time partitions holding blocks with their bounds, kept up
+	// to date by the table as partitions are created and dropped
+	Partitioning table.Partitioning `json:"partitioning,omitzero"`
+	Partitions   []table.Partition  `json:"partitions,omitempty"`
+
+	// Retention expires old rows in the background, set by Options.Retention or
+	// SetRetention
+	Retention table.RetentionPolicy `json:"retention,omitzero"`
+
+	// Dir is the directory a renamed table still lives in until it is moved, empty
+	// once the directory carries the table name
+	Dir string `json:"dir,omitempty"`
+
+	// History holds every schema version older than Schema, blocks and WAL records
+	// written under them are read through these
+	History []schema.Schema `json:"history,omitempty"`
+}
+
+type catalog struct {
+	// mu guards Tables and save. Tables report partition changes without db.mu, so it
+	// is never held while calling into a table
+	mu     sync.Mutex
+	path   string
+	Tables map[string]catalogEntry `json:"tables"`
+}
+
+func loadCatalog(dir string) (*catalog, error) {
+	c := &catalog{
+		path:   filepath.Join(dir, catalogFile),
+		Tables: make(map[string]catalogEntry),
+	}
+
+	data, err := os.ReadFile(c.path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read catalog: %v", err)
+	}
+
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("failed to parse catalog %s: %v", c.path, err)
+	}
+
+	if c.Tables == nil {
+		c.Tables = make(map[string]catalogEntry)
+	}
+
+	return c, nil
+}
+
+// setPartitions records the partitions of a table already in the catalog. The
+// partitions are saved again with the next change or on Close when the save fails.
+func (c *catalog) setPartitions(name string, parts []table.Partition) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.Tables[name]
+	if !ok {
+		return nil
+	}
+	entry.Partitions = parts
+	c.Tables[name] = entry
+
+	if err := c.save(); err != nil {
+		return fmt.Errorf("failed to save partitions of table %s: %v", name, err)
+	}
+	return nil
+}
+
+// save rewrites the whole catalog through a temp file and a rename so a crash
+// leaves either the old or the new catalog on disk, never a torn one, callers must hold
+// c.mu
+func (c *catalog) save() error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %v", err)
+	}
+
+	tmp := c.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, c.path); err != nil {
+		return err
+	}
+	// the rename is only durable once the directory is
+	return syncDir(filepath.Dir(c.path))
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("failed to sync directory %s: %v", dir, err)
+	}
+	return nil
+}
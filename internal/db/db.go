@@ -5,22 +5,208 @@ import (
 	"backtraceDB/internal/table"
 	"backtraceDB/internal/wal"
 	"fmt"
+	"log"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
+	"time"
 )
 
+const defaultRoot = "_data_internal"
+
+// Options configures where a database is stored and the defaults its tables start with.
+// The zero value keeps the historical behaviour of storing everything under
+// _data_internal in the working directory.
+type Options struct {
+	// Root is the directory holding every database, one sub-directory per database.
+	Root string
+
+	// MaxBlockSize, UseDiskStorage and AllowOutOfOrder are applied to tables made by
+	// CreateTable. Tables recovered from the catalog keep the values stored with them.
+	MaxBlockSize    int
+	UseDiskStorage  bool
+	AllowOutOfOrder bool
+
+	// Sync is the WAL durability policy of every table, TableSync overrides it for the
+	// tables it names. Unlike the block settings these are not stored in the catalog,
+	// they apply to whatever tables the database opens or creates.
+	Sync      wal.SyncPolicy
+	TableSync map[string]wal.SyncPolicy
+
+	// Compaction merges small blocks of every table in the background when its
+	// Interval is set, the tables stop compacting when the database is closed
+	Compaction table.CompactionPolicy
+
+	// Partitioning files the blocks of tables made by CreateTable under one directory
+	// per time bucket. It is stored in the catalog with each table
+	Partitioning table.Partitioning
+
+	// Retention expires old rows of tables made by CreateTable or first registered by
+	// OpenTable, SetRetention changes it per table. It is stored in the catalog
+	Retention table.RetentionPolicy
+}
+
 type DB struct {
-	mu     sync.RWMutex
-	name   string
-	tables map[string]*table.Table
+	mu      sync.RWMutex
+	name    string
+	dir     string
+	opts    Options
+	tables  map[string]*table.Table
+	catalog *catalog
+
+	// what WAL replay found for each table opened with a log
+	recovery map[string]wal.ReplayResult
+}
+
+// Open loads the catalog of the database and reopens every table recorded in it,
+// so existing tables are available through Table without re-supplying a schema.
+func Open(name string, opts Options) (*DB, error) {
+	if opts.Root == "" {
+		opts.Root = defaultRoot
+	}
+
+	dir := filepath.Join(opts.Root, name)
+
+	c, err := loadCatalog(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	db := &DB{
+		name:     name,
+		dir:      dir,
+		opts:     opts,
+		tables:   make(map[string]*table.Table),
+		catalog:  c,
+		recovery: make(map[string]wal.ReplayResult),
+	}
+
+	if err := db.finishPending(); err != nil {
+		return nil, err
+	}
+
+	// tables opened first may already report partitions to the catalog while the rest
+	// is loaded
+	entries := make(map[string]catalogEntry, len(c.Tables))
+	for tableName, entry := range c.Tables {
+		entries[tableName] = entry
+	}
+
+	for tableName, entry := range entries {
+		t, err := db.openEntry(entry)
+		if err != nil {
+			// the tables opened so far hold WALs and background jobs, nothing is written
+			for _, opened := range db.tables {
+				opened.Detach(false)
+			}
+			return nil, fmt.Errorf("failed to open table %s: %v", tableName, err)
+		}
+		db.tables[tableName] = t
+	}
+
+	return db, nil
 }
 
-func Open(name string) (*DB, error) {
-	return &DB{
-		name:   name,
-		tables: make(map[string]*table.Table),
-	}, nil
+// openEntry opens the table recorded in entry with its stored settings.
+func (db *DB) openEntry(entry catalogEntry) (*table.Table, error) {
+	t, err := db.openTable(entry.Schema, entry.History, entry.Partitioning)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry.MaxBlockSize > 0 {
+		t.MaxBlockSize = entry.MaxBlockSize
+	}
+	if entry.UseDiskStorage {
+		t.UseDiskStorage = true
+	}
+	t.AllowOutOfOrder = entry.OutOfOrder
+
+	// started once the stored settings are in place, the compactor reads them
+	if err := t.StartCompaction(db.opts.Compaction); err != nil {
+		t.Detach(false)
+		return nil, err
+	}
+	if err := t.StartRetention(entry.Retention); err != nil {
+		t.Detach(false)
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// a table directory moved aside by DropTable or TruncateTable is named after the
+// operation, the time and the table, so a crash before it was removed is finished here
+const (
+	droppedPrefix   = ".dropped-"
+	truncatedPrefix = ".truncated-"
+)
+
+func trashPath(dir, prefix, name string) string {
+	return filepath.Join(dir, fmt.Sprintf("%s%d-%s", prefix, time.Now().UnixNano(), name))
+}
+
+// finishPending completes the drops, truncates and renames a crash interrupted. A
+// dropped table whose directory was moved aside but is still in the catalog leaves it
+// now, a renamed table whose directory was not moved yet is moved now.
+func (db *DB) finishPending() error {
+	c := db.catalog
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	changed := false
+
+	for name, entry := range c.Tables {
+		if entry.Dir == "" {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(db.dir, entry.Dir)); err == nil {
+			if err := os.Rename(filepath.Join(db.dir, entry.Dir), db.tablePath(name)); err != nil {
+				return fmt.Errorf("failed to finish renaming table %s: %v", name, err)
+			}
+			if err := syncDir(db.dir); err != nil {
+				return err
+			}
+		}
+		entry.Dir = ""
+		c.Tables[name] = entry
+		changed = true
+	}
+
+	dirEntries, err := os.ReadDir(db.dir)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read database directory: %v", err)
+	}
+
+	for _, e := range dirEntries {
+		trash := e.Name()
+		if !e.IsDir() || (!strings.HasPrefix(trash, droppedPrefix) && !strings.HasPrefix(trash, truncatedPrefix)) {
+			continue
+		}
+
+		if rest, ok := strings.CutPrefix(trash, droppedPrefix); ok {
+			_, name, _ := strings.Cut(rest, "-")
+			if _, listed := c.Tables[name]; listed {
+				if _, err := os.Stat(db.tablePath(name)); os.IsNotExist(err) {
+					delete(c.Tables, name)
+					changed = true
+				}
+			}
+		}
+
+		if err := os.RemoveAll(filepath.Join(db.dir, trash)); err != nil {
+			return fmt.Errorf("failed to remove %s: %v", trash, err)
+		}
+	}
+
+	if !changed {
+		return nil
+	}
+	if err := c.save(); err != nil {
+		return fmt.Errorf("failed to save catalog: %v", err)
+	}
+	return nil
 }
 
 func (db *DB) CreateTable(s schema.Schema) (*table.Table, error) {
@@ -32,63 +218,508 @@ func (db *DB) CreateTable(s schema.Schema) (*table.Table, error) {
 		return nil, fmt.Errorf("table %s already exists", s.Name)
 	}
 
-	t, err := table.CreateTable(s, nil, db.name)
+	if err := db.opts.Partitioning.Validate(); err != nil {
+		return nil, err
+	}
+
+	t, err := table.CreateTable(s, nil, db.tablePath(s.Name))
 	if err != nil {
 		return nil, err
 	}
+	t.Partitioning = db.opts.Partitioning
+	t.OnPartitionsChange = db.partitionsChanged(s.Name)
+
+	if db.opts.MaxBlockSize > 0 {
+		t.MaxBlockSize = db.opts.MaxBlockSize
+	}
+	t.UseDiskStorage = db.opts.UseDiskStorage
+	t.AllowOutOfOrder = db.opts.AllowOutOfOrder
+	t.SyncPolicy = db.syncPolicy(s.Name)
+
+	if err := t.StartCompaction(db.opts.Compaction); err != nil {
+		return nil, err
+	}
+	if err := t.StartRetention(db.opts.Retention); err != nil {
+		t.StopCompaction()
+		return nil, err
+	}
+
+	if err := db.register(t); err != nil {
+		t.StopCompaction()
+		t.StopRetention()
+		return nil, err
+	}
 
 	db.tables[s.Name] = t
 	return t, nil
 }
 
+// OpenTable returns the table described by s. Tables recorded in the catalog are
+// checked against s so a stale schema cannot misread the parquet columns; tables
+// written before the catalog existed are recovered from disk and registered.
 func (db *DB) OpenTable(s schema.Schema) (*table.Table, error) {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
 	if tbl, ok := db.tables[s.Name]; ok {
+		if err := s.Matches(tbl.Schema()); err != nil {
+			return nil, fmt.Errorf("schema mismatch: %v", err)
+		}
 		return tbl, nil
 	}
 
-	tablePath := filepath.Join("_data_internal", db.name, s.Name)
+	t, err := db.openTable(s, nil, table.Partitioning{})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := t.StartCompaction(db.opts.Compaction); err != nil {
+		return nil, err
+	}
+	if err := t.StartRetention(db.opts.Retention); err != nil {
+		t.StopCompaction()
+		return nil, err
+	}
+
+	if err := db.register(t); err != nil {
+		t.StopCompaction()
+		t.StopRetention()
+		return nil, err
+	}
+
+	db.tables[s.Name] = t
+	return t, nil
+}
+
+func (db *DB) openTable(s schema.Schema, history []schema.Schema, part table.Partitioning) (*table.Table, error) {
+	tablePath := db.tablePath(s.Name)
 	walPath := filepath.Join(tablePath, "wal")
-	walFile := filepath.Join(walPath, "wal.dat")
+
+	policy := db.syncPolicy(s.Name)
+
+	hasWAL, err := wal.Exists(walPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look for WAL: %v", err)
+	}
 
 	var w *wal.WAL
-	if _, err := os.Stat(walFile); err == nil {
-		var err error
-		w, err = wal.NewWAL(walPath, s)
+	if hasWAL {
+		w, err = wal.NewWAL(walPath, s, history...)
 		if err != nil {
 			return nil, fmt.Errorf("failed to open WAL: %v", err)
 		}
+		if err := w.SetSyncPolicy(policy); err != nil {
+			w.Close()
+			return nil, err
+		}
+	}
+
+	// the WAL is closed again when the table cannot be opened
+	opened := false
+	if w != nil {
+		defer func() {
+			if !opened {
+				w.Close()
+			}
+		}()
 	}
 
-	t, err := table.CreateTable(s, w, db.name)
+	t, err := table.CreateTable(s, w, tablePath, history...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create table: %v", err)
 	}
+	t.SyncPolicy = policy
+	t.Partitioning = part
+	t.OnPartitionsChange = db.partitionsChanged(s.Name)
 
 	if err := t.LoadFromDisk(); err != nil {
 		return nil, fmt.Errorf("failed to load parquet data: %v", err)
 	}
 
 	if w != nil {
-		if err := w.ReplayTable(t); err != nil {
+		// records at or below the durable LSN are in the blocks loaded above already,
+		// a crash between a flush and its checkpoint leaves them behind in the log
+		durable := t.DurableLSN()
+
+		result, err := w.ReplayTable(t, durable)
+		if err != nil {
 			return nil, fmt.Errorf("failed to replay WAL: %v", err)
 		}
+		db.recovery[s.Name] = result
 
-		// once replayed, we will truncate the WAL to prevent double loading on next start
-		if err := w.Reset(); err != nil {
-			return nil, fmt.Errorf("failed to clear WAL after recovery: %v", err)
+		// replayed records stay in the log until their rows are flushed, only the
+		// segments the blocks cover are dropped
+		if err := w.Checkpoint(durable); err != nil {
+			return nil, fmt.Errorf("failed to checkpoint WAL after recovery: %v", err)
 		}
 
 		t.UseDiskStorage = true
 	}
 
-	db.tables[s.Name] = t
+	opened = true
 	return t, nil
 }
 
-func (db *DB) getTableName(name string) (*table.Table, bool) {
+func (db *DB) syncPolicy(table string) wal.SyncPolicy {
+	if p, ok := db.opts.TableSync[table]; ok {
+		return p
+	}
+	return db.opts.Sync
+}
+
+func (db *DB) tablePath(name string) string {
+	return filepath.Join(db.dir, name)
+}
+
+// partitionsChanged keeps the partitions of the table name in the catalog up to date.
+// The change the table made stands either way, so a failed save is only logged.
+func (db *DB) partitionsChanged(name string) func([]table.Partition) {
+	return func(parts []table.Partition) {
+		if err := db.catalog.setPartitions(name, parts); err != nil {
+			log.Printf("backtraceDB: %v", err)
+		}
+	}
+}
+
+// register records the table in the catalog, callers must hold db.mu
+func (db *DB) register(t *table.Table) error {
+	entry := catalogEntry{
+		Schema:         t.Schema(),
+		MaxBlockSize:   t.MaxBlockSize,
+		UseDiskStorage: t.UseDiskStorage,
+		OutOfOrder:     t.AllowOutOfOrder,
+		Partitioning:   t.Partitioning,
+		Partitions:     t.Partitions(),
+		Retention:      db.opts.Retention,
+		History:        t.History(),
+	}
+
+	db.catalog.mu.Lock()
+	defer db.catalog.mu.Unlock()
+
+	db.catalog.Tables[entry.Schema.Name] = entry
+
+	if err := db.catalog.save(); err != nil {
+		return fmt.Errorf("failed to save catalog: %v", err)
+	}
+
+	return nil
+}
+
+// AlterTable applies schema changes to an existing table. Blocks already written keep
+// their schema version and are read through it: added columns read their default,
+// dropped columns are ignored and widened columns are converted on the fly.
+func (db *DB) AlterTable(name string, changes ...schema.Change) (schema.Schema, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	tbl, ok := db.tables[name]
+	if !ok {
+		return schema.Schema{}, fmt.Errorf("table %s not found", name)
+	}
+
+	next, err := tbl.PlanAlter(changes...)
+	if err != nil {
+		return schema.Schema{}, err
+	}
+
+	// the catalog learns the new version before any block or WAL record uses it, a
+	// crash in between leaves rows of the old version that upgrade on replay
+	history := append(tbl.History(), tbl.Schema())
+
+	db.catalog.mu.Lock()
+	prev := db.catalog.Tables[name]
+	entry := prev
+	entry.Schema = next
+	entry.History = history
+	db.catalog.Tables[name] = entry
+
+	if err := db.catalog.save(); err != nil {
+		db.catalog.Tables[name] = prev
+		db.catalog.mu.Unlock()
+		return schema.Schema{}, fmt.Errorf("failed to save catalog: %v", err)
+	}
+	db.catalog.mu.Unlock()
+
+	// Alter may flush blocks, which reports partition changes to the catalog
+	if err := tbl.Alter(next); err != nil {
+		db.catalog.mu.Lock()
+		defer db.catalog.mu.Unlock()
+
+		// partitions may have changed meanwhile, only the schema is rolled back
+		entry := db.catalog.Tables[name]
+		entry.Schema, entry.History = prev.Schema, prev.History
+		db.catalog.Tables[name] = entry
+		if saveErr := db.catalog.save(); saveErr != nil {
+			return schema.Schema{}, fmt.Errorf("failed to alter table %s: %v (restoring catalog: %v)", name, err, saveErr)
+		}
+		return schema.Schema{}, fmt.Errorf("failed to alter table %s: %v", name, err)
+	}
+
+	return next, nil
+}
+
+// DropTable removes the table name with all its data. It refuses while readers hold
+// the table. The directory is moved aside before the catalog forgets the table and
+// removed after, Open finishes a drop a crash interrupted. When the move or the catalog
+// fails the table is reopened, look it up again.
+func (db *DB) DropTable(name string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	tbl, ok := db.tables[name]
+	if !ok {
+		return fmt.Errorf("table %s not found", name)
+	}
+
+	if err := tbl.Detach(false); err != nil {
+		return fmt.Errorf("failed to drop table %s: %v", name, err)
+	}
+
+	trash := trashPath(db.dir, droppedPrefix, name)
+	if err := moveDir(db.dir, db.tablePath(name), trash); err != nil {
+		return db.reopen(name, fmt.Errorf("failed to drop table %s: %v", name, err))
+	}
+
+	db.catalog.mu.Lock()
+	entry := db.catalog.Tables[name]
+	delete(db.catalog.Tables, name)
+	err := db.catalog.save()
+	if err != nil {
+		db.catalog.Tables[name] = entry
+	}
+	db.catalog.mu.Unlock()
+	if err != nil {
+		// Open would finish the drop of a directory left in the trash
+		err = fmt.Errorf("failed to save catalog: %v", err)
+		if moveErr := moveDir(db.dir, trash, db.tablePath(name)); moveErr != nil {
+			return fmt.Errorf("%v (moving table %s back: %v)", err, name, moveErr)
+		}
+		return db.reopen(name, err)
+	}
+
+	delete(db.tables, name)
+	delete(db.recovery, name)
+
+	if err := os.RemoveAll(trash); err != nil {
+		return fmt.Errorf("table %s dropped but its files were not removed: %v", name, err)
+	}
+	return nil
+}
+
+// TruncateTable deletes every row of the table name and keeps its schema and settings.
+// It refuses while readers hold the table. The table returned by Table and OpenTable
+// before the truncate is detached, look it up again. When the move or the catalog fails
+// the table is reopened with its rows.
+func (db *DB) TruncateTable(name string) (*table.Table, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	tbl, ok := db.tables[name]
+	if !ok {
+		return nil, fmt.Errorf("table %s not found", name)
+	}
+
+	if err := tbl.Detach(false); err != nil {
+		return nil, fmt.Errorf("failed to truncate table %s: %v", name, err)
+	}
+
+	trash := trashPath(db.dir, truncatedPrefix, name)
+	if err := moveDir(db.dir, db.tablePath(name), trash); err != nil {
+		return nil, db.reopen(name, fmt.Errorf("failed to truncate table %s: %v", name, err))
+	}
+
+	db.catalog.mu.Lock()
+	prev := db.catalog.Tables[name]
+	entry := prev
+	entry.Partitions = nil
+	db.catalog.Tables[name] = entry
+	err := db.catalog.save()
+	if err != nil {
+		db.catalog.Tables[name] = prev
+	}
+	db.catalog.mu.Unlock()
+	if err != nil {
+		err = fmt.Errorf("failed to save catalog: %v", err)
+		if moveErr := moveDir(db.dir, trash, db.tablePath(name)); moveErr != nil {
+			// the table opens empty without its directory, Open removes the trash
+			err = fmt.Errorf("%v (moving table %s back: %v)", err, name, moveErr)
+		}
+		return nil, db.reopen(name, err)
+	}
+
+	// once the catalog forgot the partitions the truncate stands, a table that fails to
+	// open leaves db.tables like it does in reopen, Open removes the trash
+	delete(db.recovery, name)
+	t, err := db.openEntry(entry)
+	if err != nil {
+		delete(db.tables, name)
+		return nil, fmt.Errorf("failed to reopen truncated table %s: %v", name, err)
+	}
+	db.tables[name] = t
+
+	if err := os.RemoveAll(trash); err != nil {
+		return t, fmt.Errorf("table %s truncated but its old files were not removed: %v", name, err)
+	}
+	return t, nil
+}
+
+// RenameTable renames the table from to, moving its directory and its catalog entry.
+// It refuses while readers hold the table and when to is taken. Rows still in memory
+// are flushed first. The catalog records the new name before the directory moves, Open
+// finishes a move a crash interrupted. When the rename fails before the directory moved
+// the table is reopened under its old name, look it up again.
+func (db *DB) RenameTable(from, to string) (*table.Table, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	tbl, ok := db.tables[from]
+	if !ok {
+		return nil, fmt.Errorf("table %s not found", from)
+	}
+
+	next := tbl.Schema()
+	next.Name = to
+	if err := next.Validate(); err != nil {
+		return nil, err
+	}
+	if _, ok := db.tables[to]; ok {
+		return nil, fmt.Errorf("table %s already exists", to)
+	}
+	// a table written before the catalog existed is only known by its directory
+	if _, err := os.Stat(db.tablePath(to)); !os.IsNotExist(err) {
+		return nil, fmt.Errorf("directory of table %s already exists", to)
+	}
+
+	if err := tbl.Detach(true); err != nil {
+		return nil, fmt.Errorf("failed to rename table %s: %v", from, err)
+	}
+	// read before the catalog is locked, see catalog.mu
+	parts := tbl.Partitions()
+
+	db.catalog.mu.Lock()
+	prev := db.catalog.Tables[from]
+	entry := prev
+	entry.Schema = next
+	entry.Partitions = parts
+	entry.Dir = from
+	delete(db.catalog.Tables, from)
+	db.catalog.Tables[to] = entry
+	err := db.catalog.save()
+	if err != nil {
+		delete(db.catalog.Tables, to)
+		db.catalog.Tables[from] = prev
+	}
+	db.catalog.mu.Unlock()
+	if err != nil {
+		return nil, db.reopen(from, fmt.Errorf("failed to save catalog: %v", err))
+	}
+
+	if err := moveDir(db.dir, db.tablePath(from), db.tablePath(to)); err != nil {
+		err = fmt.Errorf("failed to move table %s: %v", from, err)
+
+		// the directory is still where it was, so is the table once the catalog agrees
+		db.catalog.mu.Lock()
+		delete(db.catalog.Tables, to)
+		db.catalog.Tables[from] = prev
+		saveErr := db.catalog.save()
+		db.catalog.mu.Unlock()
+		if saveErr != nil {
+			err = fmt.Errorf("%v (restoring catalog: %v)", err, saveErr)
+		}
+		return nil, db.reopen(from, err)
+	}
+	delete(db.tables, from)
+	delete(db.recovery, from)
+
+	// the directory has moved, a failed save leaves Dir behind for Open to settle and the
+	// table is opened under its new name either way
+	db.catalog.mu.Lock()
+	entry.Dir = ""
+	db.catalog.Tables[to] = entry
+	saveErr := db.catalog.save()
+	db.catalog.mu.Unlock()
+
+	t, err := db.openEntry(entry)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reopen renamed table %s: %v", to, err)
+	}
+	db.tables[to] = t
+
+	if saveErr != nil {
+		return t, fmt.Errorf("table %s renamed but the catalog was not saved: %v", from, saveErr)
+	}
+	return t, nil
+}
+
+// reopen puts the table name back in service after a drop or rename detached it and
+// then failed with err. It returns err, with the reason when the table cannot reopen.
+func (db *DB) reopen(name string, err error) error {
+	db.catalog.mu.Lock()
+	entry := db.catalog.Tables[name]
+	db.catalog.mu.Unlock()
+
+	t, openErr := db.openEntry(entry)
+	if openErr != nil {
+		delete(db.tables, name)
+		delete(db.recovery, name)
+		return fmt.Errorf("%v (reopening table %s: %v)", err, name, openErr)
+	}
+	db.tables[name] = t
+	return err
+}
+
+// moveDir renames the directory from to to and syncs parent, a table that never wrote
+// anything has no directory to move.
+func moveDir(parent, from, to string) error {
+	if err := os.Rename(from, to); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return syncDir(parent)
+}
+
+// SetRetention replaces the retention policy of the table name and records it in the
+// catalog, the zero policy keeps every row again.
+func (db *DB) SetRetention(name string, p table.RetentionPolicy) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	tbl, ok := db.tables[name]
+	if !ok {
+		return fmt.Errorf("table %s not found", name)
+	}
+
+	if err := tbl.StartRetention(p); err != nil {
+		return err
+	}
+
+	db.catalog.mu.Lock()
+	defer db.catalog.mu.Unlock()
+
+	entry := db.catalog.Tables[name]
+	entry.Retention = p
+	db.catalog.Tables[name] = entry
+
+	if err := db.catalog.save(); err != nil {
+		return fmt.Errorf("failed to save catalog: %v", err)
+	}
+	return nil
+}
+
+// Recovery returns what WAL replay found when the table was opened, including the size
+// of a torn tail that was discarded. ok is false when the table had no WAL to replay.
+func (db *DB) Recovery(name string) (wal.ReplayResult, bool) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	result, ok := db.recovery[name]
+	return result, ok
+}
+
+func (db *DB) Table(name string) (*table.Table, bool) {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 
@@ -111,10 +742,38 @@ func (db *DB) ListAllTables() []string {
 }
 
 func (db *DB) Close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
 	for _, tbl := range db.tables {
 		if err := tbl.Close(); err != nil {
 			return err
 		}
+
+		// MaxBlockSize, UseDiskStorage, AllowOutOfOrder and Partitioning are plain
+		// fields callers set after CreateTable, so the catalog picks up their final
+		// values here. They are read before the catalog is locked, see catalog.mu
+		name, parts := tbl.Schema().Name, tbl.Partitions()
+		maxBlockSize, useDisk := tbl.MaxBlockSize, tbl.UseDiskStorage
+		outOfOrder, partitioning := tbl.AllowOutOfOrder, tbl.Partitioning
+
+		db.catalog.mu.Lock()
+		entry := db.catalog.Tables[name]
+		entry.MaxBlockSize = maxBlockSize
+		entry.UseDiskStorage = useDisk
+		entry.OutOfOrder = outOfOrder
+		entry.Partitioning = partitioning
+		entry.Partitions = parts
+		db.catalog.Tables[name] = entry
+		db.catalog.mu.Unlock()
 	}
-	return nil
+
+	db.catalog.mu.Lock()
+	defer db.catalog.mu.Unlock()
+
+	if len(db.catalog.Tables) == 0 {
+		return nil
+	}
+
+	return db.catalog.save()
 }
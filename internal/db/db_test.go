@@ -2,18 +2,22 @@ package db
 
 import (
 	"backtraceDB/internal/schema"
+	"backtraceDB/internal/table"
+	"backtraceDB/internal/wal"
 	"fmt"
 	"os"
 	"path/filepath"
+	"runtime"
 	"testing"
+	"time"
 )
 
 func TestDBWorkFlow(t *testing.T) {
 	dbName := "workflow_test"
-	defer os.RemoveAll(filepath.Join("_data_internal", dbName))
+	root := t.TempDir()
 
 	// 1. Open DB
-	database, err := Open(dbName)
+	database, err := Open(dbName, Options{Root: root})
 	if err != nil {
 		t.Fatalf("Failed to open DB: %v", err)
 	}
@@ -57,7 +61,7 @@ func TestDBWorkFlow(t *testing.T) {
 func TestRecoveryWorkflow(t *testing.T) {
 	dbName := "recovery_test"
 	tableName := "metrics"
-	defer os.RemoveAll(filepath.Join("_data_internal", dbName))
+	root := t.TempDir()
 
 	s := schema.Schema{
 		Name:       tableName,
@@ -70,7 +74,7 @@ func TestRecoveryWorkflow(t *testing.T) {
 
 	// PHASE 1: Write data and "close"
 	{
-		database, err := Open(dbName)
+		database, err := Open(dbName, Options{Root: root})
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -90,7 +94,7 @@ func TestRecoveryWorkflow(t *testing.T) {
 
 	// PHASE 2: Open and Recover
 	{
-		database, err := Open(dbName)
+		database, err := Open(dbName, Options{Root: root})
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -107,15 +111,177 @@ func TestRecoveryWorkflow(t *testing.T) {
 	}
 }
 
+func TestTornWALRecovery(t *testing.T) {
+	dbName := "torn_wal_test"
+	root := t.TempDir()
+
+	s := schema.Schema{
+		Name:       "metrics",
+		TimeColumn: "timestamp",
+		Columns: []schema.Column{
+			{Name: "timestamp", Type: schema.Int64},
+			{Name: "value", Type: schema.Float64},
+		},
+	}
+
+	database, err := Open(dbName, Options{Root: root, UseDiskStorage: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tbl, err := database.CreateTable(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := tbl.AppendRow(map[string]any{"timestamp": int64(i), "value": float64(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// simulate a crash in the middle of writing the last record
+	walFile := filepath.Join(root, dbName, "metrics", "wal", "00000000000000000001.wal")
+	data, err := os.ReadFile(walFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(walFile, data[:len(data)-3], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := Open(dbName, Options{Root: root})
+	if err != nil {
+		t.Fatalf("a torn WAL tail should not stop the database from opening: %v", err)
+	}
+
+	recovered, _ := reopened.Table("metrics")
+	if recovered.RowCount() != 2 {
+		t.Errorf("expected the 2 complete rows to be recovered, got %d", recovered.RowCount())
+	}
+
+	result, ok := reopened.Recovery("metrics")
+	if !ok {
+		t.Fatal("expected a recovery result for metrics")
+	}
+	if result.Rows != 2 || result.Discarded != int64(len(data)/3-3) {
+		t.Errorf("expected 2 rows and %d discarded bytes, got %+v", len(data)/3-3, result)
+	}
+}
+
+func TestCrashRecovery(t *testing.T) {
+	s := schema.Schema{
+		Name:       "metrics",
+		TimeColumn: "timestamp",
+		Columns: []schema.Column{
+			{Name: "timestamp", Type: schema.Int64},
+			{Name: "value", Type: schema.Float64},
+		},
+	}
+
+	setup := func(t *testing.T, rows int) (string, *DB) {
+		root := t.TempDir()
+		database, err := Open("crash_test", Options{Root: root, UseDiskStorage: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		tbl, err := database.CreateTable(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < rows; i++ {
+			if err := tbl.AppendRow(map[string]any{"timestamp": int64(i), "value": float64(i)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return root, database
+	}
+
+	rowCount := func(t *testing.T, root string) int {
+		t.Helper()
+		database, err := Open("crash_test", Options{Root: root})
+		if err != nil {
+			t.Fatal(err)
+		}
+		tbl, _ := database.Table("metrics")
+		return tbl.RowCount()
+	}
+
+	t.Run("FlushBeforeCheckpoint", func(t *testing.T) {
+		root, database := setup(t, 3)
+
+		segment := filepath.Join(root, "crash_test", "metrics", "wal", "00000000000000000001.wal")
+		data, err := os.ReadFile(segment)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Close flushes the 3 rows and checkpoints them away, putting the segment back
+		// is a crash right after the block was written
+		if err := database.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(segment, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		if n := rowCount(t, root); n != 3 {
+			t.Errorf("expected the 3 flushed rows without duplicates, got %d", n)
+		}
+	})
+
+	t.Run("CrashDuringFlush", func(t *testing.T) {
+		root, _ := setup(t, 3)
+
+		// a flush cut short before its rename leaves only the temp file
+		stray := filepath.Join(root, "crash_test", "metrics", "Ts2R3i0.parquet.tmp")
+		if err := os.WriteFile(stray, []byte("PAR1 half a block"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		if n := rowCount(t, root); n != 3 {
+			t.Errorf("expected the 3 rows from the WAL, got %d", n)
+		}
+		if _, err := os.Stat(stray); !os.IsNotExist(err) {
+			t.Errorf("expected the stray temp file to be removed, got %v", err)
+		}
+	})
+
+	t.Run("DamagedBlock", func(t *testing.T) {
+		root, database := setup(t, 3)
+		if err := database.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		blocks, _ := filepath.Glob(filepath.Join(root, "crash_test", "metrics", "*.parquet"))
+		if len(blocks) != 1 {
+			t.Fatalf("expected 1 block, got %v", blocks)
+		}
+		if err := os.Truncate(blocks[0], 10); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := Open("crash_test", Options{Root: root}); err == nil {
+			t.Error("expected a damaged block to stop the table from opening")
+		}
+	})
+
+	t.Run("ReplayedRowsSurvive", func(t *testing.T) {
+		root, _ := setup(t, 2)
+
+		// two crashes in a row, the rows replayed after the first one are still only
+		// in the WAL when the second one hits
+		if n := rowCount(t, root); n != 2 {
+			t.Errorf("expected 2 rows after the first crash, got %d", n)
+		}
+		if n := rowCount(t, root); n != 2 {
+			t.Errorf("expected 2 rows after the second crash, got %d", n)
+		}
+	})
+}
+
 func TestFullPersistenceAndRecovery(t *testing.T) {
 	dbName := "full_recovery_test"
 	tableName := "sensor_data"
-	dataRoot := "_data_internal"
-
-	// Clean up only the specific test database
-	testDbPath := filepath.Join(dataRoot, dbName)
-	os.RemoveAll(testDbPath)
-	defer os.RemoveAll(testDbPath)
+	dataRoot := t.TempDir()
 
 	s := schema.Schema{
 		Name:       tableName,
@@ -130,7 +296,7 @@ func TestFullPersistenceAndRecovery(t *testing.T) {
 	// PHASE 1: Write data and trigger multiple blocks
 	expectedRows := 12
 	{
-		database, err := Open(dbName)
+		database, err := Open(dbName, Options{Root: dataRoot})
 		if err != nil {
 			t.Fatalf("Phase 1: Failed to open DB: %v", err)
 		}
@@ -186,7 +352,7 @@ func TestFullPersistenceAndRecovery(t *testing.T) {
 
 	// PHASE 3: Recovery
 	{
-		database, err := Open(dbName)
+		database, err := Open(dbName, Options{Root: dataRoot})
 		if err != nil {
 			t.Fatalf("Phase 3: Failed to open DB: %v", err)
 		}
@@ -228,3 +394,1024 @@ func TestFullPersistenceAndRecovery(t *testing.T) {
 		}
 	}
 }
+
+func TestCatalogDiscovery(t *testing.T) {
+	dbName := "catalog_test"
+	root := t.TempDir()
+
+	trades := schema.Schema{
+		Name:       "trades",
+		TimeColumn: "ts",
+		Columns: []schema.Column{
+			{Name: "ts", Type: schema.Int64},
+			{Name: "symbol", Type: schema.String},
+			{Name: "price", Type: schema.Float64},
+			{Name: "is_buy", Type: schema.Boolean},
+		},
+	}
+
+	quotes := schema.Schema{
+		Name:       "quotes",
+		TimeColumn: "ts",
+		Columns: []schema.Column{
+			{Name: "ts", Type: schema.Int64},
+			{Name: "bid", Type: schema.Float64},
+		},
+	}
+
+	// PHASE 1: create two tables and write a few rows
+	{
+		database, err := Open(dbName, Options{Root: root})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tradesTbl, err := database.CreateTable(trades)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tradesTbl.MaxBlockSize = 3
+		tradesTbl.UseDiskStorage = true
+
+		for i := 0; i < 5; i++ {
+			row := map[string]any{"ts": int64(i), "symbol": "BTC", "price": float64(i), "is_buy": i%2 == 0}
+			if err := tradesTbl.AppendRow(row); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if _, err := database.CreateTable(quotes); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := database.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// PHASE 2: reopen without supplying any schema
+	database, err := Open(dbName, Options{Root: root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+
+	if names := database.ListAllTables(); len(names) != 2 {
+		t.Fatalf("expected 2 discovered tables, got %v", names)
+	}
+
+	tbl, ok := database.Table("trades")
+	if !ok {
+		t.Fatal("expected trades to be discovered from the catalog")
+	}
+
+	if tbl.RowCount() != 5 {
+		t.Errorf("expected 5 rows in trades, got %d", tbl.RowCount())
+	}
+	if tbl.MaxBlockSize != 3 {
+		t.Errorf("expected MaxBlockSize 3 from the catalog, got %d", tbl.MaxBlockSize)
+	}
+	if err := trades.Matches(tbl.Schema()); err != nil {
+		t.Errorf("discovered schema differs from the created one: %v", err)
+	}
+
+	if _, ok := database.Table("missing"); ok {
+		t.Error("did not expect a table named missing")
+	}
+
+	t.Run("CreateExisting", func(t *testing.T) {
+		if _, err := database.CreateTable(quotes); err == nil {
+			t.Error("expected an error creating a table that is already in the catalog")
+		}
+	})
+
+	t.Run("OpenMatching", func(t *testing.T) {
+		opened, err := database.OpenTable(trades)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if opened != tbl {
+			t.Error("expected OpenTable to return the discovered table")
+		}
+	})
+
+	t.Run("OpenMismatch", func(t *testing.T) {
+		swapped := schema.Schema{
+			Name:       "trades",
+			TimeColumn: "ts",
+			Columns: []schema.Column{
+				{Name: "ts", Type: schema.Int64},
+				{Name: "symbol", Type: schema.String},
+				{Name: "price", Type: schema.Int64},
+				{Name: "is_buy", Type: schema.Boolean},
+			},
+		}
+
+		if _, err := database.OpenTable(swapped); err == nil {
+			t.Error("expected a schema mismatch error")
+		}
+	})
+}
+
+func TestSyncOptions(t *testing.T) {
+	root := t.TempDir()
+	always := wal.SyncPolicy{Mode: wal.SyncAlways}
+	group := wal.SyncPolicy{Mode: wal.SyncGroupCommit}
+
+	opts := Options{
+		Root:           root,
+		UseDiskStorage: true,
+		Sync:           group,
+		TableSync:      map[string]wal.SyncPolicy{"orders": always},
+	}
+
+	database, err := Open("sync_test", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"orders", "quotes"} {
+		s := schema.Schema{
+			Name:       name,
+			TimeColumn: "ts",
+			Columns:    []schema.Column{{Name: "ts", Type: schema.Int64}},
+		}
+		tbl, err := database.CreateTable(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tbl.AppendRow(map[string]any{"ts": int64(1)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	orders, _ := database.Table("orders")
+	quotes, _ := database.Table("quotes")
+	if orders.SyncPolicy != always || quotes.SyncPolicy != group {
+		t.Errorf("expected orders %v and quotes %v, got %v and %v", always.Mode, group.Mode, orders.SyncPolicy.Mode, quotes.SyncPolicy.Mode)
+	}
+
+	// reopening applies the options again to the WAL found on disk
+	reopened, err := Open("sync_test", Options{Root: root, Sync: always})
+	if err != nil {
+		t.Fatal(err)
+	}
+	quotes, _ = reopened.Table("quotes")
+	if quotes.SyncPolicy != always || quotes.RowCount() != 1 {
+		t.Errorf("expected the reopened table to use %v and hold 1 row, got %v and %d", always.Mode, quotes.SyncPolicy.Mode, quotes.RowCount())
+	}
+}
+
+func TestCloseStopsWAL(t *testing.T) {
+	root := t.TempDir()
+	opts := Options{
+		Root:           root,
+		UseDiskStorage: true,
+		Sync:           wal.SyncPolicy{Mode: wal.SyncInterval, Interval: time.Millisecond},
+	}
+	s := schema.Schema{
+		Name:       "ticks",
+		TimeColumn: "ts",
+		Columns:    []schema.Column{{Name: "ts", Type: schema.Int64}},
+	}
+
+	cycle := func(i int) {
+		database, err := Open("close_test", opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tbl, ok := database.Table("ticks")
+		if !ok {
+			if tbl, err = database.CreateTable(s); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := tbl.AppendRow(map[string]any{"ts": int64(i)}); err != nil {
+			t.Fatal(err)
+		}
+		if err := database.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cycle(0)
+	before := runtime.NumGoroutine()
+	for i := 1; i <= 5; i++ {
+		cycle(i)
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("expected closing to stop the WAL syncer, goroutines went from %d to %d", before, after)
+	}
+
+	database, err := Open("close_test", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+	if tbl, _ := database.Table("ticks"); tbl.RowCount() != 6 {
+		t.Errorf("expected 6 rows after reopening, got %d", tbl.RowCount())
+	}
+}
+
+func TestFailedOpenClosesTables(t *testing.T) {
+	root := t.TempDir()
+	opts := Options{
+		Root:           root,
+		UseDiskStorage: true,
+		Sync:           wal.SyncPolicy{Mode: wal.SyncInterval, Interval: time.Millisecond},
+	}
+
+	database, err := Open("failed_open", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a", "b", "c", "d", "bad"} {
+		tbl, err := database.CreateTable(schema.Schema{
+			Name:       name,
+			TimeColumn: "ts",
+			Columns:    []schema.Column{{Name: "ts", Type: schema.Int64}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tbl.AppendRow(map[string]any{"ts": int64(1)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := database.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a file where the table directory should be cannot be opened
+	bad := filepath.Join(root, "failed_open", "bad")
+	if err := os.RemoveAll(bad); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(bad, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	before := runtime.NumGoroutine()
+	for i := 0; i < 5; i++ {
+		if _, err := Open("failed_open", opts); err == nil {
+			t.Fatal("expected opening to fail")
+		}
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("expected the tables opened before the failure to be closed, goroutines went from %d to %d", before, after)
+	}
+}
+
+func TestCompactionOption(t *testing.T) {
+	root := t.TempDir()
+	s := schema.Schema{
+		Name:       "ticks",
+		TimeColumn: "ts",
+		Columns:    []schema.Column{{Name: "ts", Type: schema.Int64}},
+	}
+
+	bad, err := Open("compaction_test", Options{Root: root, Compaction: table.CompactionPolicy{MinBlocks: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bad.CreateTable(s); err == nil {
+		t.Fatal("expected an invalid compaction policy to be rejected")
+	}
+
+	opts := Options{
+		Root:           root,
+		MaxBlockSize:   2,
+		UseDiskStorage: true,
+		Compaction:     table.CompactionPolicy{Interval: 5 * time.Millisecond, TargetRows: 6},
+	}
+	database, err := Open("compaction_test", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tbl, err := database.CreateTable(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 6; i++ {
+		if err := tbl.AppendRow(map[string]any{"ts": int64(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	blocks := func() int {
+		files, _ := filepath.Glob(filepath.Join(root, "compaction_test", "ticks", "*.parquet"))
+		return len(files)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for blocks() != 1 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := blocks(); n != 1 {
+		t.Fatalf("expected 3 blocks to be compacted into 1, found %d", n)
+	}
+
+	if err := database.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := Open("compaction_test", Options{Root: root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tbl, _ = reopened.Table("ticks")
+	if tbl.RowCount() != 6 {
+		t.Errorf("expected 6 rows after reopening, got %d", tbl.RowCount())
+	}
+}
+
+func TestOutOfOrderOption(t *testing.T) {
+	root := t.TempDir()
+	s := schema.Schema{
+		Name:       "fills",
+		TimeColumn: "ts",
+		Columns:    []schema.Column{{Name: "ts", Type: schema.Int64}},
+	}
+
+	database, err := Open("ooo_test", Options{Root: root, UseDiskStorage: true, AllowOutOfOrder: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tbl, err := database.CreateTable(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ts := range []int64{30, 10, 20} {
+		if err := tbl.AppendRow(map[string]any{"ts": ts}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// no Close, the rows come back from the WAL in the order they were written
+	reopened, err := Open("ooo_test", Options{Root: root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tbl, _ = reopened.Table("fills")
+	if !tbl.AllowOutOfOrder {
+		t.Fatal("expected the catalog to keep the table accepting rows out of order")
+	}
+	if err := tbl.AppendRow(map[string]any{"ts": int64(0)}); err != nil {
+		t.Fatal(err)
+	}
+
+	var times []int64
+	r := tbl.Reader().OrderByTime()
+	for {
+		row, ok := r.Next()
+		if !ok {
+			break
+		}
+		times = append(times, row["ts"].(int64))
+	}
+	if r.Err() != nil || fmt.Sprint(times) != "[0 10 20 30]" {
+		t.Errorf("expected [0 10 20 30], got %v (%v)", times, r.Err())
+	}
+}
+
+func TestPartitioningOption(t *testing.T) {
+	root := t.TempDir()
+	s := schema.Schema{
+		Name:       "ticks",
+		TimeColumn: "ts",
+		Columns:    []schema.Column{{Name: "ts", Type: schema.Int64}},
+	}
+	day := int64(24 * 60 * 60)
+
+	opts := Options{
+		Root:           root,
+		MaxBlockSize:   2,
+		UseDiskStorage: true,
+		Partitioning:   table.Partitioning{By: table.PartitionDay, Unit: time.Second},
+	}
+	database, err := Open("partition_test", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tbl, err := database.CreateTable(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// two blocks in day 0 and day 1, the rest stays in the active block
+	for _, ts := range []int64{0, 10, day, day + 10, day + 20} {
+		if err := tbl.AppendRow(map[string]any{"ts": ts}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// flushing into a new partition records it in the catalog right away
+	c, err := loadCatalog(filepath.Join(root, "partition_test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parts := c.Tables["ticks"].Partitions; len(parts) != 2 || parts[1].Name != "1970-01-02" || parts[1].Start != day {
+		t.Errorf("expected day 0 and day 1 in the catalog, got %v", parts)
+	}
+
+	if err := database.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := Open("partition_test", Options{Root: root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tbl, _ = reopened.Table("ticks")
+	if tbl.Partitioning != opts.Partitioning {
+		t.Errorf("expected the catalog to keep the partitioning, got %+v", tbl.Partitioning)
+	}
+	if parts := tbl.Partitions(); len(parts) != 2 || parts[1].End != 2*day {
+		t.Errorf("expected 2 partitions of a day after reopening, got %v", parts)
+	}
+
+	if err := tbl.DropPartition("1970-01-01"); err != nil {
+		t.Fatal(err)
+	}
+	c, err = loadCatalog(filepath.Join(root, "partition_test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parts := c.Tables["ticks"].Partitions; len(parts) != 1 || parts[0].Name != "1970-01-02" {
+		t.Errorf("expected the dropped partition to leave the catalog, got %v", parts)
+	}
+	if tbl.RowCount() != 3 {
+		t.Errorf("expected the 3 rows of day 1 to remain, got %d", tbl.RowCount())
+	}
+
+	if err := reopened.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	bad, err := Open("partition_bad", Options{Root: root, Partitioning: table.Partitioning{By: 9}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bad.CreateTable(s); err == nil {
+		t.Error("expected an unknown granularity to be rejected")
+	}
+}
+
+func TestRetentionOption(t *testing.T) {
+	root := t.TempDir()
+	s := schema.Schema{
+		Name:       "ticks",
+		TimeColumn: "ts",
+		Columns:    []schema.Column{{Name: "ts", Type: schema.Int64}},
+	}
+
+	keep := table.RetentionPolicy{Keep: 90 * 24 * time.Hour, Unit: time.Second, Interval: 5 * time.Millisecond}
+	database, err := Open("retention_test", Options{Root: root, MaxBlockSize: 2, UseDiskStorage: true, Retention: keep})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tbl, err := database.CreateTable(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// one flushed block from 100 days ago, one from now and a row in the active block
+	now := time.Now().Unix()
+	old := now - 100*24*60*60
+	for _, ts := range []int64{old, old + 1, now, now + 1, now + 2} {
+		if err := tbl.AppendRow(map[string]any{"ts": ts}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for tbl.RowCount() != 3 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := tbl.RowCount(); n != 3 {
+		t.Fatalf("expected the 2 old rows to expire, %d rows left", n)
+	}
+
+	if err := database.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := Open("retention_test", Options{Root: root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+
+	if entry := reopened.catalog.Tables["ticks"]; entry.Retention != keep {
+		t.Errorf("expected the catalog to keep the retention, got %+v", entry.Retention)
+	}
+	if err := reopened.SetRetention("ticks", table.RetentionPolicy{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := reopened.SetRetention("missing", keep); err == nil {
+		t.Error("expected an unknown table to be rejected")
+	}
+
+	c, err := loadCatalog(filepath.Join(root, "retention_test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := c.Tables["ticks"].Retention; r != (table.RetentionPolicy{}) {
+		t.Errorf("expected the retention to be cleared, got %+v", r)
+	}
+}
+
+func TestDropTruncateRename(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "manage_test")
+	s := schema.Schema{
+		Name:       "ticks",
+		TimeColumn: "ts",
+		Columns:    []schema.Column{{Name: "ts", Type: schema.Int64}},
+	}
+
+	count := func(t *testing.T, tbl *table.Table) int {
+		t.Helper()
+		n := 0
+		r := tbl.Reader()
+		for {
+			if _, ok := r.Next(); !ok {
+				break
+			}
+			n++
+		}
+		if err := r.Err(); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	database, err := Open("manage_test", Options{Root: root, MaxBlockSize: 2, UseDiskStorage: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tbl, err := database.CreateTable(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := tbl.AppendRow(map[string]any{"ts": int64(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("RefusesReaders", func(t *testing.T) {
+		r := tbl.Reader()
+		if err := database.DropTable("ticks"); err == nil {
+			t.Fatal("expected a drop to wait for the open reader")
+		}
+		if _, err := database.RenameTable("ticks", "quotes"); err == nil {
+			t.Fatal("expected a rename to wait for the open reader")
+		}
+		r.Close()
+
+		// the table is untouched
+		if err := tbl.AppendRow(map[string]any{"ts": int64(5)}); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	renamed, err := database.RenameTable("ticks", "quotes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Run("Renamed", func(t *testing.T) {
+		if _, ok := database.Table("ticks"); ok {
+			t.Error("expected the old name to be gone")
+		}
+		if err := tbl.AppendRow(map[string]any{"ts": int64(6)}); err == nil {
+			t.Error("expected the detached table to refuse appends")
+		}
+		if renamed.Schema().Name != "quotes" || count(t, renamed) != 6 {
+			t.Errorf("expected quotes with 6 rows, got %s with %d", renamed.Schema().Name, count(t, renamed))
+		}
+		if _, err := os.Stat(filepath.Join(dir, "ticks")); !os.IsNotExist(err) {
+			t.Errorf("expected the old directory to be moved, got %v", err)
+		}
+		if _, err := database.RenameTable("quotes", "quotes"); err == nil {
+			t.Error("expected renaming onto an existing table to fail")
+		}
+	})
+
+	truncated, err := database.TruncateTable("quotes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Run("Truncated", func(t *testing.T) {
+		if truncated.RowCount() != 0 || truncated.Schema().Name != "quotes" || truncated.MaxBlockSize != 2 {
+			t.Errorf("expected an empty quotes table keeping its settings, got %d rows", truncated.RowCount())
+		}
+		for i := 10; i < 13; i++ {
+			if err := truncated.AppendRow(map[string]any{"ts": int64(i)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+
+	if err := database.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := Open("manage_test", Options{Root: root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	quotes, ok := reopened.Table("quotes")
+	if !ok {
+		t.Fatal("expected quotes after reopening")
+	}
+	if n := count(t, quotes); n != 3 {
+		t.Errorf("expected the 3 rows written after the truncate, got %d", n)
+	}
+
+	if err := reopened.DropTable("quotes"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "quotes")); !os.IsNotExist(err) {
+		t.Errorf("expected the table directory to be removed, got %v", err)
+	}
+	if err := reopened.DropTable("quotes"); err == nil {
+		t.Error("expected dropping a missing table to fail")
+	}
+	if err := reopened.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	again, err := Open("manage_test", Options{Root: root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer again.Close()
+	if tables := again.ListAllTables(); len(tables) != 0 {
+		t.Errorf("expected no tables left, got %v", tables)
+	}
+}
+
+func TestInterruptedDropAndRename(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "pending_test")
+
+	database, err := Open("pending_test", Options{Root: root, UseDiskStorage: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"dropped", "renamed"} {
+		tbl, err := database.CreateTable(schema.Schema{
+			Name:       name,
+			TimeColumn: "ts",
+			Columns:    []schema.Column{{Name: "ts", Type: schema.Int64}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tbl.AppendRow(map[string]any{"ts": int64(1)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := database.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a drop that moved the directory aside, a rename that only reached the catalog
+	if err := os.Rename(filepath.Join(dir, "dropped"), filepath.Join(dir, ".dropped-1-dropped")); err != nil {
+		t.Fatal(err)
+	}
+	c, err := loadCatalog(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := c.Tables["renamed"]
+	entry.Schema.Name = "moved"
+	entry.Dir = "renamed"
+	delete(c.Tables, "renamed")
+	c.Tables["moved"] = entry
+	if err := c.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := Open("pending_test", Options{Root: root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+
+	if _, ok := reopened.Table("dropped"); ok {
+		t.Error("expected the interrupted drop to be finished")
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".dropped-1-dropped")); !os.IsNotExist(err) {
+		t.Errorf("expected the moved aside directory to be removed, got %v", err)
+	}
+
+	moved, ok := reopened.Table("moved")
+	if !ok || moved.RowCount() != 1 {
+		t.Fatal("expected the renamed table with its row")
+	}
+	if entry := reopened.catalog.Tables["moved"]; entry.Dir != "" {
+		t.Errorf("expected the move to be recorded, still in %s", entry.Dir)
+	}
+}
+
+func TestFailedDropAndRename(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "failed_test")
+
+	database, err := Open("failed_test", Options{Root: root, UseDiskStorage: true, MaxBlockSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+
+	tbl, err := database.CreateTable(schema.Schema{
+		Name:       "quotes",
+		TimeColumn: "ts",
+		Columns:    []schema.Column{{Name: "ts", Type: schema.Int64}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := tbl.AppendRow(map[string]any{"ts": int64(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a directory in the way of the temporary file makes every catalog save fail
+	blocker := filepath.Join(dir, catalogFile+".tmp")
+	if err := os.Mkdir(blocker, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.catalog.setPartitions("quotes", nil); err == nil {
+		t.Error("expected a failed partition save to be reported")
+	}
+
+	// the table is back in service after either failure, with every row
+	usable := func(t *testing.T, rows int) {
+		t.Helper()
+		tbl, ok := database.Table("quotes")
+		if !ok {
+			t.Fatal("expected quotes to still be there")
+		}
+		if err := tbl.AppendRow(map[string]any{"ts": int64(rows)}); err != nil {
+			t.Fatal(err)
+		}
+		if n := tbl.RowCount(); n != rows+1 {
+			t.Errorf("expected %d rows, got %d", rows+1, n)
+		}
+	}
+
+	if err := database.DropTable("quotes"); err == nil {
+		t.Fatal("expected the drop to fail")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "quotes")); err != nil {
+		t.Errorf("expected the table directory to be moved back, got %v", err)
+	}
+	usable(t, 5)
+
+	if _, err := database.RenameTable("quotes", "trades"); err == nil {
+		t.Fatal("expected the rename to fail")
+	}
+	if _, ok := database.Table("trades"); ok {
+		t.Error("expected no trades table after the failed rename")
+	}
+	usable(t, 6)
+
+	if _, err := database.TruncateTable("quotes"); err == nil {
+		t.Fatal("expected the truncate to fail")
+	}
+	if trash, _ := filepath.Glob(filepath.Join(dir, truncatedPrefix+"*")); len(trash) != 0 {
+		t.Errorf("expected the table directory to be moved back, found %v", trash)
+	}
+	usable(t, 7)
+
+	if err := os.Remove(blocker); err != nil {
+		t.Fatal(err)
+	}
+	trades, err := database.RenameTable("quotes", "trades")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := trades.RowCount(); n != 8 {
+		t.Errorf("expected 8 rows after the rename, got %d", n)
+	}
+}
+
+func TestSeparateRoots(t *testing.T) {
+	dbName := "shared_name"
+	rootA := t.TempDir()
+	rootB := t.TempDir()
+
+	s := schema.Schema{
+		Name:       "ticks",
+		TimeColumn: "ts",
+		Columns: []schema.Column{
+			{Name: "ts", Type: schema.Int64},
+			{Name: "price", Type: schema.Float64},
+		},
+	}
+
+	opts := Options{Root: rootA, MaxBlockSize: 2, UseDiskStorage: true}
+
+	dbA, err := Open(dbName, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbB, err := Open(dbName, Options{Root: rootB})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tblA, err := dbA.CreateTable(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tblA.MaxBlockSize != 2 || !tblA.UseDiskStorage {
+		t.Errorf("expected table defaults from Options, got MaxBlockSize=%d UseDiskStorage=%v", tblA.MaxBlockSize, tblA.UseDiskStorage)
+	}
+
+	// the same table name in another root must not collide with the first one
+	tblB, err := dbB.CreateTable(s)
+	if err != nil {
+		t.Fatalf("expected no collision between roots: %v", err)
+	}
+
+	for i := 0; i < 5; i++ {
+		if err := tblA.AppendRow(map[string]any{"ts": int64(i), "price": float64(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tblB.AppendRow(map[string]any{"ts": int64(0), "price": 1.0}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := dbA.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := dbB.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, err := wal.Exists(filepath.Join(rootA, dbName, "ticks", "wal")); !ok {
+		t.Errorf("expected the WAL under the configured root: %v", err)
+	}
+
+	reopenedA, err := Open(dbName, Options{Root: rootA})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopenedA.Close()
+
+	reopenedB, err := Open(dbName, Options{Root: rootB})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopenedB.Close()
+
+	a, _ := reopenedA.Table("ticks")
+	b, _ := reopenedB.Table("ticks")
+	if a.RowCount() != 5 || b.RowCount() != 1 {
+		t.Errorf("expected 5 and 1 rows in the two roots, got %d and %d", a.RowCount(), b.RowCount())
+	}
+}
+
+func TestAlterTable(t *testing.T) {
+	dbName := "alter_test"
+	root := t.TempDir()
+
+	s := schema.Schema{
+		Name:       "fills",
+		TimeColumn: "ts",
+		Columns: []schema.Column{
+			{Name: "ts", Type: schema.Int64},
+			{Name: "price", Type: schema.Int64},
+			{Name: "qty", Type: schema.Int64},
+		},
+	}
+
+	database, err := Open(dbName, Options{Root: root, MaxBlockSize: 3, UseDiskStorage: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tbl, err := database.CreateTable(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 3 rows in a flushed block and 2 in the active block under version 0
+	for i := 0; i < 5; i++ {
+		if err := tbl.AppendRow(map[string]any{"ts": int64(i), "price": int64(i * 10), "qty": int64(1)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	next, err := database.AlterTable("fills",
+		schema.AddColumn(schema.Column{Name: "venue", Type: schema.String, Default: "NYSE"}),
+		schema.DropColumn("qty"),
+		schema.WidenColumn("price", schema.Float64),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.Version != 1 {
+		t.Errorf("expected schema version 1, got %d", next.Version)
+	}
+
+	for i := 5; i < 8; i++ {
+		if err := tbl.AppendRow(map[string]any{"ts": int64(i), "price": float64(i*10) + 0.5, "venue": "ARCA"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := tbl.AppendRow(map[string]any{"ts": int64(8), "price": 80.0, "qty": int64(1)}); err == nil {
+		t.Error("expected appending a dropped column to fail")
+	}
+
+	check := func(t *testing.T, tbl *table.Table) {
+		r := tbl.Reader()
+		count := 0
+		for {
+			row, ok := r.Next()
+			if !ok {
+				break
+			}
+			if _, ok := row["qty"]; ok {
+				t.Errorf("dropped column qty still returned at ts %v", row["ts"])
+			}
+			if _, ok := row["price"].(float64); !ok {
+				t.Errorf("expected a float64 price at ts %v, got %T", row["ts"], row["price"])
+			}
+			expectedVenue := "NYSE"
+			if row["ts"].(int64) >= 5 {
+				expectedVenue = "ARCA"
+			}
+			if row["venue"] != expectedVenue {
+				t.Errorf("expected venue %s at ts %v, got %v", expectedVenue, row["ts"], row["venue"])
+			}
+			count++
+		}
+		if count != 8 {
+			t.Errorf("expected 8 rows, got %d", count)
+		}
+
+		r = tbl.Reader().Filter("price", ">", 25.0)
+		count = 0
+		for {
+			if _, ok := r.Next(); !ok {
+				break
+			}
+			count++
+		}
+		if count != 5 {
+			t.Errorf("expected 5 rows with price > 25, got %d", count)
+		}
+
+		r = tbl.Reader().Filter("venue", "==", "NYSE")
+		count = 0
+		for {
+			if _, ok := r.Next(); !ok {
+				break
+			}
+			count++
+		}
+		if count != 5 {
+			t.Errorf("expected 5 NYSE rows from old blocks, got %d", count)
+		}
+	}
+
+	t.Run("Live", func(t *testing.T) {
+		check(t, tbl)
+	})
+
+	if err := database.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Reopened", func(t *testing.T) {
+		reopened, err := Open(dbName, Options{Root: root})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer reopened.Close()
+
+		tbl, ok := reopened.Table("fills")
+		if !ok {
+			t.Fatal("expected fills after reopen")
+		}
+		if tbl.Schema().Version != 1 || len(tbl.History()) != 1 {
+			t.Errorf("expected version 1 with one older version, got %d and %d", tbl.Schema().Version, len(tbl.History()))
+		}
+		check(t, tbl)
+
+		if _, err := reopened.AlterTable("fills", schema.AddColumn(schema.Column{Name: "qty", Type: schema.Int64})); err == nil {
+			t.Error("expected re-adding a dropped column to fail")
+		}
+		if _, err := reopened.AlterTable("fills", schema.WidenColumn("venue", schema.Int64)); err == nil {
+			t.Error("expected narrowing string to int64 to fail")
+		}
+	})
+}
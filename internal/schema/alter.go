@@ -0,0 +1,97 @@
+package schema
+
+import "fmt"
+
+type changeKind int
+
+const (
+	addColumn changeKind = iota
+	dropColumn
+	widenColumn
+)
+
+// Change is a single schema alteration, built with AddColumn, DropColumn or WidenColumn
+// and applied with Schema.Apply.
+type Change struct {
+	kind   changeKind
+	column Column
+}
+
+// AddColumn appends col to the schema. Rows written before the change read col.Default.
+func AddColumn(col Column) Change {
+	return Change{kind: addColumn, column: col}
+}
+
+// DropColumn removes the named column. Old blocks keep the data but it is never read again.
+func DropColumn(name string) Change {
+	return Change{kind: dropColumn, column: Column{Name: name}}
+}
+
+// WidenColumn changes the type of the named column to a type that can represent every
+// value of the old one. Only int64 to float64 is supported.
+func WidenColumn(name string, to ColumnType) Change {
+	return Change{kind: widenColumn, column: Column{Name: name, Type: to}}
+}
+
+func canWiden(from, to ColumnType) bool {
+	return from == Int64 && to == Float64
+}
+
+// Apply returns a copy of s with the changes applied in order and Version bumped by one.
+// s itself is left untouched.
+func (s Schema) Apply(changes ...Change) (Schema, error) {
+	if len(changes) == 0 {
+		return Schema{}, fmt.Errorf("no schema changes given")
+	}
+
+	next := s
+	next.Columns = make([]Column, len(s.Columns))
+	copy(next.Columns, s.Columns)
+	next.Version = s.Version + 1
+
+	for _, c := range changes {
+		idx := -1
+		for i, col := range next.Columns {
+			if col.Name == c.column.Name {
+				idx = i
+				break
+			}
+		}
+
+		switch c.kind {
+		case addColumn:
+			if idx != -1 {
+				return Schema{}, fmt.Errorf("column %s already exists", c.column.Name)
+			}
+			next.Columns = append(next.Columns, c.column)
+
+		case dropColumn:
+			if idx == -1 {
+				return Schema{}, fmt.Errorf("column %s not found", c.column.Name)
+			}
+			if c.column.Name == s.TimeColumn {
+				return Schema{}, fmt.Errorf("time column %s cannot be dropped", c.column.Name)
+			}
+			next.Columns = append(next.Columns[:idx], next.Columns[idx+1:]...)
+
+		case widenColumn:
+			if idx == -1 {
+				return Schema{}, fmt.Errorf("column %s not found", c.column.Name)
+			}
+			if c.column.Name == s.TimeColumn {
+				return Schema{}, fmt.Errorf("time column %s cannot change type", c.column.Name)
+			}
+			from := next.Columns[idx].Type
+			if !canWiden(from, c.column.Type) {
+				return Schema{}, fmt.Errorf("column %s cannot be widened from %s to %s", c.column.Name, from, c.column.Type)
+			}
+			next.Columns[idx].Type = c.column.Type
+		}
+	}
+
+	if err := next.Validate(); err != nil {
+		return Schema{}, err
+	}
+
+	return next, nil
+}
@@ -11,15 +11,132 @@ const (
 	Boolean
 )
 
+var columnTypeNames = map[ColumnType]string{
+	Int64:   "int64",
+	Float64: "float64",
+	String:  "string",
+	Boolean: "bool",
+}
+
+func (t ColumnType) String() string {
+	if name, ok := columnTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("ColumnType(%d)", int(t))
+}
+
+// types are stored by name in the catalog so reordering the constants can never
+// silently change the meaning of a table on disk
+func (t ColumnType) MarshalText() ([]byte, error) {
+	name, ok := columnTypeNames[t]
+	if !ok {
+		return nil, fmt.Errorf("unknown column type %d", int(t))
+	}
+	return []byte(name), nil
+}
+
+func (t *ColumnType) UnmarshalText(text []byte) error {
+	for typ, name := range columnTypeNames {
+		if name == string(text) {
+			*t = typ
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown column type %q", text)
+}
+
 type Column struct {
-	Name string
-	Type ColumnType
+	Name string     `json:"name"`
+	Type ColumnType `json:"type"`
+
+	// Nullable columns accept rows that leave the column out or set it to nil.
+	Nullable bool `json:"nullable,omitempty"`
+
+	// Default is what rows written before the column was added read as.
+	// A nil Default means null for nullable columns and the zero value of Type otherwise.
+	Default any `json:"default,omitempty"`
+
+	// BloomFilter writes a bloom filter of the column into every row group of a block,
+	// so equality lookups of ids that min/max cannot narrow down skip the row groups
+	// that do not hold them. The filters are read from the block file once the block
+	// stats let it through, they do not skip blocks before the file is opened.
+	BloomFilter bool `json:"bloom_filter,omitempty"`
 }
 
 type Schema struct {
-	Name       string
-	TimeColumn string
-	Columns    []Column
+	Name       string   `json:"name"`
+	TimeColumn string   `json:"time_column"`
+	Columns    []Column `json:"columns"`
+
+	// Version starts at 0 and is bumped by every Apply, blocks and WAL records
+	// remember the version they were written with
+	Version int `json:"version"`
+}
+
+// DefaultValue returns Default converted to the Go type of the column, or nil for a
+// nullable column without a Default. JSON decodes every number as float64, so defaults
+// read back from the catalog are normalised here.
+func (c Column) DefaultValue() any {
+	if c.Default == nil && c.Nullable {
+		return nil
+	}
+
+	switch c.Type {
+	case Int64:
+		switch v := c.Default.(type) {
+		case int64:
+			return v
+		case int:
+			return int64(v)
+		case float64:
+			return int64(v)
+		}
+		return int64(0)
+	case Float64:
+		switch v := c.Default.(type) {
+		case float64:
+			return v
+		case int64:
+			return float64(v)
+		case int:
+			return float64(v)
+		}
+		return float64(0)
+	case String:
+		if v, ok := c.Default.(string); ok {
+			return v
+		}
+		return ""
+	case Boolean:
+		if v, ok := c.Default.(bool); ok {
+			return v
+		}
+		return false
+	}
+	return nil
+}
+
+func (c Column) validateDefault() error {
+	if c.Default == nil {
+		return nil
+	}
+
+	ok := false
+	switch v := c.Default.(type) {
+	case int64, int:
+		ok = c.Type == Int64 || c.Type == Float64
+	case float64:
+		ok = c.Type == Float64 || (c.Type == Int64 && v == float64(int64(v)))
+	case string:
+		ok = c.Type == String
+	case bool:
+		ok = c.Type == Boolean
+	}
+
+	if !ok {
+		return fmt.Errorf("default %v (%T) is not valid for %s column %s", c.Default, c.Default, c.Type, c.Name)
+	}
+	return nil
 }
 
 func (s Schema) Validate() error {
@@ -45,12 +162,24 @@ func (s Schema) Validate() error {
 		}
 		columnNames[col.Name] = struct{}{}
 
+		if err := col.validateDefault(); err != nil {
+			return err
+		}
+
+		if col.BloomFilter && col.Type == Boolean {
+			return fmt.Errorf("bool column %s cannot have a bloom filter", col.Name)
+		}
+
 		if col.Name == s.TimeColumn {
 			timeColumnFound = true
 
 			if col.Type != Int64 {
 				return fmt.Errorf("time column %s must be of type int64", col.Name)
 			}
+
+			if col.Nullable {
+				return fmt.Errorf("time column %s cannot be nullable", col.Name)
+			}
 		}
 	}
 
@@ -60,3 +189,48 @@ func (s Schema) Validate() error {
 
 	return nil
 }
+
+// Column returns the column with the given name.
+func (s Schema) Column(name string) (Column, bool) {
+	for _, col := range s.Columns {
+		if col.Name == name {
+			return col, true
+		}
+	}
+	return Column{}, false
+}
+
+// Matches reports how s differs from stored, the schema a table was created with.
+// A nil error means both describe the same on-disk layout.
+func (s Schema) Matches(stored Schema) error {
+
+	if s.Name != stored.Name {
+		return fmt.Errorf("schema name %s does not match stored name %s", s.Name, stored.Name)
+	}
+
+	if s.TimeColumn != stored.TimeColumn {
+		return fmt.Errorf("table %s: time column %s does not match stored time column %s", s.Name, s.TimeColumn, stored.TimeColumn)
+	}
+
+	if len(s.Columns) != len(stored.Columns) {
+		return fmt.Errorf("table %s: schema has %d columns, stored schema has %d", s.Name, len(s.Columns), len(stored.Columns))
+	}
+
+	for i, col := range s.Columns {
+		storedCol := stored.Columns[i]
+
+		if col.Name != storedCol.Name {
+			return fmt.Errorf("table %s: column %d is %s, stored schema has %s", s.Name, i, col.Name, storedCol.Name)
+		}
+
+		if col.Type != storedCol.Type {
+			return fmt.Errorf("table %s: column %s is %s, stored schema has %s", s.Name, col.Name, col.Type, storedCol.Type)
+		}
+
+		if col.Nullable != storedCol.Nullable {
+			return fmt.Errorf("table %s: column %s nullable is %v, stored schema has %v", s.Name, col.Name, col.Nullable, storedCol.Nullable)
+		}
+	}
+
+	return nil
+}
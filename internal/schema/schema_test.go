@@ -15,3 +15,116 @@ func TestValidate(t *testing.T) {
 		t.Errorf("expected no error, got %v", err)
 	}
 }
+
+func TestMatches(t *testing.T) {
+	stored := Schema{
+		Name:       "trades",
+		TimeColumn: "ts",
+		Columns: []Column{
+			{Name: "ts", Type: Int64},
+			{Name: "price", Type: Float64},
+		},
+	}
+
+	if err := stored.Matches(stored); err != nil {
+		t.Errorf("expected identical schemas to match, got %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		columns []Column
+	}{
+		{"TypeChanged", []Column{{Name: "ts", Type: Int64}, {Name: "price", Type: String}}},
+		{"Renamed", []Column{{Name: "ts", Type: Int64}, {Name: "px", Type: Float64}}},
+		{"Missing", []Column{{Name: "ts", Type: Int64}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := Schema{Name: "trades", TimeColumn: "ts", Columns: tt.columns}
+			if err := s.Matches(stored); err == nil {
+				t.Error("expected a mismatch error")
+			}
+		})
+	}
+}
+
+func TestColumnTypeText(t *testing.T) {
+	for _, typ := range []ColumnType{Int64, Float64, String, Boolean} {
+		text, err := typ.MarshalText()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var decoded ColumnType
+		if err := decoded.UnmarshalText(text); err != nil {
+			t.Fatal(err)
+		}
+		if decoded != typ {
+			t.Errorf("expected %v after round trip, got %v", typ, decoded)
+		}
+	}
+
+	var bad ColumnType
+	if err := bad.UnmarshalText([]byte("decimal")); err == nil {
+		t.Error("expected an error for an unknown type name")
+	}
+}
+
+func TestApply(t *testing.T) {
+	s := Schema{
+		Name:       "trades",
+		TimeColumn: "ts",
+		Columns: []Column{
+			{Name: "ts", Type: Int64},
+			{Name: "qty", Type: Int64},
+			{Name: "venue", Type: String},
+		},
+	}
+
+	next, err := s.Apply(
+		AddColumn(Column{Name: "is_buy", Type: Boolean, Default: true}),
+		DropColumn("venue"),
+		WidenColumn("qty", Float64),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if next.Version != 1 {
+		t.Errorf("expected version 1, got %d", next.Version)
+	}
+	if len(s.Columns) != 3 || s.Columns[1].Type != Int64 {
+		t.Error("Apply must not modify the original schema")
+	}
+	if col, _ := next.Column("qty"); col.Type != Float64 {
+		t.Errorf("expected qty to be float64, got %v", col.Type)
+	}
+	if _, ok := next.Column("venue"); ok {
+		t.Error("expected venue to be dropped")
+	}
+	if col, _ := next.Column("is_buy"); col.DefaultValue() != true {
+		t.Errorf("expected is_buy default true, got %v", col.DefaultValue())
+	}
+
+	invalid := []struct {
+		name   string
+		change Change
+	}{
+		{"AddExisting", AddColumn(Column{Name: "qty", Type: Int64})},
+		{"AddBadDefault", AddColumn(Column{Name: "px", Type: Float64, Default: "1.5"})},
+		{"DropMissing", DropColumn("price")},
+		{"DropTime", DropColumn("ts")},
+		{"WidenTime", WidenColumn("ts", Float64)},
+		{"Narrow", WidenColumn("venue", Int64)},
+		{"BoolBloomFilter", AddColumn(Column{Name: "flag", Type: Boolean, BloomFilter: true})},
+	}
+
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := s.Apply(tt.change); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
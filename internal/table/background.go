@@ -0,0 +1,72 @@
+package table
+
+import (
+	"sync"
+	"time"
+)
+
+// backgroundJob runs a pass on a ticker until it is stopped and keeps the error of the
+// last pass. Compaction and retention each run one per table.
+type backgroundJob struct {
+	mu      sync.Mutex
+	stop    chan struct{}
+	stopped chan struct{}
+	err     error
+}
+
+// start stops a running job and starts pass every interval, interval 0 only stops it.
+func (j *backgroundJob) start(interval time.Duration, pass func() error) {
+	j.halt()
+
+	if interval == 0 {
+		return
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.stop = make(chan struct{})
+	j.stopped = make(chan struct{})
+	go j.run(interval, pass, j.stop, j.stopped)
+}
+
+// halt stops the job, waiting for a running pass to finish.
+func (j *backgroundJob) halt() {
+	j.mu.Lock()
+	stop, stopped := j.stop, j.stopped
+	j.stop, j.stopped = nil, nil
+	j.mu.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-stopped
+	}
+}
+
+// lastErr returns the error of the last pass, nil once one succeeds.
+func (j *backgroundJob) lastErr() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.err
+}
+
+func (j *backgroundJob) run(interval time.Duration, pass func() error, stop, stopped chan struct{}) {
+	defer close(stopped)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			err := pass()
+
+			j.mu.Lock()
+			j.err = err
+			j.mu.Unlock()
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package table
+
+import "backtraceDB/internal/schema"
+
+// RecordBatch holds the rows of one block column-wise. The vectors cover the rows of the
+// block that were decoded, every row for blocks in memory and only the pages the filters
+// may match for blocks read from parquet, in block order. Selection marks the rows among
+// them that passed all predicates. Schema lists the columns the batch exposes, the
+// selected ones when the reader projects. Vectors are shared with the reader and the
+// table, callers must not modify them.
+type RecordBatch struct {
+	Schema    schema.Schema
+	Selection []bool
+
+	storage *ColumnStorage
+	columns map[string]int
+}
+
+// newRecordBatch exposes the logical columns in projection of storage, all columns of s
+// when projection is nil.
+func newRecordBatch(s schema.Schema, storage *ColumnStorage, selection []bool, projection []int) *RecordBatch {
+	if projection == nil {
+		projection = make([]int, len(s.Columns))
+		for i := range s.Columns {
+			projection[i] = i
+		}
+	}
+
+	out := s
+	out.Columns = make([]schema.Column, 0, len(projection))
+	columns := make(map[string]int, len(projection))
+	for _, i := range projection {
+		out.Columns = append(out.Columns, s.Columns[i])
+		columns[s.Columns[i].Name] = i
+	}
+
+	return &RecordBatch{
+		Schema:    out,
+		Selection: selection,
+		storage:   storage,
+		columns:   columns,
+	}
+}
+
+// Len returns the number of rows in the vectors, selected or not.
+func (rb *RecordBatch) Len() int {
+	return len(rb.Selection)
+}
+
+// Selected returns the number of rows that passed the predicates.
+func (rb *RecordBatch) Selected() int {
+	n := 0
+	for _, ok := range rb.Selection {
+		if ok {
+			n++
+		}
+	}
+	return n
+}
+
+func (rb *RecordBatch) location(name string, t schema.ColumnType) (ColumnLocation, bool) {
+	idx, ok := rb.columns[name]
+	if !ok {
+		return ColumnLocation{}, false
+	}
+	loc := rb.storage.locations[idx]
+	return loc, loc.Type == t
+}
+
+// Int64 returns the vector of an int64 column.
+func (rb *RecordBatch) Int64(name string) ([]int64, bool) {
+	loc, ok := rb.location(name, schema.Int64)
+	if !ok {
+		return nil, false
+	}
+	return rb.storage.Int64Cols[loc.Index], true
+}
+
+// Float64 returns the vector of a float64 column.
+func (rb *RecordBatch) Float64(name string) ([]float64, bool) {
+	loc, ok := rb.location(name, schema.Float64)
+	if !ok {
+		return nil, false
+	}
+	return rb.storage.Float64Cols[loc.Index], true
+}
+
+// Strings returns a string column dictionary encoded, row i holds dict[ids[i]].
+func (rb *RecordBatch) Strings(name string) (ids []int, dict []string, ok bool) {
+	loc, ok := rb.location(name, schema.String)
+	if !ok {
+		return nil, nil, false
+	}
+	return rb.storage.StringCols[loc.Index], rb.storage.StringReads[loc.Index], true
+}
+
+// Bool returns the bitmap of a bool column.
+func (rb *RecordBatch) Bool(name string) (*Bitmap, bool) {
+	loc, ok := rb.location(name, schema.Boolean)
+	if !ok {
+		return nil, false
+	}
+	return &rb.storage.BoolCols[loc.Index], true
+}
+
+// Validity returns the validity bitmap of a column, a set bit marks a non-null row.
+// It is nil when the column has no nulls in this batch.
+func (rb *RecordBatch) Validity(name string) *Bitmap {
+	idx, ok := rb.columns[name]
+	if !ok {
+		return nil
+	}
+	return rb.storage.Validity[idx]
+}
@@ -0,0 +1,78 @@
+package table
+
+import "math/bits"
+
+// Bitmap is a packed, append-only bit vector. Booleans are stored 64 to a word
+// so a 10M row block costs ~1.2MB instead of 10MB for a []bool.
+type Bitmap struct {
+	words []uint64
+	n     int
+}
+
+func (b *Bitmap) Append(v bool) {
+	if b.n%64 == 0 {
+		b.words = append(b.words, 0)
+	}
+	if v {
+		b.words[b.n/64] |= 1 << (uint(b.n) % 64)
+	}
+	b.n++
+}
+
+// AppendN appends n copies of v, filling whole words at a time where it can.
+func (b *Bitmap) AppendN(v bool, n int) {
+	for n > 0 && b.n%64 != 0 {
+		b.Append(v)
+		n--
+	}
+
+	var word uint64
+	if v {
+		word = ^uint64(0)
+	}
+	for n >= 64 {
+		b.words = append(b.words, word)
+		b.n += 64
+		n -= 64
+	}
+
+	for ; n > 0; n-- {
+		b.Append(v)
+	}
+}
+
+func (b *Bitmap) Get(i int) bool {
+	return b.words[i/64]&(1<<(uint(i)%64)) != 0
+}
+
+func (b *Bitmap) Set(i int, v bool) {
+	if v {
+		b.words[i/64] |= 1 << (uint(i) % 64)
+	} else {
+		b.words[i/64] &^= 1 << (uint(i) % 64)
+	}
+}
+
+func (b *Bitmap) Len() int {
+	return b.n
+}
+
+// Count returns the number of set bits.
+func (b *Bitmap) Count() int {
+	count := 0
+	for _, w := range b.words {
+		count += bits.OnesCount64(w)
+	}
+	return count
+}
+
+// Clone returns a copy of the first n bits. The copy shares no memory with b, so it
+// can be read while b keeps growing.
+func (b *Bitmap) Clone(n int) Bitmap {
+	words := make([]uint64, (n+63)/64)
+	copy(words, b.words)
+	if n%64 != 0 {
+		words[len(words)-1] &= (1 << (uint(n) % 64)) - 1
+	}
+	return Bitmap{words: words, n: n}
+}
@@ -3,81 +3,177 @@ package table
 import (
 	"backtraceDB/internal/schema"
 	"bytes"
+	"cmp"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"os"
 	"path/filepath"
 	"slices"
+	"strconv"
 
 	"github.com/parquet-go/parquet-go"
 )
 
+// schemaVersionKey is the parquet key/value metadata entry holding the schema version
+// a block was written with
+const schemaVersionKey = "backtracedb.schema_version"
+
+// maxLSNKey holds the LSN of the newest WAL record whose rows are in the block
+const maxLSNKey = "backtracedb.max_lsn"
+
+// every page gets min/max in the column index, so the smaller the pages the less a
+// narrow scan decodes. Tests lower both to get blocks of many pages and row groups.
+var (
+	parquetPageSize     = parquet.DefaultPageBufferSize
+	parquetRowGroupRows = int64(parquet.DefaultMaxRowsPerRowGroup)
+)
+
 type Block struct {
-	Storage  *ColumnStorage
-	RowCount int
-	Path     string
-	isOnDisk bool
-	MaxTs    int64
+	Storage       *ColumnStorage
+	RowCount      int
+	Path          string
+	isOnDisk      bool
+	MinTs         int64
+	MaxTs         int64
+	SchemaVersion int
+	MaxLSN        uint64 // newest WAL record in the block, 0 for tables without a WAL
+	Partition     string // time partition the block is filed under, empty when unpartitioned
+
+	// ID numbers the blocks of a table, Size and Checksum (crc32c) describe the file
+	// once the block is written to disk
+	ID       int
+	Size     int64
+	Checksum uint32
 
 	isClosed     bool
 	inMemoryData []byte
 
+	// unsorted is set while rows that arrived out of order sit in Storage, the block is
+	// sorted by time before it is written
+	unsorted bool
+
+	// parts are the blocks a reader ordering by time merges into this one because their
+	// time ranges overlap
+	parts []*Block
+
 	IntMin   []int64
 	IntMax   []int64
 	FloatMin []float64
 	FloatMax []float64
+	BoolMin  []bool // false if the block holds at least one false
+	BoolMax  []bool // true if the block holds at least one true
+
+	// string stats are nil for blocks loaded without a manifest, StringValues holds the
+	// sorted distinct values of a column, or nil when there are more than
+	// maxStringValues of them
+	StringMin    []string
+	StringMax    []string
+	StringValues [][]string
+
+	// NullCounts is indexed by logical column, unlike the min/max slices which are
+	// indexed by the position of the column among columns of the same type
+	NullCounts []int
 }
 
 func NewBlock(colTypes []schema.ColumnType) (*Block, []ColumnLocation, error) {
 	storage, locations, err := NewColumnStorage(colTypes)
 
-	numInt, numFloat := 0, 0
+	b := &Block{
+		Storage:  storage,
+		RowCount: 0,
+		Path:     "",
+		isOnDisk: false,
+		MaxTs:    0,
+	}
+	b.allocStats(colTypes)
+
+	return b, locations, err
+}
+
+// allocStats sizes the min/max slices for a block with the given column layout
+func (b *Block) allocStats(colTypes []schema.ColumnType) {
+	numInt, numFloat, numBool := 0, 0, 0
 	for _, t := range colTypes {
 		switch t {
 		case schema.Int64:
 			numInt++
 		case schema.Float64:
 			numFloat++
+		case schema.Boolean:
+			numBool++
 		}
 	}
 
-	return &Block{
-		Storage:  storage,
-		RowCount: 0,
-		Path:     "",
-		isOnDisk: false,
-		MaxTs:    0,
-		IntMin:   make([]int64, numInt),
-		IntMax:   make([]int64, numInt),
-		FloatMin: make([]float64, numFloat),
-		FloatMax: make([]float64, numFloat),
-	}, locations, err
+	b.IntMin = make([]int64, numInt)
+	b.IntMax = make([]int64, numInt)
+	b.FloatMin = make([]float64, numFloat)
+	b.FloatMax = make([]float64, numFloat)
+	b.BoolMin = make([]bool, numBool)
+	b.BoolMax = make([]bool, numBool)
+	b.NullCounts = make([]int, len(colTypes))
 }
 
+// LoadInto decodes the columns of s from the block into dest. A block written under an
+// older schema version may lack columns added since, those read as the column default,
+// and may hold int64 data for columns that were widened to float64 later.
 func (b *Block) LoadInto(dest *ColumnStorage, s schema.Schema, locations []ColumnLocation) error {
+	return b.LoadColumnsInto(dest, s, locations, nil)
+}
 
-	var pf *parquet.File
-	var err error
+// LoadColumnsInto is LoadInto restricted to the logical columns set in want, the other
+// column chunks are never read and their columns stay empty in dest. A nil want loads
+// every column.
+func (b *Block) LoadColumnsInto(dest *ColumnStorage, s schema.Schema, locations []ColumnLocation, want []bool) error {
+	pf, closeFile, err := b.openParquet()
+	if err != nil {
+		return err
+	}
+	defer closeFile()
 
+	return b.loadRows(pf, dest, s, locations, want, nil)
+}
+
+// openParquet opens the parquet file of the block, on disk or in memory. The returned
+// func closes it.
+func (b *Block) openParquet() (*parquet.File, func(), error) {
 	if len(b.inMemoryData) > 0 {
-		pf, err = parquet.OpenFile(bytes.NewReader(b.inMemoryData), int64(len(b.inMemoryData)))
-		if err != nil {
-			return fmt.Errorf("failed to open block file: %v", err)
-		}
-	} else if b.isOnDisk {
-		f, err := os.Open(b.Path)
+		pf, err := parquet.OpenFile(bytes.NewReader(b.inMemoryData), int64(len(b.inMemoryData)))
 		if err != nil {
-			return fmt.Errorf("failed to open block file: %v", err)
+			return nil, nil, fmt.Errorf("failed to open block file: %v", err)
 		}
-		defer f.Close()
+		return pf, func() {}, nil
+	}
 
-		stat, _ := f.Stat()
-		pf, err = parquet.OpenFile(f, stat.Size())
-		if err != nil {
-			return err
-		}
-	} else {
-		return fmt.Errorf("block is not on disk and has no in memory data")
+	if !b.isOnDisk {
+		return nil, nil, fmt.Errorf("block is not on disk and has no in memory data")
+	}
+
+	f, err := os.Open(b.Path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open block file: %v", err)
+	}
+
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, fmt.Errorf("failed to open block file: %v", err)
+	}
+	pf, err := parquet.OpenFile(f, stat.Size())
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return pf, func() { f.Close() }, nil
+}
+
+// loadRows decodes the rows of pf in rows, sorted and not overlapping, into dest, every
+// row when rows is nil. Pages outside rows are skipped through the offset index without
+// being decoded.
+func (b *Block) loadRows(pf *parquet.File, dest *ColumnStorage, s schema.Schema, locations []ColumnLocation, want []bool, rows []rowRange) error {
+	total := int64(b.RowCount)
+	if rows != nil {
+		total = rangeLen(rows)
 	}
 
 	fileSchema := pf.Schema()
@@ -89,63 +185,98 @@ func (b *Block) LoadInto(dest *ColumnStorage, s schema.Schema, locations []Colum
 	valueBuffer := make([]parquet.Value, 256) //256 is small enough to fit into l1/l2 cache
 
 	for logicalIdx, col := range s.Columns {
+		if want != nil && !want[logicalIdx] {
+			continue
+		}
 
 		pIdx, exists := parquetColIndices[col.Name]
 		loc := locations[logicalIdx]
 
 		if !exists {
-			return fmt.Errorf("column %s not found in parquet file", col.Name)
+			fillDefault(dest, logicalIdx, col.DefaultValue(), int(total))
+			continue
 		}
+
+		row := 0
+		appendValue := func(v parquet.Value) {
+			// nulls keep a zero value slot so every column stays row aligned
+			if v.IsNull() {
+				dest.AppendValue(logicalIdx, nil)
+				row++
+				return
+			}
+			dest.appendValidity(logicalIdx, row, true)
+			row++
+
+			switch loc.Type {
+			case schema.Int64:
+				dest.Int64Cols[loc.Index] = append(dest.Int64Cols[loc.Index], v.Int64())
+			case schema.Float64:
+				f := v.Double()
+				if v.Kind() == parquet.Int64 {
+					f = float64(v.Int64())
+				}
+				dest.Float64Cols[loc.Index] = append(dest.Float64Cols[loc.Index], f)
+			case schema.String:
+				dest.appendString(loc.Index, v.String())
+			case schema.Boolean:
+				dest.BoolCols[loc.Index].Append(v.Boolean())
+			}
+		}
+
+		var groupStart int64
 		for _, rowGroup := range pf.RowGroups() {
-			columnChunk := rowGroup.ColumnChunks()[pIdx]
-			pages := columnChunk.Pages()
+			groupRows := rowGroup.NumRows()
+			ranges := clipRows(rows, groupStart, groupStart+groupRows)
+			groupStart += groupRows
+			if len(ranges) == 0 {
+				continue
+			}
+
+			pages := rowGroup.ColumnChunks()[pIdx].Pages()
+			err := readRanges(pages, ranges, valueBuffer, appendValue)
+			pages.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// readRanges reads the rows of ranges, relative to the row group of pages, and passes
+// their values to fn. Columns are flat, so every value is one row.
+func readRanges(pages parquet.Pages, ranges []rowRange, buf []parquet.Value, fn func(parquet.Value)) error {
+	for _, r := range ranges {
+		if r.start > 0 {
+			if err := pages.SeekToRow(r.start); err != nil {
+				return fmt.Errorf("failed to seek to row %d: %v", r.start, err)
+			}
+		}
+
+		left := r.end - r.start
+		for left > 0 {
+			page, err := pages.ReadPage()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("failed to read page: %v", err)
+			}
+
+			values := page.Values()
+			for left > 0 {
+				n, err := values.ReadValues(buf[:min(int64(len(buf)), left)])
+				for i := 0; i < n; i++ {
+					fn(buf[i])
+				}
+				left -= int64(n)
 
-			for {
-				page, err := pages.ReadPage()
 				if err == io.EOF {
 					break
 				}
 				if err != nil {
-					return fmt.Errorf("failed to read page: %v", err)
-				}
-
-				values := page.Values()
-
-				for {
-					n, err := values.ReadValues(valueBuffer)
-
-					if n > 0 {
-						for i := 0; i < n; i++ {
-							v := valueBuffer[i]
-
-							if v.IsNull() {
-								continue
-							}
-
-							switch loc.Type {
-							case schema.Int64:
-								dest.Int64Cols[loc.Index] = append(dest.Int64Cols[loc.Index], v.Int64())
-							case schema.Float64:
-								dest.Float64Cols[loc.Index] = append(dest.Float64Cols[loc.Index], v.Double())
-							case schema.String:
-								stringVal := v.String()
-								dict := dest.StringDicts[loc.Index]
-								id, exists := dict[stringVal]
-								if !exists {
-									id = len(dict)
-									dict[stringVal] = id
-									dest.StringReads[loc.Index] = append(dest.StringReads[loc.Index], stringVal)
-								}
-								dest.StringCols[loc.Index] = append(dest.StringCols[loc.Index], id)
-							}
-						}
-					}
-					if err == io.EOF {
-						break
-					}
-					if err != nil {
-						return fmt.Errorf("failed to read values: %v", err)
-					}
+					return fmt.Errorf("failed to read values: %v", err)
 				}
 			}
 		}
@@ -153,41 +284,85 @@ func (b *Block) LoadInto(dest *ColumnStorage, s schema.Schema, locations []Colum
 	return nil
 }
 
+func fillDefault(dest *ColumnStorage, col int, val any, n int) {
+	for i := 0; i < n; i++ {
+		dest.AppendValue(col, val)
+	}
+}
+
 func (b *Block) WriteParquetTo(w io.Writer, s schema.Schema, locations []ColumnLocation) error {
 	pqFields := make(map[string]parquet.Node)
 
 	for _, col := range s.Columns {
+		var node parquet.Node
 		switch col.Type {
 		case schema.Int64:
-			pqFields[col.Name] = parquet.Leaf(parquet.Int64Type)
+			node = parquet.Leaf(parquet.Int64Type)
 		case schema.Float64:
-			pqFields[col.Name] = parquet.Leaf(parquet.DoubleType)
+			node = parquet.Leaf(parquet.DoubleType)
 		case schema.String:
-			pqFields[col.Name] = parquet.Leaf(parquet.ByteArrayType)
+			node = parquet.Leaf(parquet.ByteArrayType)
+		case schema.Boolean:
+			node = parquet.Leaf(parquet.BooleanType)
+		default:
+			continue
 		}
+
+		if col.Nullable {
+			node = parquet.Optional(node)
+		}
+		pqFields[col.Name] = node
 	}
 
 	pqSchema := parquet.NewSchema(s.Name, parquet.Group(pqFields))
 
-	writer := parquet.NewGenericWriter[any](w, pqSchema)
-
-	row := make(map[string]any)
+	writer := parquet.NewGenericWriter[any](w, pqSchema,
+		parquet.KeyValueMetadata(schemaVersionKey, strconv.Itoa(s.Version)),
+		parquet.KeyValueMetadata(maxLSNKey, strconv.FormatUint(b.MaxLSN, 10)),
+		parquet.PageBufferSize(parquetPageSize),
+		parquet.MaxRowsPerRowGroup(parquetRowGroupRows),
+		parquet.BloomFilters(bloomColumns(s)...),
+	)
+
+	// rows are built leaf by leaf with explicit definition levels, a map row would have
+	// the zero value of an optional field written as null
+	leaves := make([]int, 0, len(pqFields))
+	for _, field := range pqSchema.Fields() {
+		leaves = append(leaves, slices.IndexFunc(s.Columns, func(c schema.Column) bool { return c.Name == field.Name() }))
+	}
 
+	row := make(parquet.Row, len(leaves))
 	for i := 0; i < b.RowCount; i++ {
-		for logicalIdx, col := range s.Columns {
+		for leaf, logicalIdx := range leaves {
+			col := s.Columns[logicalIdx]
 			loc := locations[logicalIdx]
+
+			if b.Storage.IsNull(logicalIdx, i) {
+				row[leaf] = parquet.NullValue().Level(0, 0, leaf)
+				continue
+			}
+
+			var v parquet.Value
 			switch col.Type {
 			case schema.Int64:
-				row[col.Name] = b.Storage.Int64Cols[loc.Index][i]
+				v = parquet.Int64Value(b.Storage.Int64Cols[loc.Index][i])
 			case schema.Float64:
-				row[col.Name] = b.Storage.Float64Cols[loc.Index][i]
+				v = parquet.DoubleValue(b.Storage.Float64Cols[loc.Index][i])
 			case schema.String:
 				strID := b.Storage.StringCols[loc.Index][i]
-				row[col.Name] = b.Storage.StringReads[loc.Index][strID]
+				v = parquet.ByteArrayValue([]byte(b.Storage.StringReads[loc.Index][strID]))
+			case schema.Boolean:
+				v = parquet.BooleanValue(b.Storage.BoolCols[loc.Index].Get(i))
+			}
+
+			def := 0
+			if col.Nullable {
+				def = 1
 			}
+			row[leaf] = v.Level(0, def, leaf)
 		}
 
-		if _, err := writer.Write([]any{row}); err != nil {
+		if _, err := writer.WriteRows([]parquet.Row{row}); err != nil {
 			return fmt.Errorf("failed to write row %d: %v", i, err)
 		}
 	}
@@ -195,46 +370,137 @@ func (b *Block) WriteParquetTo(w io.Writer, s schema.Schema, locations []ColumnL
 	return writer.Close()
 }
 
+// UpdateStats recomputes the block statistics from Storage. Null rows are left out of
+// min/max and counted in NullCounts instead.
 func (b *Block) UpdateStats() {
 	if b.Storage == nil {
 		return
 	}
 
-	for i, col := range b.Storage.Int64Cols {
-		if len(col) == 0 {
-			continue
+	b.NullCounts = make([]int, len(b.Storage.locations))
+
+	for logicalIdx, loc := range b.Storage.locations {
+		validity := b.Storage.Validity[logicalIdx]
+		if validity != nil {
+			b.NullCounts[logicalIdx] = validity.Len() - validity.Count()
+		}
+
+		switch loc.Type {
+		case schema.Int64:
+			if min, max, ok := validMinMax(b.Storage.Int64Cols[loc.Index], validity); ok {
+				b.IntMin[loc.Index], b.IntMax[loc.Index] = min, max
+			}
+		case schema.Float64:
+			if min, max, ok := validMinMax(b.Storage.Float64Cols[loc.Index], validity); ok {
+				b.FloatMin[loc.Index], b.FloatMax[loc.Index] = min, max
+			}
+		case schema.Boolean:
+			col := &b.Storage.BoolCols[loc.Index]
+			valid, trues := col.Len(), col.Count()
+			if validity != nil {
+				valid, trues = 0, 0
+				for i := 0; i < col.Len(); i++ {
+					if validity.Get(i) {
+						valid++
+						if col.Get(i) {
+							trues++
+						}
+					}
+				}
+			}
+			if valid == 0 {
+				continue
+			}
+			b.BoolMin[loc.Index] = trues == valid
+			b.BoolMax[loc.Index] = trues > 0
+		case schema.String:
+			b.updateStringStats(loc.Index, validity)
+		}
+	}
+
+}
+
+// maxStringValues caps the distinct values a block keeps per string column, past it
+// only min and max are kept
+const maxStringValues = 256
+
+// updateStringStats records min, max and the distinct values of the string column idx.
+// Dictionaries are shared by the pieces of a split block, so only the ids the rows use
+// count.
+func (b *Block) updateStringStats(idx int, validity *Bitmap) {
+	n := len(b.Storage.StringCols)
+	if len(b.StringMin) != n {
+		b.StringMin = make([]string, n)
+		b.StringMax = make([]string, n)
+		b.StringValues = make([][]string, n)
+	}
+
+	dict := b.Storage.StringReads[idx]
+	used := make([]bool, len(dict))
+	for i, id := range b.Storage.StringCols[idx] {
+		if validity == nil || validity.Get(i) {
+			used[id] = true
+		}
+	}
+
+	var values []string
+	for id, v := range dict {
+		if used[id] {
+			values = append(values, v)
 		}
-		b.IntMax[i] = slices.Max(col)
-		b.IntMin[i] = slices.Min(col)
 	}
+	if len(values) == 0 {
+		b.StringMin[idx], b.StringMax[idx], b.StringValues[idx] = "", "", []string{}
+		return
+	}
+
+	slices.Sort(values)
+	values = slices.Compact(values)
+
+	b.StringMin[idx], b.StringMax[idx] = values[0], values[len(values)-1]
+	b.StringValues[idx] = nil
+	if len(values) <= maxStringValues {
+		b.StringValues[idx] = values
+	}
+}
 
-	for i, col := range b.Storage.Float64Cols {
+// validMinMax returns the min and max of the non-null values of col, ok is false when
+// there are none.
+func validMinMax[T cmp.Ordered](col []T, validity *Bitmap) (min, max T, ok bool) {
+	if validity == nil {
 		if len(col) == 0 {
-			continue
+			return min, max, false
 		}
-		b.FloatMax[i] = slices.Max(col)
-		b.FloatMin[i] = slices.Min(col)
+		return slices.Min(col), slices.Max(col), true
 	}
 
+	for i, v := range col {
+		if !validity.Get(i) {
+			continue
+		}
+		if !ok || v < min {
+			min = v
+		}
+		if !ok || v > max {
+			max = v
+		}
+		ok = true
+	}
+	return min, max, ok
 }
+
 func (b *Block) Rotate(useDisk bool, filePath string, s schema.Schema, locations []ColumnLocation) error {
 
 	b.UpdateStats()
 
 	if useDisk {
-		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
-			return fmt.Errorf("failed to create directory: %v", err)
-		}
-
-		f, err := os.Create(filePath)
+		size, sum, err := writeFileAtomic(filePath, func(w io.Writer) error {
+			return b.WriteParquetTo(w, s, locations)
+		})
 		if err != nil {
 			return err
 		}
-		defer f.Close()
-
-		if err := b.WriteParquetTo(f, s, locations); err != nil {
-			return err
-		}
+		b.Size, b.Checksum = size, sum
 
 		b.inMemoryData = nil
 		b.Path = filePath
@@ -264,30 +530,119 @@ func (b *Block) Persist(path string, s schema.Schema, locations []ColumnLocation
 		return nil
 	}
 
-	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
-		return fmt.Errorf("failed to create directory: %v", err)
-	}
-
-	f, err := os.Create(path)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
-	if len(b.inMemoryData) > 0 {
-		_, err = io.Copy(f, bytes.NewReader(b.inMemoryData))
-		if err != nil {
-			return err
-		}
-	} else if b.Storage != nil {
-		if err := b.WriteParquetTo(f, s, locations); err != nil {
+	size, sum, err := writeFileAtomic(path, func(w io.Writer) error {
+		if len(b.inMemoryData) > 0 {
+			_, err := io.Copy(w, bytes.NewReader(b.inMemoryData))
 			return err
+		} else if b.Storage != nil {
+			return b.WriteParquetTo(w, s, locations)
 		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
+	b.Size, b.Checksum = size, sum
 
 	b.Path = path
 	b.isOnDisk = true
 	b.inMemoryData = nil
 	b.Storage = nil
 	return nil
+}
+
+// tempSuffix marks a block file that is still being written, LoadFromDisk removes the
+// ones a crash left behind
+const tempSuffix = ".tmp"
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// writeFileAtomic makes path hold either the complete output of write or nothing. The
+// data goes to a temp file that is fsynced and renamed over path, and the directory is
+// fsynced so the rename survives a crash as well. It returns the size and crc32c of
+// what was written.
+func writeFileAtomic(path string, write func(io.Writer) error) (int64, uint32, error) {
+	dir := filepath.Dir(path)
+	_, statErr := os.Stat(dir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return 0, 0, fmt.Errorf("failed to create directory: %v", err)
+	}
+
+	// a new partition directory only survives a crash once its parent is synced too
+	if os.IsNotExist(statErr) {
+		if err := syncDir(filepath.Dir(dir)); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	tmp := path + tempSuffix
+	f, err := os.Create(tmp)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	sum := crc32.New(castagnoli)
+	counter := &countingWriter{w: io.MultiWriter(f, sum)}
+
+	if err := write(counter); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return 0, 0, err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return 0, 0, fmt.Errorf("failed to sync %s: %v", tmp, err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return 0, 0, err
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return 0, 0, fmt.Errorf("failed to rename %s: %v", tmp, err)
+	}
+
+	return counter.n, sum.Sum32(), syncDir(dir)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// fileChecksum returns the size and crc32c of the file at path.
+func fileChecksum(path string) (int64, uint32, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	sum := crc32.New(castagnoli)
+	n, err := io.Copy(sum, f)
+	if err != nil {
+		return 0, 0, err
+	}
+	return n, sum.Sum32(), nil
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("failed to sync directory %s: %v", dir, err)
+	}
+	return nil
 }
\ No newline at end of file
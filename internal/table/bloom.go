@@ -0,0 +1,106 @@
+package table
+
+import (
+	"backtraceDB/internal/schema"
+	"math"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// bloom filters get bloomBitsPerValue bits per value of a row group, which gives about
+// 1% false positives
+const bloomBitsPerValue = 10
+
+// bloomColumns returns the filters the parquet writer builds for the columns of s that
+// ask for one. They are written into the block file, one per row group, and only read
+// when a lookup needs them, so neither the manifest nor memory holds them. A block is
+// therefore only ruled out by them once its file is open, see pageRows.
+func bloomColumns(s schema.Schema) []parquet.BloomFilterColumn {
+	var filters []parquet.BloomFilterColumn
+	for _, col := range s.Columns {
+		if col.BloomFilter {
+			filters = append(filters, parquet.SplitBlockFilter(bloomBitsPerValue, col.Name))
+		}
+	}
+	return filters
+}
+
+// bloomKeys returns v as stored in a column of type typ in the block, ok is false when
+// it cannot be looked up. typ differs from the schema type of a column widened since.
+func bloomKeys(typ schema.ColumnType, v any) ([]parquet.Value, bool) {
+	switch typ {
+	case schema.Int64:
+		switch t := v.(type) {
+		case int64:
+			return []parquet.Value{parquet.Int64Value(t)}, true
+		case int:
+			return []parquet.Value{parquet.Int64Value(int64(t))}, true
+		case float64:
+			if t != math.Trunc(t) || t < math.MinInt64 || t >= math.MaxInt64 {
+				return nil, false
+			}
+			return []parquet.Value{parquet.Int64Value(int64(t))}, true
+		}
+	case schema.Float64:
+		var f float64
+		switch t := v.(type) {
+		case float64:
+			f = t
+		case int64:
+			f = float64(t)
+		case int:
+			f = float64(t)
+		default:
+			return nil, false
+		}
+		// filters hash the bits, -0 == 0 but hashes apart
+		if f == 0 {
+			return []parquet.Value{parquet.DoubleValue(0), parquet.DoubleValue(math.Copysign(0, -1))}, true
+		}
+		return []parquet.Value{parquet.DoubleValue(f)}, true
+	case schema.String:
+		if s, ok := v.(string); ok {
+			return []parquet.Value{parquet.ByteArrayValue([]byte(s))}, true
+		}
+	}
+	return nil, false
+}
+
+// bloomRulesOut reports whether the bloom filter of chunk proves that no row of it
+// matches the checked == or IN predicate p. typ is the type of the column in the block.
+func bloomRulesOut(chunk parquet.ColumnChunk, typ schema.ColumnType, p Predicate) (bool, error) {
+	var values []any
+	switch p.Op {
+	case "==":
+		values = []any{p.Value}
+	case OpIn:
+		var ok bool
+		if values, ok = setValues(p.Value); !ok {
+			return false, nil
+		}
+	default:
+		return false, nil
+	}
+
+	filter := chunk.BloomFilter()
+	if filter == nil {
+		return false, nil
+	}
+
+	for _, v := range values {
+		keys, ok := bloomKeys(typ, v)
+		if !ok {
+			return false, nil
+		}
+		for _, key := range keys {
+			found, err := filter.Check(key)
+			if err != nil {
+				return false, err
+			}
+			if found {
+				return false, nil
+			}
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,200 @@
+package table
+
+import (
+	"backtraceDB/internal/schema"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBloomKeys(t *testing.T) {
+	if keys, ok := bloomKeys(schema.Float64, 0.0); !ok || len(keys) != 2 || !math.Signbit(keys[1].Double()) {
+		t.Errorf("expected 0 to be looked up as 0 and -0, got %v", keys)
+	}
+	if keys, ok := bloomKeys(schema.Int64, 42.0); !ok || keys[0].Int64() != 42 {
+		t.Errorf("expected 42.0 to be looked up as int64 42 in a widened column, got %v", keys)
+	}
+	if _, ok := bloomKeys(schema.Int64, 1.5); ok {
+		t.Error("expected 1.5 not to be looked up in an int64 column")
+	}
+}
+
+// bloomTable writes 1000 orders in blocks of 100 with ids spread over the whole range in
+// every block, so min/max never rule one out
+func bloomTable(t *testing.T) (*Table, string) {
+	t.Helper()
+
+	s := schema.Schema{
+		Name:       "orders",
+		TimeColumn: "ts",
+		Columns: []schema.Column{
+			{Name: "ts", Type: schema.Int64},
+			{Name: "order_id", Type: schema.Int64, BloomFilter: true},
+			{Name: "trade_id", Type: schema.String, Nullable: true, BloomFilter: true},
+			{Name: "qty", Type: schema.Int64},
+		},
+	}
+
+	dir := t.TempDir()
+	tbl, err := CreateTable(s, nil, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tbl.MaxBlockSize = 100
+	tbl.UseDiskStorage = true
+
+	for i := 0; i < 1000; i++ {
+		row := map[string]any{
+			"ts":       int64(i),
+			"order_id": int64(i%100*1000 + i/100),
+			"trade_id": fmt.Sprintf("T%d", i%100*1000+i/100),
+			"qty":      int64(i),
+		}
+		if i%10 == 0 {
+			row["trade_id"] = nil
+		}
+		if err := tbl.AppendRow(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return tbl, dir
+}
+
+// readBlocks returns the flushed blocks e does not rule out, either by their stats or by
+// the bloom filters and page index of their file
+func readBlocks(t *testing.T, tbl *Table, e Expr) []int {
+	t.Helper()
+
+	r := tbl.Reader().Where(e)
+	defer r.Close()
+
+	var read []int
+	for i, block := range r.blocks {
+		if block.Storage != nil {
+			continue
+		}
+		skip, err := r.canSkipBlock(block)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if skip {
+			continue
+		}
+
+		pf, closeFile, err := block.openParquet()
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows, err := r.pageRows(block, pf)
+		closeFile()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rows == nil || len(rows) > 0 {
+			read = append(read, i)
+		}
+	}
+	return read
+}
+
+func TestBloomPruning(t *testing.T) {
+	tbl, dir := bloomTable(t)
+
+	pf, closeFile, err := tbl.coldBlocks[0].openParquet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, field := range pf.Schema().Fields() {
+		hasFilter := pf.RowGroups()[0].ColumnChunks()[i].BloomFilter() != nil
+		if want := field.Name() == "order_id" || field.Name() == "trade_id"; hasFilter != want {
+			t.Errorf("expected a filter for %s to be %v", field.Name(), want)
+		}
+	}
+	closeFile()
+
+	// order 42003 is row 342, in block 3
+	tests := []struct {
+		name string
+		expr Expr
+		read []int
+	}{
+		{"Int", Compare("order_id", "==", 42003), []int{3}},
+		{"String", Compare("trade_id", "==", "T42003"), []int{3}},
+		{"In", In("trade_id", "T42003", "T99009", "T5"), []int{3, 9}},
+		{"NullRowsLeftOut", Compare("trade_id", "==", "T3"), nil},
+		{"Missing", Compare("order_id", "==", 77777), nil},
+		{"NoFilter", Compare("qty", "==", 342), []int{3}},
+	}
+
+	check := func(t *testing.T, tbl *Table) {
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				read := readBlocks(t, tbl, tt.expr)
+				if fmt.Sprint(read) != fmt.Sprint(tt.read) {
+					t.Errorf("expected blocks %v to be read, got %v", tt.read, read)
+				}
+			})
+		}
+	}
+	check(t, tbl)
+
+	rows := scanTimes(t, tbl.Reader().Filter("order_id", "==", 42003))
+	if len(rows) != 1 || rows[0] != 342 {
+		t.Errorf("expected row 342, got %v", rows)
+	}
+
+	t.Run("Reload", func(t *testing.T) {
+		manifest, err := os.ReadFile(filepath.Join(dir, manifestFile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(manifest), "bloom") {
+			t.Error("expected the filters to stay out of the manifest")
+		}
+
+		reloaded, err := CreateTable(tbl.schema, nil, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := reloaded.LoadFromDisk(); err != nil {
+			t.Fatal(err)
+		}
+		check(t, reloaded)
+	})
+
+	t.Run("ReloadWithoutManifest", func(t *testing.T) {
+		copied := t.TempDir()
+		for _, b := range tbl.coldBlocks {
+			data, err := os.ReadFile(b.Path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(copied, filepath.Base(b.Path)), data, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		reloaded, err := CreateTable(tbl.schema, nil, copied)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := reloaded.LoadFromDisk(); err != nil {
+			t.Fatal(err)
+		}
+		if read := readBlocks(t, reloaded, Compare("order_id", "==", 42003)); fmt.Sprint(read) != "[3]" {
+			t.Errorf("expected the filters to come with the block files, read %v", read)
+		}
+	})
+
+	t.Run("Compaction", func(t *testing.T) {
+		if _, err := tbl.Compact(CompactionPolicy{TargetRows: 200}); err != nil {
+			t.Fatal(err)
+		}
+		if read := readBlocks(t, tbl, Compare("order_id", "==", 42003)); len(read) != 1 {
+			t.Errorf("expected the merged blocks to keep their filters, read %v", read)
+		}
+	})
+}
@@ -2,7 +2,9 @@ package table
 
 import (
 	"backtraceDB/internal/schema"
+	"cmp"
 	"fmt"
+	"slices"
 )
 
 type ColumnLocation struct {
@@ -16,6 +18,14 @@ type ColumnStorage struct {
 	StringCols  [][]int
 	StringDicts []map[string]int
 	StringReads [][]string //to make reads faster
+	BoolCols    []Bitmap
+
+	// Validity has one bitmap per logical column where a set bit marks a non-null row.
+	// A nil bitmap means the column has no nulls, so tables without nulls pay nothing.
+	// Null rows still hold the zero value in the typed slices to keep lengths aligned.
+	Validity []*Bitmap
+
+	locations []ColumnLocation
 }
 
 func NewColumnStorage(colTypes []schema.ColumnType) (*ColumnStorage, []ColumnLocation, error) {
@@ -25,6 +35,7 @@ func NewColumnStorage(colTypes []schema.ColumnType) (*ColumnStorage, []ColumnLoc
 	intIdx := 0
 	floatIdx := 0
 	stringIdx := 0
+	boolIdx := 0
 
 	for i, t := range colTypes {
 
@@ -46,12 +57,277 @@ func NewColumnStorage(colTypes []schema.ColumnType) (*ColumnStorage, []ColumnLoc
 			storage.StringReads = append(storage.StringReads, []string{})
 			location[i] = ColumnLocation{Type: t, Index: stringIdx}
 			stringIdx++
-		
+
+		case schema.Boolean:
+			storage.BoolCols = append(storage.BoolCols, Bitmap{})
+			location[i] = ColumnLocation{Type: t, Index: boolIdx}
+			boolIdx++
+
 		default:
 			return nil, nil, fmt.Errorf("unsupported column type: %v", t)
 		}
 
 	}
 
+	storage.Validity = make([]*Bitmap, len(colTypes))
+	storage.locations = location
+
 	return storage, location, nil
 }
+
+// IsNull reports whether row of the logical column col is null.
+func (s *ColumnStorage) IsNull(col, row int) bool {
+	v := s.Validity[col]
+	return v != nil && !v.Get(row)
+}
+
+// appendValidity records whether the row being appended at index row of the logical
+// column col is valid. The bitmap is only materialised once the first null shows up.
+func (s *ColumnStorage) appendValidity(col, row int, valid bool) {
+	v := s.Validity[col]
+	if v == nil {
+		if valid {
+			return
+		}
+		v = &Bitmap{}
+		v.AppendN(true, row)
+		s.Validity[col] = v
+	}
+	v.Append(valid)
+}
+
+// columnLen returns how many rows the logical column col holds.
+func (s *ColumnStorage) columnLen(col int) int {
+	loc := s.locations[col]
+	switch loc.Type {
+	case schema.Int64:
+		return len(s.Int64Cols[loc.Index])
+	case schema.Float64:
+		return len(s.Float64Cols[loc.Index])
+	case schema.String:
+		return len(s.StringCols[loc.Index])
+	case schema.Boolean:
+		return s.BoolCols[loc.Index].Len()
+	}
+	return 0
+}
+
+// AppendValue appends val to the logical column col, a nil val appends a null.
+// val must already have the Go type of the column.
+func (s *ColumnStorage) AppendValue(col int, val any) {
+	loc := s.locations[col]
+	s.appendValidity(col, s.columnLen(col), val != nil)
+
+	switch loc.Type {
+	case schema.Int64:
+		v, _ := val.(int64)
+		s.Int64Cols[loc.Index] = append(s.Int64Cols[loc.Index], v)
+	case schema.Float64:
+		v, _ := val.(float64)
+		s.Float64Cols[loc.Index] = append(s.Float64Cols[loc.Index], v)
+	case schema.String:
+		v, _ := val.(string)
+		s.appendString(loc.Index, v)
+	case schema.Boolean:
+		v, _ := val.(bool)
+		s.BoolCols[loc.Index].Append(v)
+	}
+}
+
+// AppendColumn appends n values held in a typed slice to the logical column col, a nil
+// vals appends n nulls. vals must have the slice type of the column.
+func (s *ColumnStorage) AppendColumn(col int, vals any, n int) {
+	if vals == nil {
+		for i := 0; i < n; i++ {
+			s.AppendValue(col, nil)
+		}
+		return
+	}
+
+	if v := s.Validity[col]; v != nil {
+		v.AppendN(true, n)
+	}
+
+	loc := s.locations[col]
+	switch loc.Type {
+	case schema.Int64:
+		s.Int64Cols[loc.Index] = append(s.Int64Cols[loc.Index], vals.([]int64)...)
+	case schema.Float64:
+		s.Float64Cols[loc.Index] = append(s.Float64Cols[loc.Index], vals.([]float64)...)
+	case schema.String:
+		for _, v := range vals.([]string) {
+			s.appendString(loc.Index, v)
+		}
+	case schema.Boolean:
+		for _, v := range vals.([]bool) {
+			s.BoolCols[loc.Index].Append(v)
+		}
+	}
+}
+
+func (s *ColumnStorage) appendString(idx int, v string) {
+	dict := s.StringDicts[idx]
+	id, exists := dict[v]
+	if !exists {
+		id = len(dict)
+		dict[v] = id
+		s.StringReads[idx] = append(s.StringReads[idx], v)
+	}
+	s.StringCols[idx] = append(s.StringCols[idx], id)
+}
+
+// Value returns row of the logical column col boxed in its Go type, nil for nulls.
+func (s *ColumnStorage) Value(col, row int) any {
+	if s.IsNull(col, row) {
+		return nil
+	}
+
+	loc := s.locations[col]
+	switch loc.Type {
+	case schema.Int64:
+		return s.Int64Cols[loc.Index][row]
+	case schema.Float64:
+		return s.Float64Cols[loc.Index][row]
+	case schema.String:
+		return s.StringReads[loc.Index][s.StringCols[loc.Index][row]]
+	case schema.Boolean:
+		return s.BoolCols[loc.Index].Get(row)
+	}
+	return nil
+}
+
+// snapshot returns a read-only view of the first rows rows. Appends to s only write past
+// the end of the shared typed slices, but bitmaps grow in place, so those are copied.
+// String dictionaries are left out, readers resolve ids through StringReads.
+func (s *ColumnStorage) snapshot(rows int) *ColumnStorage {
+	snap := &ColumnStorage{
+		Int64Cols:   make([][]int64, len(s.Int64Cols)),
+		Float64Cols: make([][]float64, len(s.Float64Cols)),
+		StringCols:  make([][]int, len(s.StringCols)),
+		StringReads: make([][]string, len(s.StringReads)),
+		BoolCols:    make([]Bitmap, len(s.BoolCols)),
+		Validity:    make([]*Bitmap, len(s.Validity)),
+		locations:   s.locations,
+	}
+
+	for i, col := range s.Int64Cols {
+		snap.Int64Cols[i] = col[:rows:rows]
+	}
+	for i, col := range s.Float64Cols {
+		snap.Float64Cols[i] = col[:rows:rows]
+	}
+	for i, col := range s.StringCols {
+		snap.StringCols[i] = col[:rows:rows]
+		snap.StringReads[i] = s.StringReads[i][:len(s.StringReads[i]):len(s.StringReads[i])]
+	}
+	for i := range s.BoolCols {
+		snap.BoolCols[i] = s.BoolCols[i].Clone(rows)
+	}
+	for i, validity := range s.Validity {
+		if validity != nil {
+			v := validity.Clone(rows)
+			snap.Validity[i] = &v
+		}
+	}
+
+	return snap
+}
+
+// appendRows appends the first n rows of src, which has the same column layout as s,
+// to the logical columns set in want. A nil want appends every column.
+func (s *ColumnStorage) appendRows(src *ColumnStorage, n int, want []bool) {
+	for col, loc := range s.locations {
+		if want != nil && !want[col] {
+			continue
+		}
+
+		if src.Validity[col] != nil || s.Validity[col] != nil {
+			base := s.columnLen(col)
+			for i := 0; i < n; i++ {
+				s.appendValidity(col, base+i, !src.IsNull(col, i))
+			}
+		}
+
+		switch loc.Type {
+		case schema.Int64:
+			s.Int64Cols[loc.Index] = append(s.Int64Cols[loc.Index], src.Int64Cols[loc.Index][:n]...)
+		case schema.Float64:
+			s.Float64Cols[loc.Index] = append(s.Float64Cols[loc.Index], src.Float64Cols[loc.Index][:n]...)
+		case schema.String:
+			for _, id := range src.StringCols[loc.Index][:n] {
+				s.appendString(loc.Index, src.StringReads[loc.Index][id])
+			}
+		case schema.Boolean:
+			for i := 0; i < n; i++ {
+				s.BoolCols[loc.Index].Append(src.BoolCols[loc.Index].Get(i))
+			}
+		}
+	}
+}
+
+// timeOrder returns the row order that sorts s by the int64 logical column col. Rows
+// with the same timestamp keep the order they were appended in.
+func (s *ColumnStorage) timeOrder(col int) []int {
+	ts := s.Int64Cols[s.locations[col].Index]
+
+	order := make([]int, len(ts))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(a, b int) int {
+		return cmp.Compare(ts[a], ts[b])
+	})
+	return order
+}
+
+// permute returns a copy of s holding the rows listed in order, in that order. String
+// dictionaries are shared with s. Columns that were not loaded into s stay empty.
+func (s *ColumnStorage) permute(order []int) *ColumnStorage {
+	out := &ColumnStorage{
+		Int64Cols:   make([][]int64, len(s.Int64Cols)),
+		Float64Cols: make([][]float64, len(s.Float64Cols)),
+		StringCols:  make([][]int, len(s.StringCols)),
+		StringDicts: s.StringDicts,
+		StringReads: s.StringReads,
+		BoolCols:    make([]Bitmap, len(s.BoolCols)),
+		Validity:    make([]*Bitmap, len(s.Validity)),
+		locations:   s.locations,
+	}
+
+	for col, loc := range s.locations {
+		if s.columnLen(col) == 0 {
+			continue
+		}
+
+		switch loc.Type {
+		case schema.Int64:
+			out.Int64Cols[loc.Index] = permuted(s.Int64Cols[loc.Index], order)
+		case schema.Float64:
+			out.Float64Cols[loc.Index] = permuted(s.Float64Cols[loc.Index], order)
+		case schema.String:
+			out.StringCols[loc.Index] = permuted(s.StringCols[loc.Index], order)
+		case schema.Boolean:
+			for _, row := range order {
+				out.BoolCols[loc.Index].Append(s.BoolCols[loc.Index].Get(row))
+			}
+		}
+
+		if v := s.Validity[col]; v != nil {
+			validity := &Bitmap{}
+			for _, row := range order {
+				validity.Append(v.Get(row))
+			}
+			out.Validity[col] = validity
+		}
+	}
+
+	return out
+}
+
+func permuted[T any](col []T, order []int) []T {
+	out := make([]T, len(order))
+	for i, row := range order {
+		out[i] = col[row]
+	}
+	return out
+}
@@ -0,0 +1,362 @@
+package table
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// CompactionPolicy decides which cold blocks get merged and how often the background
+// compactor looks for them. The zero value merges runs of at least two blocks up to
+// MaxBlockSize rows and never runs in the background.
+type CompactionPolicy struct {
+	Interval   time.Duration // time between background passes, 0 disables them
+	TargetRows int           // rows a merged block may grow to, MaxBlockSize when 0
+	MinBlocks  int           // fewest adjacent blocks worth merging, 2 when 0
+}
+
+func (p CompactionPolicy) validate() error {
+	if p.Interval < 0 {
+		return fmt.Errorf("compaction interval must not be negative, got %v", p.Interval)
+	}
+	if p.TargetRows < 0 {
+		return fmt.Errorf("compaction target must not be negative, got %d rows", p.TargetRows)
+	}
+	if p.MinBlocks != 0 && p.MinBlocks < 2 {
+		return fmt.Errorf("compaction needs at least 2 blocks to merge, got %d", p.MinBlocks)
+	}
+	return nil
+}
+
+// retiredFiles are block files compacted away while readers of an older snapshot may
+// still open them, they are deleted once the last of those readers is done.
+type retiredFiles struct {
+	epoch uint64
+	paths []string
+}
+
+// StartCompaction runs Compact with p every p.Interval until the table is closed or
+// StopCompaction is called. A failed pass is retried on the next tick and reported by
+// CompactionErr until then.
+func (t *Table) StartCompaction(p CompactionPolicy) error {
+	if err := p.validate(); err != nil {
+		return err
+	}
+
+	t.compactor.start(p.Interval, func() error {
+		_, err := t.Compact(p)
+		return err
+	})
+	return nil
+}
+
+// StopCompaction stops the background compactor, waiting for a running pass to finish.
+func (t *Table) StopCompaction() {
+	t.compactor.halt()
+}
+
+// CompactionErr returns the error of the last background pass, nil once one succeeds.
+func (t *Table) CompactionErr() error {
+	return t.compactor.lastErr()
+}
+
+// Compact merges runs of adjacent small blocks on disk into blocks of up to
+// p.TargetRows rows and returns how many merged blocks it wrote. Blocks whose time
+// ranges overlap because of rows that arrived late are always merged, sorted by time
+// and split again into blocks of up to p.TargetRows rows. The merged blocks replace
+// their run in the manifest in a single entry, so a crash leaves either the old blocks
+// or the new ones. Readers keep working meanwhile: a snapshot taken before the swap
+// still reads the old files, which are only deleted once it is done. Runs never cross
+// a partition boundary.
+func (t *Table) Compact(p CompactionPolicy) (int, error) {
+	return t.compact(p, nil)
+}
+
+// compact merges the runs among the blocks include accepts, every block when nil.
+func (t *Table) compact(p CompactionPolicy, include func(*Block) bool) (int, error) {
+	if err := p.validate(); err != nil {
+		return 0, err
+	}
+
+	// one pass at a time, a second one would plan runs the first is already merging
+	t.compactMu.Lock()
+	defer t.compactMu.Unlock()
+
+	runs, target := t.planCompaction(p, include)
+
+	written := 0
+	for _, run := range runs {
+		n, err := t.mergeRun(run, target)
+		if err != nil {
+			return written, err
+		}
+		written += n
+	}
+
+	return written, nil
+}
+
+// planCompaction picks the runs to merge and the number of rows a merged block may
+// hold. Only blocks on disk are merged, blocks of a table without disk storage stay as
+// they are.
+func (t *Table) planCompaction(p CompactionPolicy, include func(*Block) bool) ([][]*Block, int) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	target := p.TargetRows
+	if target == 0 {
+		target = t.MaxBlockSize
+	}
+	minBlocks := p.MinBlocks
+	if minBlocks == 0 {
+		minBlocks = 2
+	}
+
+	var runs [][]*Block
+	var run []*Block
+	rows := 0
+
+	flush := func() {
+		if len(run) >= minBlocks {
+			runs = append(runs, run)
+		}
+		run, rows = nil, 0
+	}
+
+	// the cold blocks are sorted by MaxTs, so a block overlaps the group before it when
+	// it starts before the newest row of the group
+	var group []*Block
+	var groupMax int64
+
+	emit := func() {
+		switch {
+		case len(group) > 1:
+			flush()
+			runs = append(runs, group)
+		case len(group) == 1:
+			block := group[0]
+			if block.RowCount >= target {
+				flush()
+				break
+			}
+			if rows+block.RowCount > target {
+				flush()
+			}
+			run = append(run, block)
+			rows += block.RowCount
+		}
+		group = nil
+	}
+
+	partition := ""
+
+	for _, block := range t.coldBlocks {
+		if !block.isOnDisk || (include != nil && !include(block)) {
+			emit()
+			flush()
+			continue
+		}
+		if block.Partition != partition {
+			emit()
+			flush()
+			partition = block.Partition
+		}
+		if len(group) > 0 && block.MinTs >= groupMax {
+			emit()
+		}
+		if len(group) == 0 || block.MaxTs > groupMax {
+			groupMax = block.MaxTs
+		}
+		group = append(group, block)
+	}
+	emit()
+	flush()
+
+	return runs, target
+}
+
+// mergeRun writes the rows of run into blocks of up to target rows, sorted by time, and
+// swaps them in. It returns how many blocks it wrote, none when the run changed while
+// it was merged.
+func (t *Table) mergeRun(run []*Block, target int) (int, error) {
+	t.mu.RLock()
+	s, locations, timeIdx := t.schema, t.locations, t.timeColIdx
+	t.mu.RUnlock()
+
+	// the blocks of the run are immutable on disk, so they are read without the lock
+	storage, _, err := NewColumnStorage(columnTypes(s))
+	if err != nil {
+		return 0, err
+	}
+
+	sorted := true
+	for i, block := range run {
+		if err := block.LoadInto(storage, s, locations); err != nil {
+			return 0, fmt.Errorf("failed to read block %s for compaction: %v", filepath.Base(block.Path), err)
+		}
+		if i > 0 && block.MinTs < run[i-1].MaxTs {
+			sorted = false
+		}
+	}
+
+	rows := storage.columnLen(timeIdx)
+	if !sorted {
+		storage = storage.permute(storage.timeOrder(timeIdx))
+	}
+	ts := storage.Int64Cols[locations[timeIdx].Index]
+
+	var maxLSN uint64
+	for _, block := range run {
+		maxLSN = max(maxLSN, block.MaxLSN)
+	}
+
+	// split evenly rather than leaving a small block at the end
+	chunks := (rows + target - 1) / target
+	size := (rows + chunks - 1) / chunks
+
+	var merged []*Block
+	removeMerged := func() {
+		for _, block := range merged {
+			os.Remove(block.Path)
+		}
+	}
+
+	for lo := 0; lo < rows; lo += size {
+		hi := min(lo+size, rows)
+
+		block := &Block{
+			Storage:       storage,
+			RowCount:      hi - lo,
+			MinTs:         ts[lo],
+			MaxTs:         ts[hi-1],
+			SchemaVersion: s.Version,
+			MaxLSN:        maxLSN,
+			Partition:     run[0].Partition,
+		}
+		if chunks > 1 {
+			order := make([]int, hi-lo)
+			for i := range order {
+				order[i] = lo + i
+			}
+			block.Storage = storage.permute(order)
+		}
+		block.allocStats(columnTypes(s))
+
+		t.mu.Lock()
+		path := t.blockPath(block)
+		t.mu.Unlock()
+
+		if err := block.Rotate(true, path, s, locations); err != nil {
+			removeMerged()
+			return 0, fmt.Errorf("failed to write compacted block: %v", err)
+		}
+		merged = append(merged, block)
+	}
+
+	t.mu.Lock()
+
+	start := slices.Index(t.coldBlocks, run[0])
+	if start == -1 || start+len(run) > len(t.coldBlocks) || !slices.Equal(t.coldBlocks[start:start+len(run)], run) {
+		t.mu.Unlock()
+		removeMerged()
+		return 0, nil
+	}
+
+	var entry manifestEntry
+	paths := make([]string, len(run))
+	for _, block := range merged {
+		entry.Add = append(entry.Add, newBlockMeta(block))
+	}
+	for i, block := range run {
+		entry.Remove = append(entry.Remove, filepath.Base(block.Path))
+		paths[i] = block.Path
+	}
+
+	if err := appendManifest(t.dir, entry); err != nil {
+		t.mu.Unlock()
+		removeMerged()
+		return 0, fmt.Errorf("failed to record compacted block in manifest: %v", err)
+	}
+
+	t.coldBlocks = slices.Replace(t.coldBlocks, start, start+len(run), merged...)
+
+	t.retire(paths)
+	t.mu.Unlock()
+
+	return len(merged), t.removeRetired()
+}
+
+// retire queues paths for deletion once no reader of the current snapshot is left and
+// starts a new epoch for readers taken from now on, callers must hold t.mu.
+func (t *Table) retire(paths []string) {
+	t.filesMu.Lock()
+	defer t.filesMu.Unlock()
+
+	t.retired = append(t.retired, retiredFiles{epoch: t.epoch, paths: paths})
+	t.epoch++
+}
+
+// pin records a reader of the current epoch, callers must hold t.mu.
+func (t *Table) pin() uint64 {
+	t.filesMu.Lock()
+	defer t.filesMu.Unlock()
+
+	t.readers[t.epoch]++
+	return t.epoch
+}
+
+// liveReaders counts the readers that have not finished or been closed yet.
+func (t *Table) liveReaders() int {
+	t.filesMu.Lock()
+	defer t.filesMu.Unlock()
+
+	n := 0
+	for _, count := range t.readers {
+		n += count
+	}
+	return n
+}
+
+func (t *Table) unpin(epoch uint64) error {
+	t.filesMu.Lock()
+	t.readers[epoch]--
+	if t.readers[epoch] == 0 {
+		delete(t.readers, epoch)
+	}
+	t.filesMu.Unlock()
+
+	return t.removeRetired()
+}
+
+// removeRetired deletes the retired files no live reader can reach anymore. Readers
+// that are never finished or closed keep their files around until the next start,
+// where LoadFromDisk removes every file the manifest no longer lists.
+func (t *Table) removeRetired() error {
+	t.filesMu.Lock()
+	defer t.filesMu.Unlock()
+
+	oldest := t.epoch
+	for epoch := range t.readers {
+		oldest = min(oldest, epoch)
+	}
+
+	kept := t.retired[:0]
+	var firstErr error
+	for _, r := range t.retired {
+		// a reader of epoch e sees every file retired in epoch e or later
+		if r.epoch >= oldest {
+			kept = append(kept, r)
+			continue
+		}
+		for _, path := range r.paths {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) && firstErr == nil {
+				firstErr = fmt.Errorf("failed to remove compacted block %s: %v", filepath.Base(path), err)
+			}
+		}
+	}
+	t.retired = kept
+
+	return firstErr
+}
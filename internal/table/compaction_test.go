@@ -0,0 +1,277 @@
+package table
+
+import (
+	"backtraceDB/internal/schema"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// smallBlocks writes 10 rows into 5 blocks of 2 on disk, ts 0 to 90
+func smallBlocks(t *testing.T) (*Table, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	tbl, err := CreateTable(manifestSchema(), nil, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tbl.MaxBlockSize = 2
+	tbl.UseDiskStorage = true
+
+	for i := 0; i < 10; i++ {
+		row := map[string]any{"ts": int64(i * 10), "price": float64(i), "is_buy": i%2 == 0}
+		if err := tbl.AppendRow(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return tbl, dir
+}
+
+// scanTimes returns the ts column of every row in scan order
+func scanTimes(t *testing.T, r *TableReader) []int64 {
+	t.Helper()
+
+	var times []int64
+	for {
+		row, ok := r.Next()
+		if !ok {
+			break
+		}
+		times = append(times, row["ts"].(int64))
+	}
+	if err := r.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return times
+}
+
+func checkTimes(t *testing.T, times []int64, n int) {
+	t.Helper()
+
+	if len(times) != n {
+		t.Fatalf("expected %d rows, got %d", n, len(times))
+	}
+	for i, ts := range times {
+		if ts != int64(i*10) {
+			t.Fatalf("expected rows in time order, got %v", times)
+		}
+	}
+}
+
+func blockFiles(t *testing.T, dir string) []string {
+	t.Helper()
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.parquet"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestCompaction(t *testing.T) {
+	t.Run("MergesRuns", func(t *testing.T) {
+		tbl, dir := smallBlocks(t)
+
+		n, err := tbl.Compact(CompactionPolicy{TargetRows: 6})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 2 {
+			t.Errorf("expected 2 merged blocks, got %d", n)
+		}
+		if len(tbl.coldBlocks) != 2 {
+			t.Fatalf("expected 5 blocks to become 2, got %d", len(tbl.coldBlocks))
+		}
+
+		first, second := tbl.coldBlocks[0], tbl.coldBlocks[1]
+		if first.RowCount != 6 || first.MinTs != 0 || first.MaxTs != 50 {
+			t.Errorf("expected the first block to hold ts 0 to 50 in 6 rows, got %d to %d in %d", first.MinTs, first.MaxTs, first.RowCount)
+		}
+		if second.RowCount != 4 || second.MinTs != 60 || second.MaxTs != 90 {
+			t.Errorf("expected the second block to hold ts 60 to 90 in 4 rows, got %d to %d in %d", second.MinTs, second.MaxTs, second.RowCount)
+		}
+		if first.FloatMin[0] != 0 || first.FloatMax[0] != 5 || first.BoolMin[0] || !first.BoolMax[0] {
+			t.Errorf("expected the stats to be rebuilt, got price %v to %v", first.FloatMin[0], first.FloatMax[0])
+		}
+		if files := blockFiles(t, dir); len(files) != 2 {
+			t.Errorf("expected the compacted files to be deleted, found %d", len(files))
+		}
+		if err := tbl.Verify(); err != nil {
+			t.Error(err)
+		}
+
+		checkTimes(t, scanTimes(t, tbl.Reader()), 10)
+
+		// pruning works off the new stats
+		times := scanTimes(t, tbl.Reader().Filter("ts", ">", int64(55)))
+		if len(times) != 4 || times[0] != 60 {
+			t.Errorf("expected ts 60 to 90, got %v", times)
+		}
+	})
+
+	t.Run("Policy", func(t *testing.T) {
+		tbl, _ := smallBlocks(t)
+
+		// no two neighbours fit into 3 rows
+		if n, err := tbl.Compact(CompactionPolicy{TargetRows: 3}); err != nil || n != 0 {
+			t.Errorf("expected nothing to merge, got %d (%v)", n, err)
+		}
+
+		// only one run of 3 blocks fits into 6 rows, the rest holds 2
+		if n, err := tbl.Compact(CompactionPolicy{TargetRows: 6, MinBlocks: 3}); err != nil || n != 1 {
+			t.Errorf("expected one merged block, got %d (%v)", n, err)
+		}
+		if len(tbl.coldBlocks) != 3 {
+			t.Errorf("expected 3 blocks, got %d", len(tbl.coldBlocks))
+		}
+
+		if _, err := tbl.Compact(CompactionPolicy{MinBlocks: 1}); err == nil {
+			t.Error("expected a run of 1 block to be rejected")
+		}
+	})
+
+	t.Run("Reload", func(t *testing.T) {
+		tbl, dir := smallBlocks(t)
+		if _, err := tbl.Compact(CompactionPolicy{TargetRows: 10}); err != nil {
+			t.Fatal(err)
+		}
+		if err := tbl.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		reloaded, err := reload(t, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(reloaded.coldBlocks) != 1 || reloaded.RowCount() != 10 {
+			t.Fatalf("expected 1 block of 10 rows, got %d blocks of %d rows", len(reloaded.coldBlocks), reloaded.RowCount())
+		}
+		checkTimes(t, scanTimes(t, reloaded.Reader()), 10)
+
+		// IDs keep counting past the merged block
+		if reloaded.nextBlockID != tbl.nextBlockID {
+			t.Errorf("expected the next block ID to be %d, got %d", tbl.nextBlockID, reloaded.nextBlockID)
+		}
+	})
+
+	t.Run("ReaderKeepsFiles", func(t *testing.T) {
+		tbl, dir := smallBlocks(t)
+
+		r := tbl.Reader()
+		if _, ok := r.Next(); !ok {
+			t.Fatal("expected a first row")
+		}
+
+		if _, err := tbl.Compact(CompactionPolicy{TargetRows: 10}); err != nil {
+			t.Fatal(err)
+		}
+		if files := blockFiles(t, dir); len(files) != 6 {
+			t.Fatalf("expected the old files to stay while a reader uses them, found %d", len(files))
+		}
+
+		// the old snapshot reads its blocks, a new one the merged block
+		rest := scanTimes(t, r)
+		checkTimes(t, append([]int64{0}, rest...), 10)
+		checkTimes(t, scanTimes(t, tbl.Reader()), 10)
+
+		if files := blockFiles(t, dir); len(files) != 1 {
+			t.Errorf("expected the old files to go once the reader is done, found %d", len(files))
+		}
+	})
+
+	t.Run("AbandonedReader", func(t *testing.T) {
+		tbl, dir := smallBlocks(t)
+
+		tbl.Reader()
+		if _, err := tbl.Compact(CompactionPolicy{TargetRows: 10}); err != nil {
+			t.Fatal(err)
+		}
+
+		// the files outlive the table, the manifest no longer lists them
+		reloaded, err := reload(t, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if files := blockFiles(t, dir); len(files) != 1 {
+			t.Errorf("expected the compacted files to be removed on load, found %d", len(files))
+		}
+		checkTimes(t, scanTimes(t, reloaded.Reader()), 10)
+	})
+
+	t.Run("OldSchemaVersion", func(t *testing.T) {
+		tbl, _ := smallBlocks(t)
+
+		next, err := tbl.PlanAlter(schema.AddColumn(schema.Column{Name: "venue", Type: schema.String, Default: "NYSE"}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tbl.Alter(next); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := tbl.Compact(CompactionPolicy{TargetRows: 10}); err != nil {
+			t.Fatal(err)
+		}
+		if v := tbl.coldBlocks[0].SchemaVersion; v != next.Version {
+			t.Errorf("expected the merged block to use version %d, got %d", next.Version, v)
+		}
+
+		r := tbl.Reader().Filter("venue", "==", "NYSE")
+		checkTimes(t, scanTimes(t, r), 10)
+	})
+
+	t.Run("Background", func(t *testing.T) {
+		tbl, dir := smallBlocks(t)
+
+		if err := tbl.StartCompaction(CompactionPolicy{Interval: 5 * time.Millisecond, TargetRows: 10}); err != nil {
+			t.Fatal(err)
+		}
+
+		// appends and scans go on while the compactor runs
+		for i := 10; i < 20; i++ {
+			row := map[string]any{"ts": int64(i * 10), "price": float64(i), "is_buy": true}
+			if err := tbl.AppendRow(row); err != nil {
+				t.Fatal(err)
+			}
+			checkTimes(t, scanTimes(t, tbl.Reader()), i+1)
+		}
+
+		// runs merged while rows still came in may leave up to 3 blocks that cannot be
+		// merged any further within 10 rows
+		deadline := time.Now().Add(5 * time.Second)
+		for len(blockFiles(t, dir)) > 3 && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+
+		if err := tbl.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := tbl.CompactionErr(); err != nil {
+			t.Error(err)
+		}
+		if files := blockFiles(t, dir); len(files) > 3 {
+			t.Errorf("expected 20 rows to end up in at most 3 blocks, found %d files", len(files))
+		}
+		checkTimes(t, scanTimes(t, tbl.Reader()), 20)
+	})
+
+	t.Run("InMemory", func(t *testing.T) {
+		tbl, err := CreateTable(manifestSchema(), nil, t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		tbl.MaxBlockSize = 2
+		for i := 0; i < 6; i++ {
+			tbl.AppendRow(map[string]any{"ts": int64(i), "price": 1.0, "is_buy": true})
+		}
+
+		if n, err := tbl.Compact(CompactionPolicy{TargetRows: 10}); err != nil || n != 0 {
+			t.Errorf("expected blocks without files to be left alone, got %d (%v)", n, err)
+		}
+		if _, err := os.Stat(filepath.Join(tbl.dir, manifestFile)); !os.IsNotExist(err) {
+			t.Errorf("expected no manifest for a table in memory, got %v", err)
+		}
+	})
+}
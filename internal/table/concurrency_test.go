@@ -0,0 +1,163 @@
+package table
+
+import (
+	"backtraceDB/internal/schema"
+	"sync"
+	"testing"
+)
+
+func concurrencySchema() schema.Schema {
+	return schema.Schema{
+		Name:       "concurrency_test",
+		TimeColumn: "ts",
+		Columns: []schema.Column{
+			{Name: "ts", Type: schema.Int64},
+			{Name: "feed", Type: schema.Int64},
+			{Name: "symbol", Type: schema.String},
+			{Name: "is_buy", Type: schema.Boolean},
+		},
+	}
+}
+
+func countRows(t *testing.T, r *TableReader) int {
+	t.Helper()
+
+	n := 0
+	for {
+		if _, ok := r.Next(); !ok {
+			break
+		}
+		n++
+	}
+	return n
+}
+
+func TestReaderSnapshot(t *testing.T) {
+	tbl, err := CreateTable(concurrencySchema(), nil, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tbl.MaxBlockSize = 100
+	tbl.UseDiskStorage = true
+
+	appendRows := func(from, to int) {
+		for i := from; i < to; i++ {
+			row := map[string]any{"ts": int64(i), "feed": int64(0), "symbol": "AAPL", "is_buy": i%2 == 0}
+			if err := tbl.AppendRow(row); err != nil {
+				t.Fatalf("failed to append row %d: %v", i, err)
+			}
+		}
+	}
+
+	appendRows(0, 150)
+	r := tbl.Reader()
+	filtered := tbl.Reader().Filter("is_buy", "==", true)
+
+	// rotates the block the snapshot was cut from and flushes two more
+	appendRows(150, 400)
+
+	if r.RowCount() != 150 {
+		t.Errorf("expected snapshot row count 150, got %d", r.RowCount())
+	}
+	if n := countRows(t, r); n != 150 {
+		t.Errorf("expected 150 rows in snapshot, got %d", n)
+	}
+	if n := countRows(t, filtered); n != 75 {
+		t.Errorf("expected 75 buys in snapshot, got %d", n)
+	}
+	if n := countRows(t, tbl.Reader()); n != 400 {
+		t.Errorf("expected 400 rows in a new reader, got %d", n)
+	}
+}
+
+// TestConcurrentAppendAndRead is meant to be run with -race. Several feeds append while
+// readers scan and the schema is altered underneath them.
+func TestConcurrentAppendAndRead(t *testing.T) {
+	tbl, err := CreateTable(concurrencySchema(), nil, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tbl.MaxBlockSize = 500
+	tbl.UseDiskStorage = true
+
+	const feeds = 4
+	const rowsPerFeed = 2000
+
+	var writers sync.WaitGroup
+	for f := 0; f < feeds; f++ {
+		writers.Add(1)
+		go func(feed int) {
+			defer writers.Done()
+			for i := 0; i < rowsPerFeed; i++ {
+				// every feed writes the same tick so appends from different goroutines
+				// never break the time ordering
+				row := map[string]any{"ts": int64(0), "feed": int64(feed), "symbol": "AAPL", "is_buy": i%2 == 0}
+				if err := tbl.AppendRow(row); err != nil {
+					t.Errorf("feed %d: failed to append row %d: %v", feed, i, err)
+					return
+				}
+			}
+		}(f)
+	}
+
+	writers.Add(1)
+	go func() {
+		defer writers.Done()
+		next, err := tbl.PlanAlter(schema.AddColumn(schema.Column{Name: "venue", Type: schema.String, Nullable: true}))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if err := tbl.Alter(next); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	done := make(chan struct{})
+	var readers sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			last := 0
+			for scan := 0; scan < 50; scan++ {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				r := tbl.Reader()
+				n := countRows(t, r)
+				if n != r.RowCount() {
+					t.Errorf("reader saw %d rows but its snapshot holds %d", n, r.RowCount())
+					return
+				}
+				if n < last {
+					t.Errorf("row count went backwards from %d to %d", last, n)
+					return
+				}
+				last = n
+
+				if n := countRows(t, tbl.Reader().Filter("feed", "==", int64(1))); n > rowsPerFeed {
+					t.Errorf("expected at most %d rows from feed 1, got %d", rowsPerFeed, n)
+					return
+				}
+			}
+		}()
+	}
+
+	writers.Wait()
+	close(done)
+	readers.Wait()
+
+	if tbl.RowCount() != feeds*rowsPerFeed {
+		t.Errorf("expected %d rows, got %d", feeds*rowsPerFeed, tbl.RowCount())
+	}
+	if n := countRows(t, tbl.Reader()); n != feeds*rowsPerFeed {
+		t.Errorf("expected to read %d rows, got %d", feeds*rowsPerFeed, n)
+	}
+	if n := countRows(t, tbl.Reader().Filter("feed", "==", int64(2))); n != rowsPerFeed {
+		t.Errorf("expected %d rows from feed 2, got %d", rowsPerFeed, n)
+	}
+}
@@ -0,0 +1,343 @@
+package table
+
+import (
+	"backtraceDB/internal/schema"
+	"fmt"
+)
+
+// set predicates take a slice of values, of the column type or of any
+const (
+	OpIn    = "IN"
+	OpNotIn = "NOT IN"
+)
+
+// Expr is a filter built from predicates with And, Or and Not. Nulls follow SQL: a
+// comparison with a null is neither true nor false, Not keeps it that way, and only the
+// rows the whole expression is true for match.
+type Expr interface {
+	// negate returns the expression that is true where e is false, with the same nulls
+	negate() Expr
+}
+
+type andExpr []Expr
+
+type orExpr []Expr
+
+type notExpr struct{ expr Expr }
+
+// Compare is the predicate colName op value, as Filter takes it.
+func Compare(colName, op string, value any) Expr {
+	return Predicate{ColName: colName, Op: op, Value: value}
+}
+
+// And matches the rows every expression matches, all rows when there are none.
+func And(exprs ...Expr) Expr { return andExpr(exprs) }
+
+// Or matches the rows any expression matches, no rows when there are none.
+func Or(exprs ...Expr) Expr { return orExpr(exprs) }
+
+// Not matches the rows e is false for. Rows e is unknown for, because of a null, match
+// neither e nor Not(e).
+func Not(e Expr) Expr { return notExpr{e} }
+
+// In matches the rows whose colName is one of values.
+func In(colName string, values ...any) Expr {
+	return Predicate{ColName: colName, Op: OpIn, Value: values}
+}
+
+// Between matches the rows with lo <= colName <= hi.
+func Between(colName string, lo, hi any) Expr {
+	return And(Compare(colName, ">=", lo), Compare(colName, "<=", hi))
+}
+
+var negatedOps = map[string]string{
+	"==":        "!=",
+	"!=":        "==",
+	">":         "<=",
+	"<=":        ">",
+	"<":         ">=",
+	">=":        "<",
+	OpIsNull:    OpIsNotNull,
+	OpIsNotNull: OpIsNull,
+	OpIn:        OpNotIn,
+	OpNotIn:     OpIn,
+	OpPrefix:    OpNotPrefix,
+	OpNotPrefix: OpPrefix,
+	OpLike:      OpNotLike,
+	OpNotLike:   OpLike,
+	OpRegex:     OpNotRegex,
+	OpNotRegex:  OpRegex,
+}
+
+// comparisons with a null are false both ways, so flipping the operator keeps nulls out
+func (p Predicate) negate() Expr {
+	p.Op = negatedOps[p.Op]
+	return p
+}
+
+func (e andExpr) negate() Expr {
+	out := make(orExpr, len(e))
+	for i, child := range e {
+		out[i] = child.negate()
+	}
+	return out
+}
+
+func (e orExpr) negate() Expr {
+	out := make(andExpr, len(e))
+	for i, child := range e {
+		out[i] = child.negate()
+	}
+	return out
+}
+
+func (e notExpr) negate() Expr { return e.expr }
+
+// checkExpr checks every predicate of e and pushes Not down to them, so the tree that
+// is evaluated only holds And, Or and predicates.
+func (tr *TableReader) checkExpr(e Expr) (Expr, error) {
+	switch e := e.(type) {
+	case Predicate:
+		return tr.checkPredicate(e)
+	case andExpr:
+		out := make(andExpr, len(e))
+		for i, child := range e {
+			c, err := tr.checkExpr(child)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = c
+		}
+		return out, nil
+	case orExpr:
+		out := make(orExpr, len(e))
+		for i, child := range e {
+			c, err := tr.checkExpr(child)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = c
+		}
+		return out, nil
+	case notExpr:
+		c, err := tr.checkExpr(e.expr)
+		if err != nil {
+			return nil, err
+		}
+		return c.negate(), nil
+	}
+	return nil, fmt.Errorf("unsupported expression %T", e)
+}
+
+// checkSet turns the values of an IN predicate on col into a set of the column type.
+func checkSet(col schema.Column, value any) (any, error) {
+	var values []any
+	switch v := value.(type) {
+	case []any:
+		values = append([]any(nil), v...)
+	case []int:
+		values = boxAll(v)
+	case []int64:
+		values = boxAll(v)
+	case []float64:
+		values = boxAll(v)
+	case []string:
+		values = boxAll(v)
+	case []bool:
+		values = boxAll(v)
+	default:
+		return nil, fmt.Errorf("invalid value type for %s on column %s: %T, expected a slice", OpIn, col.Name, value)
+	}
+
+	for i, v := range values {
+		converted, err := convertValue(col, v)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = converted
+	}
+
+	switch col.Type {
+	case schema.Int64:
+		return toSet[int64](values), nil
+	case schema.Float64:
+		return toSet[float64](values), nil
+	case schema.String:
+		return toSet[string](values), nil
+	case schema.Boolean:
+		return toSet[bool](values), nil
+	}
+	return nil, fmt.Errorf("unsupported column type: %v", col.Type)
+}
+
+func boxAll[T any](vs []T) []any {
+	out := make([]any, len(vs))
+	for i, v := range vs {
+		out[i] = v
+	}
+	return out
+}
+
+func toSet[T comparable](values []any) map[T]bool {
+	set := make(map[T]bool, len(values))
+	for _, v := range values {
+		set[v.(T)] = true
+	}
+	return set
+}
+
+// setValues returns the members of a checked IN set, ok is false for any other value.
+func setValues(set any) (out []any, ok bool) {
+	switch s := set.(type) {
+	case map[int64]bool:
+		for v := range s {
+			out = append(out, v)
+		}
+	case map[float64]bool:
+		for v := range s {
+			out = append(out, v)
+		}
+	case map[string]bool:
+		for v := range s {
+			out = append(out, v)
+		}
+	case map[bool]bool:
+		for v := range s {
+			out = append(out, v)
+		}
+	default:
+		return nil, false
+	}
+	return out, true
+}
+
+// inSet reports whether val is a member of a checked IN set.
+func inSet(set any, val any) bool {
+	switch s := set.(type) {
+	case map[int64]bool:
+		v, ok := val.(int64)
+		return ok && s[v]
+	case map[float64]bool:
+		v, ok := val.(float64)
+		return ok && s[v]
+	case map[string]bool:
+		v, ok := val.(string)
+		return ok && s[v]
+	case map[bool]bool:
+		v, ok := val.(bool)
+		return ok && s[v]
+	}
+	return false
+}
+
+// skipSet reports whether the block stats prove no row of block matches the IN or NOT
+// IN predicate p. IN is ruled out when every member is, NOT IN when every row holds a
+// single member, which the stats show as a != that can be skipped.
+func (tr *TableReader) skipSet(block *Block, col schema.Column, p Predicate) (bool, error) {
+	values, ok := setValues(p.Value)
+	if !ok {
+		set, err := checkSet(col, p.Value)
+		if err != nil {
+			return false, err
+		}
+		values, _ = setValues(set)
+	}
+
+	if p.Op == OpNotIn && len(values) == 0 {
+		return tr.CanSkip(block, Predicate{ColName: p.ColName, Op: OpIsNotNull})
+	}
+
+	for _, v := range values {
+		op := "=="
+		if p.Op == OpNotIn {
+			op = "!="
+		}
+		skip, err := tr.CanSkip(block, Predicate{ColName: p.ColName, Op: op, Value: v})
+		if err != nil {
+			return false, err
+		}
+		if skip == (p.Op == OpNotIn) {
+			return skip, nil
+		}
+	}
+	return p.Op == OpIn, nil
+}
+
+// prune reports whether e is false for every row, given leaf telling whether a single
+// predicate is. An And is ruled out by any of its children, an Or only by all of them.
+func prune(e Expr, leaf func(Predicate) (bool, error)) (bool, error) {
+	switch e := e.(type) {
+	case Predicate:
+		return leaf(e)
+	case andExpr:
+		for _, child := range e {
+			skip, err := prune(child, leaf)
+			if err != nil || skip {
+				return skip, err
+			}
+		}
+		return false, nil
+	case orExpr:
+		for _, child := range e {
+			skip, err := prune(child, leaf)
+			if err != nil || !skip {
+				return false, err
+			}
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// walkPredicates calls fn for every predicate of e.
+func walkPredicates(e Expr, fn func(Predicate)) {
+	switch e := e.(type) {
+	case Predicate:
+		fn(e)
+	case andExpr:
+		for _, child := range e {
+			walkPredicates(child, fn)
+		}
+	case orExpr:
+		for _, child := range e {
+			walkPredicates(child, fn)
+		}
+	}
+}
+
+// applyExpr clears the rows of mask that e does not match. The children of an Or only
+// look at the rows no earlier child matched.
+func (tr *TableReader) applyExpr(e Expr, mask []bool) error {
+	switch e := e.(type) {
+	case Predicate:
+		return tr.applyPredicate(e, mask)
+	case andExpr:
+		for _, child := range e {
+			if err := tr.applyExpr(child, mask); err != nil {
+				return err
+			}
+		}
+		return nil
+	case orExpr:
+		pending := make([]bool, len(mask))
+		matched := make([]bool, len(mask))
+		copy(pending, mask)
+
+		childMask := make([]bool, len(mask))
+		for _, child := range e {
+			copy(childMask, pending)
+			if err := tr.applyExpr(child, childMask); err != nil {
+				return err
+			}
+			for i, ok := range childMask {
+				if ok {
+					matched[i] = true
+					pending[i] = false
+				}
+			}
+		}
+		copy(mask, matched)
+		return nil
+	}
+	return fmt.Errorf("unsupported expression %T", e)
+}
@@ -0,0 +1,310 @@
+package table
+
+import (
+	"backtraceDB/internal/schema"
+	"testing"
+)
+
+// exprTable writes 1000 rows in blocks of 100, ts from 0 to 999, and returns them too so
+// the expected matches can be counted by hand. price is null on every 7th row.
+func exprTable(t *testing.T) (*Table, []map[string]any) {
+	t.Helper()
+
+	s := schema.Schema{
+		Name:       "expr",
+		TimeColumn: "ts",
+		Columns: []schema.Column{
+			{Name: "ts", Type: schema.Int64},
+			{Name: "symbol", Type: schema.String},
+			{Name: "price", Type: schema.Float64, Nullable: true},
+			{Name: "qty", Type: schema.Int64},
+		},
+	}
+
+	tbl, err := CreateTable(s, nil, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tbl.MaxBlockSize = 100
+	tbl.UseDiskStorage = true
+
+	var rows []map[string]any
+	for i := 0; i < 1000; i++ {
+		row := map[string]any{
+			"ts":     int64(i),
+			"symbol": []string{"BTC", "ETH", "SOL"}[i%3],
+			"price":  float64(i%200) + 0.5,
+			"qty":    int64(i * 3),
+		}
+		if i%7 == 0 {
+			row["price"] = nil
+		}
+		if err := tbl.AppendRow(row); err != nil {
+			t.Fatal(err)
+		}
+		rows = append(rows, row)
+	}
+	return tbl, rows
+}
+
+func TestExpr(t *testing.T) {
+	tbl, rows := exprTable(t)
+
+	price := func(row map[string]any) (float64, bool) {
+		p, ok := row["price"].(float64)
+		return p, ok
+	}
+
+	tests := []struct {
+		name  string
+		expr  Expr
+		match func(row map[string]any) bool
+	}{
+		{
+			"InAndOr",
+			And(In("symbol", "BTC", "ETH"), Or(Compare("price", "<", 100), Compare("qty", ">", 2700))),
+			func(row map[string]any) bool {
+				p, ok := price(row)
+				return row["symbol"] != "SOL" && ((ok && p < 100) || row["qty"].(int64) > 2700)
+			},
+		},
+		{
+			"NotKeepsNullsOut",
+			Not(Compare("price", "<", 100)),
+			func(row map[string]any) bool {
+				p, ok := price(row)
+				return ok && p >= 100
+			},
+		},
+		{
+			"NotOr",
+			Not(Or(Compare("symbol", "==", "BTC"), Compare("price", OpIsNull, nil))),
+			func(row map[string]any) bool {
+				_, ok := price(row)
+				return row["symbol"] != "BTC" && ok
+			},
+		},
+		{
+			"Between",
+			Between("ts", 250, 349),
+			func(row map[string]any) bool {
+				ts := row["ts"].(int64)
+				return ts >= 250 && ts <= 349
+			},
+		},
+		{
+			"NotBetween",
+			Not(Between("price", 10, 189)),
+			func(row map[string]any) bool {
+				p, ok := price(row)
+				return ok && (p < 10 || p > 189)
+			},
+		},
+		{
+			"NotIn",
+			Not(In("qty", 0, 3, 6)),
+			func(row map[string]any) bool { return row["qty"].(int64) > 6 },
+		},
+		{
+			"EmptyOr",
+			Or(),
+			func(row map[string]any) bool { return false },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expected := 0
+			for _, row := range rows {
+				if tt.match(row) {
+					expected++
+				}
+			}
+
+			r := tbl.Reader().Where(tt.expr)
+			count := 0
+			for {
+				row, ok := r.Next()
+				if !ok {
+					break
+				}
+				if !tt.match(row) {
+					t.Errorf("unexpected row %v", row)
+				}
+				count++
+			}
+			if err := r.Err(); err != nil {
+				t.Fatal(err)
+			}
+			if count != expected {
+				t.Errorf("expected %d rows, got %d", expected, count)
+			}
+		})
+	}
+
+	t.Run("FilterIn", func(t *testing.T) {
+		r := tbl.Reader().Filter("symbol", OpIn, []string{"SOL"}).Filter("ts", "<", 9)
+		if times := scanTimes(t, r); len(times) != 3 || times[0] != 2 {
+			t.Errorf("expected ts 2, 5 and 8, got %v", times)
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		for _, e := range []Expr{
+			Or(Compare("price", "<", 1), Compare("qty", "~", 1)),
+			Not(In("symbol", "BTC", 1)),
+			Compare("symbol", OpIn, "BTC"),
+			nil,
+		} {
+			r := tbl.Reader().Where(e)
+			if _, ok := r.Next(); ok || r.Err() == nil {
+				t.Errorf("expected %v to be rejected", e)
+			}
+		}
+	})
+}
+
+func TestExprPruning(t *testing.T) {
+	tbl, _ := exprTable(t)
+
+	tests := []struct {
+		name string
+		expr Expr
+		read []int // blocks that cannot be skipped
+	}{
+		{"OrBothEnds", Or(Compare("ts", "<", 50), Compare("ts", ">", 950)), []int{0, 9}},
+		{"OrUnprunable", Or(Compare("ts", "<", 50), Compare("symbol", "==", "BTC")), []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}},
+		{"AndOfOr", And(Compare("qty", ">=", 600), Or(Compare("ts", "<", 250), Compare("ts", "==", 420))), []int{2, 4}},
+		{"In", In("ts", 5, 512, 5000), []int{0, 5}},
+		{"NotIn", Not(In("qty", 0, 3)), []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}},
+		{"Between", Between("ts", 150, 299), []int{1, 2}},
+		{"NotBetween", Not(Between("ts", 100, 899)), []int{0, 9}},
+		{"NotNull", Not(Compare("price", OpIsNotNull, nil)), []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := tbl.Reader().Where(tt.expr)
+			defer r.Close()
+
+			var read []int
+			for i, block := range r.blocks[:10] {
+				skip, err := r.canSkipBlock(block)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !skip {
+					read = append(read, i)
+				}
+			}
+			if len(read) != len(tt.read) {
+				t.Fatalf("expected blocks %v to be read, got %v", tt.read, read)
+			}
+			for i := range read {
+				if read[i] != tt.read[i] {
+					t.Fatalf("expected blocks %v to be read, got %v", tt.read, read)
+				}
+			}
+		})
+	}
+
+	t.Run("SingleValueNotIn", func(t *testing.T) {
+		r := tbl.Reader()
+		defer r.Close()
+
+		block := r.blocks[0]
+		loc, _ := r.getColumnLocation("qty")
+		block.IntMin[loc.Index], block.IntMax[loc.Index] = 7, 7
+		p, err := r.checkPredicate(Predicate{ColName: "qty", Op: OpNotIn, Value: []int{7, 8}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if skip, err := r.CanSkip(block, p); err != nil || !skip {
+			t.Errorf("expected a block holding only 7 to be skipped, got %v (%v)", skip, err)
+		}
+	})
+}
+
+func TestStringPatterns(t *testing.T) {
+	s := schema.Schema{
+		Name:       "instruments",
+		TimeColumn: "ts",
+		Columns: []schema.Column{
+			{Name: "ts", Type: schema.Int64},
+			{Name: "symbol", Type: schema.String, Nullable: true},
+			{Name: "size", Type: schema.Int64},
+		},
+	}
+
+	tbl, err := CreateTable(s, nil, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tbl.MaxBlockSize = 4
+	tbl.UseDiskStorage = true
+
+	// the last two rows stay in the active block
+	symbols := []any{"BTC-PERP", "BTC-USD", "ETH-PERP", nil, "SOL-USD", "BTC_PERP", "eth-perp", "BTC-PERP"}
+	for i, symbol := range symbols {
+		if err := tbl.AppendRow(map[string]any{"ts": int64(i), "symbol": symbol, "size": int64(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		expr     Expr
+		expected []int64
+	}{
+		{"Prefix", Compare("symbol", OpPrefix, "BTC-"), []int64{0, 1, 7}},
+		{"NotPrefix", Compare("symbol", OpNotPrefix, "BTC-"), []int64{2, 4, 5, 6}},
+		{"LikeSuffix", Compare("symbol", OpLike, "%-PERP"), []int64{0, 2, 7}},
+		{"LikeUnderscore", Compare("symbol", OpLike, "BTC_PERP"), []int64{0, 5, 7}},
+		{"LikeEscaped", Compare("symbol", OpLike, `BTC\_%`), []int64{5}},
+		{"NotLike", Not(Compare("symbol", OpLike, "%USD")), []int64{0, 2, 5, 6, 7}},
+		{"Regex", Compare("symbol", OpRegex, `(?i)^eth-`), []int64{2, 6}},
+		{"NotRegex", Compare("symbol", OpNotRegex, `PERP$`), []int64{1, 4, 6}},
+		{"OrPatterns", Or(Compare("symbol", OpPrefix, "SOL"), Compare("symbol", OpLike, "ETH%")), []int64{2, 4}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			times := scanTimes(t, tbl.Reader().Where(tt.expr))
+			if len(times) != len(tt.expected) {
+				t.Fatalf("expected ts %v, got %v", tt.expected, times)
+			}
+			for i := range times {
+				if times[i] != tt.expected[i] {
+					t.Fatalf("expected ts %v, got %v", tt.expected, times)
+				}
+			}
+		})
+	}
+
+	t.Run("Errors", func(t *testing.T) {
+		for _, e := range []Expr{
+			Compare("size", OpLike, "1%"),
+			Compare("symbol", OpRegex, "("),
+			Compare("symbol", OpPrefix, 1),
+		} {
+			r := tbl.Reader().Where(e)
+			if _, ok := r.Next(); ok || r.Err() == nil {
+				t.Errorf("expected %v to be rejected", e)
+			}
+		}
+	})
+}
+
+func TestLikeToRegexp(t *testing.T) {
+	for pattern, expected := range map[string]string{
+		"BTC-%":  `(?s)^BTC-.*$`,
+		"_.PERP": `(?s)^.\.PERP$`,
+		`100\%`:  `(?s)^100%$`,
+		`a\`:     `(?s)^a\\$`,
+		"(x)+":   `(?s)^\(x\)\+$`,
+	} {
+		if got := likeToRegexp(pattern); got != expected {
+			t.Errorf("%s: expected %s, got %s", pattern, expected, got)
+		}
+	}
+}
@@ -0,0 +1,300 @@
+package table
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+const manifestFile = "manifest.log"
+
+// blockMeta is what the manifest keeps about a block, enough to prune and plan a scan
+// without opening the parquet file.
+type blockMeta struct {
+	File          string `json:"file"`                // relative to the partition directory
+	Partition     string `json:"partition,omitempty"` // sub-directory of the table directory
+	ID            int    `json:"id"`
+	RowCount      int    `json:"rows"`
+	MinTs         int64  `json:"min_ts"`
+	MaxTs         int64  `json:"max_ts"`
+	MaxLSN        uint64 `json:"max_lsn,omitempty"`
+	SchemaVersion int    `json:"schema_version"`
+	Size          int64  `json:"size"`
+	Checksum      uint32 `json:"checksum"` // crc32c of the whole file
+
+	IntMin     []int64  `json:"int_min,omitempty"`
+	IntMax     []int64  `json:"int_max,omitempty"`
+	FloatMin   []string `json:"float_min,omitempty"` // formatted so NaN and infinities survive JSON
+	FloatMax   []string `json:"float_max,omitempty"`
+	BoolMin    []bool   `json:"bool_min,omitempty"`
+	BoolMax    []bool   `json:"bool_max,omitempty"`
+	NullCounts []int    `json:"null_counts,omitempty"`
+
+	// string stats are kept as bytes, base64 in JSON, which would replace invalid UTF-8
+	// in a string with U+FFFD. The string_min, string_max and string_values of older
+	// manifests may have been mangled that way and are ignored.
+	StringMin    [][]byte   `json:"string_min_bytes,omitempty"`
+	StringMax    [][]byte   `json:"string_max_bytes,omitempty"`
+	StringValues [][][]byte `json:"string_values_bytes,omitempty"`
+}
+
+// manifestEntry is one line of the manifest. The blocks it adds and removes change
+// together, so swapping blocks is atomic, and a torn line changes nothing.
+type manifestEntry struct {
+	Add    []blockMeta `json:"add,omitempty"`
+	Remove []string    `json:"remove,omitempty"`
+}
+
+func newBlockMeta(b *Block) blockMeta {
+	return blockMeta{
+		File:          filepath.Base(b.Path),
+		Partition:     b.Partition,
+		ID:            b.ID,
+		RowCount:      b.RowCount,
+		MinTs:         b.MinTs,
+		MaxTs:         b.MaxTs,
+		MaxLSN:        b.MaxLSN,
+		SchemaVersion: b.SchemaVersion,
+		Size:          b.Size,
+		Checksum:      b.Checksum,
+		IntMin:        b.IntMin,
+		IntMax:        b.IntMax,
+		FloatMin:      formatFloats(b.FloatMin),
+		FloatMax:      formatFloats(b.FloatMax),
+		BoolMin:       b.BoolMin,
+		BoolMax:       b.BoolMax,
+		NullCounts:    b.NullCounts,
+		StringMin:     stringBytes(b.StringMin),
+		StringMax:     stringBytes(b.StringMax),
+		StringValues:  stringValueBytes(b.StringValues),
+	}
+}
+
+// block rebuilds the on-disk block described by m under dir.
+func (m blockMeta) block(dir string) (*Block, error) {
+	floatMin, err := parseFloats(m.FloatMin)
+	if err != nil {
+		return nil, fmt.Errorf("block %s has invalid float stats: %v", m.File, err)
+	}
+	floatMax, err := parseFloats(m.FloatMax)
+	if err != nil {
+		return nil, fmt.Errorf("block %s has invalid float stats: %v", m.File, err)
+	}
+
+	return &Block{
+		Path:          filepath.Join(dir, m.Partition, m.File),
+		ID:            m.ID,
+		RowCount:      m.RowCount,
+		MinTs:         m.MinTs,
+		MaxTs:         m.MaxTs,
+		MaxLSN:        m.MaxLSN,
+		SchemaVersion: m.SchemaVersion,
+		Partition:     m.Partition,
+		Size:          m.Size,
+		Checksum:      m.Checksum,
+		isOnDisk:      true,
+		isClosed:      true,
+		IntMin:        orEmpty(m.IntMin),
+		IntMax:        orEmpty(m.IntMax),
+		FloatMin:      floatMin,
+		FloatMax:      floatMax,
+		BoolMin:       orEmpty(m.BoolMin),
+		BoolMax:       orEmpty(m.BoolMax),
+		NullCounts:    orEmpty(m.NullCounts),
+		// blocks recorded before string stats existed, or were kept as bytes, carry none
+		StringMin:    byteStrings(m.StringMin),
+		StringMax:    byteStrings(m.StringMax),
+		StringValues: byteStringValues(m.StringValues),
+	}, nil
+}
+
+func orEmpty[T any](s []T) []T {
+	if s == nil {
+		return []T{}
+	}
+	return s
+}
+
+// stringBytes and byteStrings convert string stats for the manifest and back, nil stays
+// nil
+func stringBytes(ss []string) [][]byte {
+	if ss == nil {
+		return nil
+	}
+	out := make([][]byte, len(ss))
+	for i, s := range ss {
+		out[i] = []byte(s)
+	}
+	return out
+}
+
+func byteStrings(bs [][]byte) []string {
+	if bs == nil {
+		return nil
+	}
+	out := make([]string, len(bs))
+	for i, b := range bs {
+		out[i] = string(b)
+	}
+	return out
+}
+
+func stringValueBytes(values [][]string) [][][]byte {
+	if values == nil {
+		return nil
+	}
+	out := make([][][]byte, len(values))
+	for i, vs := range values {
+		out[i] = stringBytes(vs)
+	}
+	return out
+}
+
+func byteStringValues(values [][][]byte) [][]string {
+	if values == nil {
+		return nil
+	}
+	out := make([][]string, len(values))
+	for i, vs := range values {
+		out[i] = byteStrings(vs)
+	}
+	return out
+}
+
+func formatFloats(fs []float64) []string {
+	out := make([]string, len(fs))
+	for i, f := range fs {
+		out[i] = strconv.FormatFloat(f, 'g', -1, 64)
+	}
+	return out
+}
+
+func parseFloats(ss []string) ([]float64, error) {
+	out := make([]float64, len(ss))
+	for i, s := range ss {
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = f
+	}
+	return out, nil
+}
+
+// loadManifest replays the manifest under dir and returns the live blocks in the order
+// they were added. ok is false when there is no manifest. A torn last line, left by a
+// crash while it was written, is cut off; rewrite reports that the log holds removed
+// blocks and is worth compacting.
+func loadManifest(dir string) (blocks []blockMeta, ok bool, rewrite bool, err error) {
+	path := filepath.Join(dir, manifestFile)
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, false, false, nil
+	}
+	if err != nil {
+		return nil, false, false, fmt.Errorf("failed to read manifest: %v", err)
+	}
+
+	live := make(map[string]int)
+
+	for offset := 0; offset < len(data); {
+		n := bytes.IndexByte(data[offset:], '\n')
+
+		var entry manifestEntry
+		if n == -1 || json.Unmarshal(data[offset:offset+n], &entry) != nil {
+			// the newline goes out with the entry, so only the last entry can be torn
+			if n != -1 && offset+n+1 < len(data) {
+				return nil, false, false, fmt.Errorf("manifest %s: corrupt entry at offset %d", path, offset)
+			}
+			if err := os.Truncate(path, int64(offset)); err != nil {
+				return nil, false, false, fmt.Errorf("failed to truncate torn manifest entry: %v", err)
+			}
+			break
+		}
+
+		for _, name := range entry.Remove {
+			if i, ok := live[name]; ok {
+				blocks[i].File = ""
+				delete(live, name)
+			}
+			rewrite = true
+		}
+		for _, meta := range entry.Add {
+			live[meta.File] = len(blocks)
+			blocks = append(blocks, meta)
+		}
+
+		offset += n + 1
+	}
+
+	out := blocks[:0]
+	for _, meta := range blocks {
+		if meta.File != "" {
+			out = append(out, meta)
+		}
+	}
+
+	return out, true, rewrite, nil
+}
+
+// appendManifest adds entry to the manifest under dir and fsyncs it before returning.
+func appendManifest(dir string, entry manifestEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, manifestFile)
+	_, statErr := os.Stat(path)
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open manifest: %v", err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to open manifest: %v", err)
+	}
+
+	// a failed append must not leave part of a line behind, the next entry would be
+	// appended after it and the manifest could no longer be read
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Truncate(info.Size())
+		f.Close()
+		return fmt.Errorf("failed to write manifest: %v", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Truncate(info.Size())
+		f.Close()
+		return fmt.Errorf("failed to sync manifest: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if os.IsNotExist(statErr) {
+		return syncDir(dir)
+	}
+	return nil
+}
+
+// writeManifest replaces the manifest under dir with a single entry adding blocks.
+func writeManifest(dir string, blocks []blockMeta) error {
+	line, err := json.Marshal(manifestEntry{Add: blocks})
+	if err != nil {
+		return err
+	}
+
+	_, _, err = writeFileAtomic(filepath.Join(dir, manifestFile), func(w io.Writer) error {
+		_, err := w.Write(append(line, '\n'))
+		return err
+	})
+	return err
+}
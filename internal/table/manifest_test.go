@@ -0,0 +1,207 @@
+package table
+
+import (
+	"backtraceDB/internal/schema"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func manifestSchema() schema.Schema {
+	return schema.Schema{
+		Name:       "manifest_test",
+		TimeColumn: "ts",
+		Columns: []schema.Column{
+			{Name: "ts", Type: schema.Int64},
+			{Name: "price", Type: schema.Float64},
+			{Name: "is_buy", Type: schema.Boolean},
+		},
+	}
+}
+
+// writeBlocks flushes 6 rows into 3 blocks of 2 under a fresh directory
+func writeBlocks(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	tbl, err := CreateTable(manifestSchema(), nil, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tbl.MaxBlockSize = 2
+	tbl.UseDiskStorage = true
+
+	prices := []float64{1.5, 2.5, math.NaN(), 4.5, 5.5, math.Inf(1)}
+	for i, price := range prices {
+		row := map[string]any{"ts": int64(i * 10), "price": price, "is_buy": i%2 == 0}
+		if err := tbl.AppendRow(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func reload(t *testing.T, dir string) (*Table, error) {
+	t.Helper()
+
+	tbl, err := CreateTable(manifestSchema(), nil, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tbl, tbl.LoadFromDisk()
+}
+
+func TestManifest(t *testing.T) {
+	t.Run("Reload", func(t *testing.T) {
+		dir := writeBlocks(t)
+
+		tbl, err := reload(t, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(tbl.coldBlocks) != 3 || tbl.RowCount() != 6 {
+			t.Fatalf("expected 3 blocks and 6 rows, got %d and %d", len(tbl.coldBlocks), tbl.RowCount())
+		}
+
+		b := tbl.coldBlocks[1]
+		if b.MinTs != 20 || b.MaxTs != 30 || b.RowCount != 2 {
+			t.Errorf("expected block 1 to cover ts 20 to 30 in 2 rows, got %d to %d in %d", b.MinTs, b.MaxTs, b.RowCount)
+		}
+		if first := tbl.coldBlocks[0]; first.FloatMin[0] != 1.5 || first.FloatMax[0] != 2.5 {
+			t.Errorf("expected the first block to hold prices 1.5 to 2.5, got %v to %v", first.FloatMin[0], first.FloatMax[0])
+		}
+		if !math.IsInf(tbl.coldBlocks[2].FloatMax[0], 1) {
+			t.Errorf("expected +Inf as max price of the last block, got %v", tbl.coldBlocks[2].FloatMax[0])
+		}
+		if b.BoolMin[0] || !b.BoolMax[0] {
+			t.Errorf("expected block 1 to hold both a buy and a sell, got [%v, %v]", b.BoolMin[0], b.BoolMax[0])
+		}
+		if tbl.nextBlockID != 3 {
+			t.Errorf("expected the next block ID to be 3, got %d", tbl.nextBlockID)
+		}
+		if err := tbl.Verify(); err != nil {
+			t.Errorf("expected the blocks to verify, got %v", err)
+		}
+
+		n := 0
+		r := tbl.Reader().Filter("ts", ">=", int64(20))
+		for {
+			if _, ok := r.Next(); !ok {
+				break
+			}
+			n++
+		}
+		if r.Err() != nil || n != 4 {
+			t.Errorf("expected 4 rows from ts 20 on, got %d (%v)", n, r.Err())
+		}
+	})
+
+	t.Run("BlocksNotOpened", func(t *testing.T) {
+		dir := writeBlocks(t)
+
+		// garbage of the right size goes unnoticed until the block is read or verified
+		blocks, _ := filepath.Glob(filepath.Join(dir, "*.parquet"))
+		info, _ := os.Stat(blocks[0])
+		if err := os.WriteFile(blocks[0], make([]byte, info.Size()), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		tbl, err := reload(t, dir)
+		if err != nil {
+			t.Fatalf("expected the table to load from the manifest alone, got %v", err)
+		}
+		if err := tbl.Verify(); err == nil {
+			t.Error("expected Verify to catch the overwritten block")
+		}
+	})
+
+	t.Run("TornEntry", func(t *testing.T) {
+		dir := writeBlocks(t)
+		path := filepath.Join(dir, manifestFile)
+		before, _ := os.ReadFile(path)
+
+		f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+		f.WriteString(`{"add":[{"file":"Ts99R1i`)
+		f.Close()
+
+		tbl, err := reload(t, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(tbl.coldBlocks) != 3 {
+			t.Errorf("expected the torn entry to be ignored, got %d blocks", len(tbl.coldBlocks))
+		}
+		if after, _ := os.ReadFile(path); len(after) != len(before) {
+			t.Errorf("expected the torn entry to be truncated, manifest went from %d to %d bytes", len(before), len(after))
+		}
+	})
+
+	t.Run("CorruptEntry", func(t *testing.T) {
+		dir := writeBlocks(t)
+		path := filepath.Join(dir, manifestFile)
+		data, _ := os.ReadFile(path)
+		if err := os.WriteFile(path, append([]byte("garbage\n"), data...), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := reload(t, dir); err == nil {
+			t.Error("expected a corrupt entry before the last one to fail the load")
+		}
+	})
+
+	t.Run("UnrecordedBlock", func(t *testing.T) {
+		dir := writeBlocks(t)
+
+		// a crash between the rename of a block and its manifest entry
+		blocks, _ := filepath.Glob(filepath.Join(dir, "*.parquet"))
+		data, _ := os.ReadFile(blocks[0])
+		orphan := filepath.Join(dir, "Ts70R2i7.parquet")
+		if err := os.WriteFile(orphan, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		tbl, err := reload(t, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tbl.RowCount() != 6 {
+			t.Errorf("expected the unrecorded block to be ignored, got %d rows", tbl.RowCount())
+		}
+		if _, err := os.Stat(orphan); !os.IsNotExist(err) {
+			t.Errorf("expected the unrecorded block to be removed, got %v", err)
+		}
+	})
+
+	t.Run("MissingBlock", func(t *testing.T) {
+		dir := writeBlocks(t)
+		blocks, _ := filepath.Glob(filepath.Join(dir, "*.parquet"))
+		os.Remove(blocks[1])
+
+		if _, err := reload(t, dir); err == nil {
+			t.Error("expected a block listed in the manifest but missing on disk to fail the load")
+		}
+	})
+
+	t.Run("Upgrade", func(t *testing.T) {
+		// tables written before the manifest only have their block files
+		dir := writeBlocks(t)
+		os.Remove(filepath.Join(dir, manifestFile))
+
+		tbl, err := reload(t, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(tbl.coldBlocks) != 3 || tbl.coldBlocks[2].MinTs != 40 {
+			t.Fatalf("expected 3 blocks with the last one starting at ts 40, got %d blocks", len(tbl.coldBlocks))
+		}
+		if err := tbl.Verify(); err != nil {
+			t.Errorf("expected the scanned blocks to verify, got %v", err)
+		}
+
+		metas, ok, _, err := loadManifest(dir)
+		if err != nil || !ok || len(metas) != 3 {
+			t.Errorf("expected a manifest of 3 blocks to be written, got %d (%v)", len(metas), err)
+		}
+	})
+}
@@ -0,0 +1,287 @@
+package table
+
+import (
+	"backtraceDB/internal/schema"
+	"fmt"
+	"slices"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// rowRange holds the rows start <= i < end of a block.
+type rowRange struct{ start, end int64 }
+
+func rangeLen(rows []rowRange) int64 {
+	var n int64
+	for _, r := range rows {
+		n += r.end - r.start
+	}
+	return n
+}
+
+// addRange appends [start, end) to rows sorted by start, merging it into the last range
+// when the two touch.
+func addRange(rows []rowRange, start, end int64) []rowRange {
+	if n := len(rows); n > 0 && start <= rows[n-1].end {
+		rows[n-1].end = max(rows[n-1].end, end)
+		return rows
+	}
+	return append(rows, rowRange{start, end})
+}
+
+func intersectRows(a, b []rowRange) []rowRange {
+	var out []rowRange
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		start, end := max(a[i].start, b[j].start), min(a[i].end, b[j].end)
+		if start < end {
+			out = append(out, rowRange{start, end})
+		}
+		if a[i].end < b[j].end {
+			i++
+		} else {
+			j++
+		}
+	}
+	return out
+}
+
+func unionRows(a, b []rowRange) []rowRange {
+	var out []rowRange
+	for i, j := 0, 0; i < len(a) || j < len(b); {
+		if j == len(b) || (i < len(a) && a[i].start <= b[j].start) {
+			out = addRange(out, a[i].start, a[i].end)
+			i++
+		} else {
+			out = addRange(out, b[j].start, b[j].end)
+			j++
+		}
+	}
+	return out
+}
+
+// clipRows returns the parts of rows within [lo, hi), relative to lo. nil rows stand for
+// every row.
+func clipRows(rows []rowRange, lo, hi int64) []rowRange {
+	if rows == nil {
+		if hi > lo {
+			return []rowRange{{0, hi - lo}}
+		}
+		return nil
+	}
+
+	var out []rowRange
+	for _, r := range rows {
+		if start, end := max(r.start, lo), min(r.end, hi); start < end {
+			out = append(out, rowRange{start - lo, end - lo})
+		}
+	}
+	return out
+}
+
+// matchRows narrows all down to the rows e may match, given leaf for the rows a single
+// predicate may match. It is prune for row ranges instead of whole blocks.
+func matchRows(e Expr, all []rowRange, leaf func(Predicate) ([]rowRange, error)) ([]rowRange, error) {
+	switch e := e.(type) {
+	case Predicate:
+		return leaf(e)
+	case andExpr:
+		rows := all
+		for _, child := range e {
+			r, err := matchRows(child, all, leaf)
+			if err != nil {
+				return nil, err
+			}
+			if rows = intersectRows(rows, r); len(rows) == 0 {
+				break
+			}
+		}
+		return rows, nil
+	case orExpr:
+		var rows []rowRange
+		for _, child := range e {
+			r, err := matchRows(child, all, leaf)
+			if err != nil {
+				return nil, err
+			}
+			rows = unionRows(rows, r)
+		}
+		return rows, nil
+	}
+	return all, nil
+}
+
+// mergeIndexStats widens the min/max of the column at loc to cover the column index
+// bounds min and max, or sets them when first.
+func (b *Block) mergeIndexStats(loc ColumnLocation, min, max parquet.Value, first bool) {
+	switch loc.Type {
+	case schema.Int64:
+		if lo := min.Int64(); first || lo < b.IntMin[loc.Index] {
+			b.IntMin[loc.Index] = lo
+		}
+		if hi := max.Int64(); first || hi > b.IntMax[loc.Index] {
+			b.IntMax[loc.Index] = hi
+		}
+	case schema.Float64:
+		if lo := min.Double(); first || lo < b.FloatMin[loc.Index] {
+			b.FloatMin[loc.Index] = lo
+		}
+		if hi := max.Double(); first || hi > b.FloatMax[loc.Index] {
+			b.FloatMax[loc.Index] = hi
+		}
+	case schema.Boolean:
+		b.BoolMin[loc.Index] = min.Boolean() && (first || b.BoolMin[loc.Index])
+		b.BoolMax[loc.Index] = max.Boolean() || (!first && b.BoolMax[loc.Index])
+	}
+}
+
+// statsFromIndex sets the stats of the column at logicalIdx from the column index of
+// every page of every row group. It reports false when a row group has no index, the
+// stats are incomplete then.
+func (b *Block) statsFromIndex(pf *parquet.File, chunkIdx, logicalIdx int, loc ColumnLocation) bool {
+	first := true
+	for _, rg := range pf.RowGroups() {
+		ci, err := rg.ColumnChunks()[chunkIdx].ColumnIndex()
+		if err != nil || ci == nil {
+			return false
+		}
+		for p := 0; p < ci.NumPages(); p++ {
+			b.NullCounts[logicalIdx] += int(ci.NullCount(p))
+			if ci.NullPage(p) {
+				continue
+			}
+			b.mergeIndexStats(loc, ci.MinValue(p), ci.MaxValue(p), first)
+			first = false
+		}
+	}
+	return true
+}
+
+// statsFromRows decodes pf and computes the block stats from its rows, for files
+// written without a column index.
+func (b *Block) statsFromRows(pf *parquet.File, s schema.Schema, locations []ColumnLocation) error {
+	storage, _, err := NewColumnStorage(columnTypes(s))
+	if err != nil {
+		return err
+	}
+	if err := b.loadRows(pf, storage, s, locations, nil, nil); err != nil {
+		return err
+	}
+
+	b.Storage = storage
+	b.UpdateStats()
+	b.Storage = nil
+	return nil
+}
+
+// pageRows returns the rows of block the filters may match, judged by the bloom filter
+// of every row group and the column index of every page of pf. It returns nil when that
+// rules nothing out. A page is judged like a block of its own, so CanSkip decides for
+// pages too.
+func (tr *TableReader) pageRows(block *Block, pf *parquet.File) ([]rowRange, error) {
+	if len(tr.filters) == 0 {
+		return nil, nil
+	}
+
+	blockSchema, blockLocations, ok := tr.layout(block.SchemaVersion)
+	if !ok {
+		return nil, fmt.Errorf("block uses unknown schema version %d", block.SchemaVersion)
+	}
+
+	chunkIndices := make(map[string]int)
+	for i, field := range pf.Schema().Fields() {
+		chunkIndices[field.Name()] = i
+	}
+	all := []rowRange{{0, pf.NumRows()}}
+
+	leaf := func(p Predicate) ([]rowRange, error) {
+		logicalIdx := slices.IndexFunc(blockSchema.Columns, func(c schema.Column) bool { return c.Name == p.ColName })
+		chunkIdx, ok := chunkIndices[p.ColName]
+		if logicalIdx == -1 || !ok {
+			// added after the block was written, CanSkip judged it on the block
+			return all, nil
+		}
+		loc := blockLocations[logicalIdx]
+
+		var rows []rowRange
+		var groupStart int64
+		for _, rg := range pf.RowGroups() {
+			n := rg.NumRows()
+			chunk := rg.ColumnChunks()[chunkIdx]
+
+			// a group whose bloom filter rules the value out is skipped without reading its index
+			if skip, err := bloomRulesOut(chunk, loc.Type, p); err != nil {
+				return nil, fmt.Errorf("failed to read bloom filter of column %s: %v", p.ColName, err)
+			} else if skip {
+				groupStart += n
+				continue
+			}
+
+			ci, err := chunk.ColumnIndex()
+			oi, oiErr := chunk.OffsetIndex()
+			if err != nil || oiErr != nil || ci == nil || oi == nil || ci.NumPages() != oi.NumPages() {
+				rows = addRange(rows, groupStart, groupStart+n)
+				groupStart += n
+				continue
+			}
+
+			for i := 0; i < oi.NumPages(); i++ {
+				first, last := oi.FirstRowIndex(i), n
+				if i+1 < oi.NumPages() {
+					last = oi.FirstRowIndex(i + 1)
+				}
+
+				page := &Block{SchemaVersion: block.SchemaVersion, RowCount: int(last - first)}
+				page.allocStats(columnTypes(blockSchema))
+				page.NullCounts[logicalIdx] = int(ci.NullCount(i))
+				if !ci.NullPage(i) {
+					page.mergeIndexStats(loc, ci.MinValue(i), ci.MaxValue(i), true)
+				}
+
+				skip, err := tr.CanSkip(page, p)
+				if err != nil {
+					return nil, err
+				}
+				if !skip {
+					rows = addRange(rows, groupStart+first, groupStart+last)
+				}
+			}
+			groupStart += n
+		}
+		return rows, nil
+	}
+
+	rows, err := matchRows(andExpr(tr.filters), all, leaf)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 1 && rows[0] == all[0] {
+		return nil, nil
+	}
+	if rows == nil {
+		rows = []rowRange{}
+	}
+	return rows, nil
+}
+
+// loadBlock decodes the needed columns of the rows of block the filters may match into
+// dest and returns how many rows it decoded.
+func (tr *TableReader) loadBlock(block *Block, dest *ColumnStorage) (int, error) {
+	pf, closeFile, err := block.openParquet()
+	if err != nil {
+		return 0, err
+	}
+	defer closeFile()
+
+	rows, err := tr.pageRows(block, pf)
+	if err != nil {
+		return 0, err
+	}
+	if err := block.loadRows(pf, dest, tr.schema, tr.locations, tr.neededColumns(), rows); err != nil {
+		return 0, err
+	}
+
+	if rows == nil {
+		return block.RowCount, nil
+	}
+	return int(rangeLen(rows)), nil
+}
@@ -0,0 +1,257 @@
+package table
+
+import (
+	"backtraceDB/internal/schema"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// pagedTable writes 2500 rows in blocks of 1000 with pages of a few dozen rows and row
+// groups of 300, so the blocks on disk span many pages and row groups. price is null on
+// every 5th row.
+func pagedTable(t *testing.T) (*Table, string, []map[string]any) {
+	t.Helper()
+
+	pageSize, groupRows := parquetPageSize, parquetRowGroupRows
+	parquetPageSize, parquetRowGroupRows = 256, 300
+	t.Cleanup(func() { parquetPageSize, parquetRowGroupRows = pageSize, groupRows })
+
+	s := schema.Schema{
+		Name:       "paged",
+		TimeColumn: "ts",
+		Columns: []schema.Column{
+			{Name: "ts", Type: schema.Int64},
+			{Name: "price", Type: schema.Float64, Nullable: true},
+			{Name: "buy", Type: schema.Boolean},
+			{Name: "symbol", Type: schema.String},
+		},
+	}
+
+	dir := t.TempDir()
+	tbl, err := CreateTable(s, nil, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tbl.MaxBlockSize = 1000
+	tbl.UseDiskStorage = true
+
+	var rows []map[string]any
+	for i := 0; i < 2500; i++ {
+		row := map[string]any{
+			"ts":     int64(i),
+			"price":  float64(i%300) + 0.5,
+			"buy":    i%1000 >= 100,
+			"symbol": []string{"BTC", "ETH", "SOL"}[i%3],
+		}
+		if i%5 == 0 {
+			row["price"] = nil
+		}
+		if err := tbl.AppendRow(row); err != nil {
+			t.Fatal(err)
+		}
+		rows = append(rows, row)
+	}
+	return tbl, dir, rows
+}
+
+func TestPageStats(t *testing.T) {
+	tbl, dir, _ := pagedTable(t)
+
+	// closing writes the active block too
+	if err := tbl.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var expected []string
+	for _, b := range append(tbl.coldBlocks, tbl.activeBlock) {
+		pf, closeFile, err := b.openParquet()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// leaf columns sort by name, ts is the last
+		pages, _ := pf.RowGroups()[0].ColumnChunks()[3].OffsetIndex()
+		if b != tbl.activeBlock && (len(pf.RowGroups()) < 2 || pages.NumPages() < 2) {
+			t.Fatalf("expected many row groups and pages, got %d and %d", len(pf.RowGroups()), pages.NumPages())
+		}
+		closeFile()
+
+		expected = append(expected, fmt.Sprint(b.MinTs, b.IntMin, b.IntMax, b.FloatMin, b.FloatMax, b.BoolMin, b.BoolMax, b.NullCounts))
+	}
+
+	// without the manifest the stats come from the column index of the files
+	if err := os.Remove(filepath.Join(dir, manifestFile)); err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := CreateTable(tbl.schema, nil, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reloaded.LoadFromDisk(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(reloaded.coldBlocks) != len(expected) {
+		t.Fatalf("expected %d blocks, got %d", len(expected), len(reloaded.coldBlocks))
+	}
+	for i, b := range reloaded.coldBlocks {
+		got := fmt.Sprint(b.MinTs, b.IntMin, b.IntMax, b.FloatMin, b.FloatMax, b.BoolMin, b.BoolMax, b.NullCounts)
+		if got != expected[i] {
+			t.Errorf("block %d: expected stats %s, got %s", i, expected[i], got)
+		}
+	}
+
+	times := scanTimes(t, reloaded.Reader().Filter("ts", ">", 990).Filter("ts", "<", 1010))
+	if len(times) != 19 || times[0] != 991 {
+		t.Errorf("expected ts 991 to 1009, got %v", times)
+	}
+}
+
+func TestPageRows(t *testing.T) {
+	tbl, _, rows := pagedTable(t)
+
+	t.Run("Ranges", func(t *testing.T) {
+		tests := []struct {
+			name string
+			expr Expr
+			want []int64 // rows of block 0 the ranges must hold
+			max  int64   // rows they may hold at most, -1 for all of them
+		}{
+			{"Between", Between("ts", 420, 430), []int64{420, 430}, 100},
+			{"OrBothEnds", Or(Compare("ts", "<", 5), Compare("ts", ">", 995)), []int64{0, 4, 996, 999}, 200},
+			{"AndAcrossColumns", And(Compare("ts", ">=", 500), Compare("buy", "==", false)), nil, 0},
+			// bools pack into one page per row group, only the first holds false
+			{"BoolRowGroup", Compare("buy", "==", false), []int64{0, 99}, 300},
+			{"Unprunable", Compare("symbol", "==", "BTC"), nil, -1},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				r := tbl.Reader().Where(tt.expr)
+				defer r.Close()
+				if err := r.Err(); err != nil {
+					t.Fatal(err)
+				}
+
+				block := tbl.coldBlocks[0]
+				pf, closeFile, err := block.openParquet()
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer closeFile()
+
+				ranges, err := r.pageRows(block, pf)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if tt.max == -1 {
+					if ranges != nil {
+						t.Errorf("expected every row, got %v", ranges)
+					}
+					return
+				}
+				if ranges == nil || rangeLen(ranges) > tt.max {
+					t.Errorf("expected at most %d rows, got %v", tt.max, ranges)
+				}
+				for _, row := range tt.want {
+					found := false
+					for _, rr := range ranges {
+						found = found || (row >= rr.start && row < rr.end)
+					}
+					if !found {
+						t.Errorf("expected row %d in %v", row, ranges)
+					}
+				}
+			})
+		}
+	})
+
+	t.Run("Scan", func(t *testing.T) {
+		tests := []struct {
+			name  string
+			expr  Expr
+			match func(row map[string]any) bool
+		}{
+			{
+				"Between",
+				Between("ts", 420, 1430),
+				func(row map[string]any) bool { ts := row["ts"].(int64); return ts >= 420 && ts <= 1430 },
+			},
+			{
+				"OrAcrossBlocks",
+				Or(Compare("ts", "<", 37), Between("ts", 1290, 1310), Compare("ts", ">", 2480)),
+				func(row map[string]any) bool {
+					ts := row["ts"].(int64)
+					return ts < 37 || (ts >= 1290 && ts <= 1310) || ts > 2480
+				},
+			},
+			{
+				"Nulls",
+				And(Compare("price", OpIsNull, nil), Compare("ts", "<", 700)),
+				func(row map[string]any) bool { return row["price"] == nil && row["ts"].(int64) < 700 },
+			},
+			{
+				"Price",
+				Compare("price", "<", 3),
+				func(row map[string]any) bool { p, ok := row["price"].(float64); return ok && p < 3 },
+			},
+			{
+				"NoPageMatches",
+				And(Compare("ts", ">=", 500), Compare("ts", "<", 1000), Compare("buy", "==", false)),
+				func(row map[string]any) bool { return false },
+			},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				var expected []map[string]any
+				for _, row := range rows {
+					if tt.match(row) {
+						expected = append(expected, row)
+					}
+				}
+
+				r := tbl.Reader().Where(tt.expr).Select("ts", "price")
+				var got []map[string]any
+				for {
+					row, ok := r.Next()
+					if !ok {
+						break
+					}
+					got = append(got, row)
+				}
+				if err := r.Err(); err != nil {
+					t.Fatal(err)
+				}
+
+				if len(got) != len(expected) {
+					t.Fatalf("expected %d rows, got %d", len(expected), len(got))
+				}
+				for i := range got {
+					if got[i]["ts"] != expected[i]["ts"] || got[i]["price"] != expected[i]["price"] {
+						t.Fatalf("expected %v, got %v", expected[i], got[i])
+					}
+				}
+			})
+		}
+	})
+}
+
+func TestRowRanges(t *testing.T) {
+	a := []rowRange{{0, 10}, {20, 30}, {40, 50}}
+	b := []rowRange{{5, 25}, {45, 60}}
+
+	if got := fmt.Sprint(intersectRows(a, b)); got != "[{5 10} {20 25} {45 50}]" {
+		t.Errorf("unexpected intersection %s", got)
+	}
+	if got := fmt.Sprint(unionRows(a, b)); got != "[{0 30} {40 60}]" {
+		t.Errorf("unexpected union %s", got)
+	}
+	if got := fmt.Sprint(clipRows(a, 25, 45)); got != "[{0 5} {15 20}]" {
+		t.Errorf("unexpected clip %s", got)
+	}
+	if got := clipRows(nil, 10, 10); got != nil {
+		t.Errorf("expected an empty row group to clip to nothing, got %v", got)
+	}
+}
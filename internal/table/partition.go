@@ -0,0 +1,485 @@
+package table
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"time"
+)
+
+// Granularity is the size of the time buckets a partitioned table splits its blocks by.
+type Granularity int
+
+const (
+	PartitionNone Granularity = iota
+	PartitionHour
+	PartitionDay
+	PartitionMonth
+)
+
+func (g Granularity) String() string {
+	switch g {
+	case PartitionNone:
+		return "none"
+	case PartitionHour:
+		return "hour"
+	case PartitionDay:
+		return "day"
+	case PartitionMonth:
+		return "month"
+	}
+	return fmt.Sprintf("Granularity(%d)", int(g))
+}
+
+// partition names sort like the buckets they stand for, and their length tells the
+// granularity apart when they are parsed back
+var partitionLayouts = map[Granularity]string{
+	PartitionHour:  "2006-01-02T15",
+	PartitionDay:   "2006-01-02",
+	PartitionMonth: "2006-01",
+}
+
+// Partitioning splits the blocks of a table into one sub-directory per time bucket of
+// the time column, interpreted as a Unix timestamp in Unit (nanoseconds when 0). Buckets
+// are cut in UTC.
+type Partitioning struct {
+	By   Granularity   `json:"by"`
+	Unit time.Duration `json:"unit,omitempty"`
+}
+
+// Validate rejects unknown granularities and negative units.
+func (p Partitioning) Validate() error {
+	if _, ok := partitionLayouts[p.By]; !ok && p.By != PartitionNone {
+		return fmt.Errorf("unknown partition granularity %d", int(p.By))
+	}
+	if p.Unit < 0 {
+		return fmt.Errorf("partition unit must not be negative, got %v", p.Unit)
+	}
+	return nil
+}
+
+func (p Partitioning) unit() int64 {
+	if p.Unit == 0 {
+		return int64(time.Nanosecond)
+	}
+	return int64(p.Unit)
+}
+
+// Partition is one time bucket of a partitioned table. It holds the rows with
+// Start <= ts < End.
+type Partition struct {
+	Name  string `json:"name"`
+	Start int64  `json:"start"`
+	End   int64  `json:"end"`
+}
+
+// partitionOf returns the partition holding ts, an empty name for tables without
+// partitioning.
+func (p Partitioning) partitionOf(ts int64) Partition {
+	layout, ok := partitionLayouts[p.By]
+	if !ok {
+		return Partition{}
+	}
+
+	at := time.Unix(0, ts*p.unit()).UTC()
+
+	var start, end time.Time
+	switch p.By {
+	case PartitionHour:
+		start = at.Truncate(time.Hour)
+		end = start.Add(time.Hour)
+	case PartitionDay:
+		start = time.Date(at.Year(), at.Month(), at.Day(), 0, 0, 0, 0, time.UTC)
+		end = start.AddDate(0, 0, 1)
+	case PartitionMonth:
+		start = time.Date(at.Year(), at.Month(), 1, 0, 0, 0, 0, time.UTC)
+		end = start.AddDate(0, 1, 0)
+	}
+
+	return Partition{
+		Name:  start.Format(layout),
+		Start: start.UnixNano() / p.unit(),
+		End:   end.UnixNano() / p.unit(),
+	}
+}
+
+// parsePartition rebuilds a partition from its directory name, whatever granularity
+// the table uses now.
+func (p Partitioning) parsePartition(name string) (Partition, error) {
+	for g, layout := range partitionLayouts {
+		if len(layout) != len(name) {
+			continue
+		}
+		start, err := time.Parse(layout, name)
+		if err != nil {
+			break
+		}
+		return Partitioning{By: g, Unit: p.Unit}.partitionOf(start.UnixNano() / p.unit()), nil
+	}
+	return Partition{}, fmt.Errorf("invalid partition name %q", name)
+}
+
+// splitByPartition cuts the blocks about to be written, sorted by time, wherever a row
+// falls into the next partition. Callers must hold t.mu.
+func (t *Table) splitByPartition(blocks []*Block) []*Block {
+	if t.Partitioning.By == PartitionNone {
+		return blocks
+	}
+
+	var out []*Block
+	for _, b := range blocks {
+		ts := b.Storage.Int64Cols[t.locations[t.timeColIdx].Index]
+		first := t.Partitioning.partitionOf(ts[0])
+
+		if t.Partitioning.partitionOf(ts[len(ts)-1]).Name == first.Name {
+			b.Partition = first.Name
+			out = append(out, b)
+			continue
+		}
+
+		for lo := 0; lo < len(ts); {
+			part := t.Partitioning.partitionOf(ts[lo])
+			hi := lo + sort.Search(len(ts)-lo, func(i int) bool { return ts[lo+i] >= part.End })
+
+			order := make([]int, hi-lo)
+			for i := range order {
+				order[i] = lo + i
+			}
+
+			piece := &Block{
+				Storage:       b.Storage.permute(order),
+				RowCount:      hi - lo,
+				MinTs:         ts[lo],
+				MaxTs:         ts[hi-1],
+				SchemaVersion: b.SchemaVersion,
+				MaxLSN:        b.MaxLSN,
+				Partition:     part.Name,
+			}
+			piece.allocStats(columnTypes(t.schema))
+			out = append(out, piece)
+
+			lo = hi
+		}
+	}
+
+	return out
+}
+
+// notePartitions refreshes the partitions that hold flushed blocks and passes them to
+// OnPartitionsChange when the set changed. Callers must hold t.mu.
+func (t *Table) notePartitions() {
+	names := make(map[string]bool)
+	for _, block := range t.coldBlocks {
+		if block.Partition != "" {
+			names[block.Partition] = true
+		}
+	}
+	// a closed table keeps its last block as the active one
+	if t.activeBlock.isOnDisk && t.activeBlock.Partition != "" {
+		names[t.activeBlock.Partition] = true
+	}
+
+	changed := len(names) != len(t.partitions)
+	for name := range names {
+		if _, ok := t.partitions[name]; ok {
+			continue
+		}
+		part, err := t.Partitioning.parsePartition(name)
+		if err != nil {
+			continue
+		}
+		t.partitions[name] = part
+		changed = true
+	}
+	for name := range t.partitions {
+		if !names[name] {
+			delete(t.partitions, name)
+		}
+	}
+
+	if changed && t.OnPartitionsChange != nil {
+		t.OnPartitionsChange(t.partitionList())
+	}
+}
+
+func (t *Table) partitionList() []Partition {
+	parts := make([]Partition, 0, len(t.partitions))
+	for _, part := range t.partitions {
+		parts = append(parts, part)
+	}
+	slices.SortFunc(parts, func(a, b Partition) int {
+		if a.Start != b.Start {
+			return cmp.Compare(a.Start, b.Start)
+		}
+		return cmp.Compare(a.Name, b.Name)
+	})
+	return parts
+}
+
+// Partitions returns the partitions holding flushed blocks, oldest first.
+func (t *Table) Partitions() []Partition {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return t.partitionList()
+}
+
+// DropPartition deletes every block of the partition name. The blocks leave the
+// manifest in one entry, their files go once no reader uses them anymore.
+func (t *Table) DropPartition(name string) error {
+	t.mu.Lock()
+
+	blocks := t.partitionBlocks(name)
+	if len(blocks) == 0 {
+		t.mu.Unlock()
+		return fmt.Errorf("partition %s not found", name)
+	}
+
+	if err := t.removeBlocks(blocks); err != nil {
+		t.mu.Unlock()
+		return err
+	}
+	t.mu.Unlock()
+
+	if err := t.removeRetired(); err != nil {
+		return err
+	}
+	t.removePartitionDirs([]string{name})
+
+	return nil
+}
+
+// removePartitionDirs removes the directories of the partitions in names that no longer
+// hold blocks. It takes the lock so no flush recreates a partition meanwhile, a directory
+// that still holds files pinned by a reader is left for LoadFromDisk.
+func (t *Table) removePartitionDirs(names []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, name := range names {
+		if _, ok := t.partitions[name]; !ok && t.UseDiskStorage {
+			os.Remove(filepath.Join(t.dir, name))
+		}
+	}
+}
+
+// archiveAttempts bounds how often ArchivePartition copies a partition that keeps
+// changing while it is copied.
+const archiveAttempts = 3
+
+// afterArchiveCopy runs between the copy and the drop of ArchivePartition, tests use it
+// to change the partition in between.
+var afterArchiveCopy = func() {}
+
+// ArchivePartition copies the blocks of the partition name to dest, with a manifest of
+// their own so dest can be opened as a table directory, and then drops the partition.
+// Only the copied blocks are dropped, when a flush or compaction changed the partition
+// during the copy it is copied again.
+func (t *Table) ArchivePartition(name, dest string) error {
+	var copied []*Block
+	for attempt := 0; attempt < archiveAttempts; attempt++ {
+		blocks, done, err := t.archiveOnce(name, dest)
+		if err != nil {
+			return err
+		}
+
+		// files of an earlier copy whose blocks were compacted away since
+		for _, block := range copied {
+			if !slices.Contains(blocks, block) {
+				os.Remove(filepath.Join(dest, filepath.Base(block.Path)))
+			}
+		}
+		copied = blocks
+
+		if done {
+			if err := t.removeRetired(); err != nil {
+				return err
+			}
+			t.removePartitionDirs([]string{name})
+			return nil
+		}
+	}
+	return fmt.Errorf("partition %s kept changing while it was archived", name)
+}
+
+// archiveOnce copies the blocks of the partition name to dest and removes them from the
+// table if the partition still holds exactly those blocks, done reports whether it did.
+func (t *Table) archiveOnce(name, dest string) (blocks []*Block, done bool, err error) {
+	t.mu.RLock()
+	blocks = t.partitionBlocks(name)
+	// pinned like a reader, so compaction or retention cannot delete the files mid-copy
+	epoch := t.pin()
+	t.mu.RUnlock()
+
+	err = archiveBlocks(name, blocks, dest)
+	if unpinErr := t.unpin(epoch); err == nil {
+		err = unpinErr
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	afterArchiveCopy()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	current := t.partitionBlocks(name)
+	changed := len(current) != len(blocks) || slices.ContainsFunc(current, func(b *Block) bool {
+		return !slices.Contains(blocks, b)
+	})
+	if changed {
+		return blocks, false, nil
+	}
+	return blocks, true, t.removeBlocks(blocks)
+}
+
+// archiveBlocks copies blocks of the partition name to dest and writes a manifest
+// listing them there.
+func archiveBlocks(name string, blocks []*Block, dest string) error {
+	if len(blocks) == 0 {
+		return fmt.Errorf("partition %s not found", name)
+	}
+	for _, block := range blocks {
+		if !block.isOnDisk {
+			return fmt.Errorf("partition %s has blocks that are not on disk", name)
+		}
+	}
+
+	metas := make([]blockMeta, len(blocks))
+	for i, block := range blocks {
+		target := filepath.Join(dest, filepath.Base(block.Path))
+		_, _, err := writeFileAtomic(target, func(w io.Writer) error {
+			f, err := os.Open(block.Path)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+
+			_, err = io.Copy(w, f)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to archive block %s: %v", filepath.Base(block.Path), err)
+		}
+
+		metas[i] = newBlockMeta(block)
+		metas[i].Partition = ""
+	}
+
+	if err := writeManifest(dest, metas); err != nil {
+		return fmt.Errorf("failed to write archive manifest: %v", err)
+	}
+	return nil
+}
+
+// CompactPartition is Compact restricted to the blocks of the partition name.
+func (t *Table) CompactPartition(name string, p CompactionPolicy) (int, error) {
+	t.mu.RLock()
+	_, ok := t.partitions[name]
+	t.mu.RUnlock()
+
+	if !ok {
+		return 0, fmt.Errorf("partition %s not found", name)
+	}
+	return t.compact(p, func(b *Block) bool { return b.Partition == name })
+}
+
+// partitionBlocks returns the cold blocks of partition name, callers must hold t.mu.
+func (t *Table) partitionBlocks(name string) []*Block {
+	if name == "" {
+		return nil
+	}
+
+	var blocks []*Block
+	for _, block := range t.coldBlocks {
+		if block.Partition == name {
+			blocks = append(blocks, block)
+		}
+	}
+	return blocks
+}
+
+// removeBlocks takes blocks out of the table with a single manifest entry and retires
+// their files. Callers must hold t.mu and call removeRetired once they released it.
+func (t *Table) removeBlocks(blocks []*Block) error {
+	var entry manifestEntry
+	var paths []string
+	for _, block := range blocks {
+		if block.isOnDisk {
+			entry.Remove = append(entry.Remove, filepath.Base(block.Path))
+			paths = append(paths, block.Path)
+		}
+	}
+
+	if len(paths) > 0 {
+		if err := appendManifest(t.dir, entry); err != nil {
+			return fmt.Errorf("failed to record removed blocks in manifest: %v", err)
+		}
+	}
+
+	t.coldBlocks = slices.DeleteFunc(t.coldBlocks, func(b *Block) bool {
+		return slices.Contains(blocks, b)
+	})
+	for _, block := range blocks {
+		t.rowCount -= block.RowCount
+	}
+
+	if len(paths) > 0 {
+		t.retire(paths)
+	}
+	t.notePartitions()
+	return nil
+}
+
+// skipPartition reports whether the time predicates of the reader rule out every row of
+// the partition name, so its blocks are skipped without looking at their stats.
+func (tr *TableReader) skipPartition(name string) bool {
+	part, ok := tr.partitions[name]
+	if !ok {
+		return false
+	}
+
+	skip, _ := prune(andExpr(tr.filters), func(p Predicate) (bool, error) {
+		if p.ColName != tr.schema.TimeColumn {
+			return false, nil
+		}
+		return part.rulesOut(p), nil
+	})
+	return skip
+}
+
+// rulesOut reports whether no time column value in part matches p.
+func (part Partition) rulesOut(p Predicate) bool {
+	if set, ok := p.Value.(map[int64]bool); ok && p.Op == OpIn {
+		for v := range set {
+			if v >= part.Start && v < part.End {
+				return false
+			}
+		}
+		return true
+	}
+
+	v, ok := p.Value.(int64)
+	if !ok {
+		return false
+	}
+
+	// partitions hold Start <= ts < End, so End-1 is the newest possible row
+	switch p.Op {
+	case "==":
+		return v < part.Start || v >= part.End
+	case ">":
+		return part.End-1 <= v
+	case ">=":
+		return part.End-1 < v
+	case "<":
+		return part.Start >= v
+	case "<=":
+		return part.Start > v
+	}
+	return false
+}
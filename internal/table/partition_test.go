@@ -0,0 +1,361 @@
+package table
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const day = int64(24 * 60 * 60)
+
+// partitionedTable writes 6 rows half a day apart in blocks of 3 to a table partitioned
+// by day, ts in seconds, so every block straddles a day boundary
+func partitionedTable(t *testing.T) (*Table, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	tbl, err := CreateTable(manifestSchema(), nil, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tbl.MaxBlockSize = 3
+	tbl.UseDiskStorage = true
+	tbl.Partitioning = Partitioning{By: PartitionDay, Unit: time.Second}
+
+	for i := 0; i < 6; i++ {
+		row := map[string]any{"ts": int64(i) * day / 2, "price": float64(i), "is_buy": true}
+		if err := tbl.AppendRow(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return tbl, dir
+}
+
+// reloadPartitioned is reload for tables of partitionedTable, partition bounds are read
+// in the unit of the table
+func reloadPartitioned(t *testing.T, dir string) (*Table, error) {
+	t.Helper()
+
+	tbl, err := CreateTable(manifestSchema(), nil, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tbl.Partitioning = Partitioning{By: PartitionDay, Unit: time.Second}
+	return tbl, tbl.LoadFromDisk()
+}
+
+func TestPartitionOf(t *testing.T) {
+	at := time.Date(2024, 2, 29, 13, 45, 0, 0, time.UTC)
+
+	tests := []struct {
+		p          Partitioning
+		ts         int64
+		name       string
+		start, end time.Time
+	}{
+		{Partitioning{By: PartitionHour}, at.UnixNano(), "2024-02-29T13",
+			time.Date(2024, 2, 29, 13, 0, 0, 0, time.UTC), time.Date(2024, 2, 29, 14, 0, 0, 0, time.UTC)},
+		{Partitioning{By: PartitionDay, Unit: time.Millisecond}, at.UnixMilli(), "2024-02-29",
+			time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{Partitioning{By: PartitionMonth, Unit: time.Second}, at.Unix(), "2024-02",
+			time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.p.By.String(), func(t *testing.T) {
+			part := tt.p.partitionOf(tt.ts)
+			unit := tt.p.unit()
+			if part.Name != tt.name || part.Start != tt.start.UnixNano()/unit || part.End != tt.end.UnixNano()/unit {
+				t.Errorf("expected %s [%d, %d), got %s [%d, %d)", tt.name, tt.start.UnixNano()/unit, tt.end.UnixNano()/unit, part.Name, part.Start, part.End)
+			}
+
+			parsed, err := tt.p.parsePartition(part.Name)
+			if err != nil || parsed != part {
+				t.Errorf("expected %s to parse back to %v, got %v (%v)", part.Name, part, parsed, err)
+			}
+		})
+	}
+
+	if _, err := (Partitioning{}).parsePartition("wal"); err == nil {
+		t.Error("expected a directory that is no partition to be rejected")
+	}
+	if err := (Partitioning{By: Granularity(9)}).Validate(); err == nil {
+		t.Error("expected an unknown granularity to be rejected")
+	}
+}
+
+func TestPartitioning(t *testing.T) {
+	t.Run("Layout", func(t *testing.T) {
+		tbl, dir := partitionedTable(t)
+
+		parts := tbl.Partitions()
+		if len(parts) != 3 {
+			t.Fatalf("expected 3 partitions, got %v", parts)
+		}
+		for i, part := range parts {
+			if part.Start != int64(i)*day || part.End != int64(i+1)*day {
+				t.Errorf("expected partition %d to cover day %d, got [%d, %d)", i, i, part.Start, part.End)
+			}
+		}
+
+		// the blocks straddling midnight are split, one file per partition and block
+		for name, n := range map[string]int{"1970-01-01": 1, "1970-01-02": 2, "1970-01-03": 1} {
+			if files := blockFiles(t, filepath.Join(dir, name)); len(files) != n {
+				t.Errorf("expected %d blocks in %s, found %d", n, name, len(files))
+			}
+		}
+		if files := blockFiles(t, dir); len(files) != 0 {
+			t.Errorf("expected no blocks outside the partitions, found %d", len(files))
+		}
+
+		reloaded, err := reloadPartitioned(t, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := reloaded.Partitions(); len(got) != 3 || got[2] != parts[2] {
+			t.Errorf("expected the partitions to survive a reload, got %v", got)
+		}
+		if err := reloaded.Verify(); err != nil {
+			t.Error(err)
+		}
+
+		times := scanTimes(t, reloaded.Reader())
+		if len(times) != 6 || times[5] != 5*day/2 {
+			t.Errorf("expected 6 rows back in time order, got %v", times)
+		}
+	})
+
+	t.Run("Pruning", func(t *testing.T) {
+		tbl, _ := partitionedTable(t)
+
+		r := tbl.Reader().Filter("ts", ">=", day)
+
+		// stats that rule nothing out, only the partition bounds can skip the block
+		first := r.blocks[0]
+		first.IntMin[0], first.IntMax[0] = math.MinInt64, math.MaxInt64
+		if skip, err := r.canSkipBlock(first); err != nil || !skip {
+			t.Errorf("expected the block of day 0 to be skipped on its partition, got %v (%v)", skip, err)
+		}
+		if skip, _ := r.canSkipBlock(r.blocks[1]); skip {
+			t.Error("expected the block of day 1 to be read")
+		}
+
+		times := scanTimes(t, r)
+		if len(times) != 4 || times[0] != day {
+			t.Errorf("expected the rows from day 1 on, got %v", times)
+		}
+
+		for _, tt := range []struct {
+			op   string
+			v    int64
+			skip bool
+		}{
+			{"==", day, true}, {"==", day - 1, false},
+			{">", day - 1, true}, {">", day - 2, false},
+			{"<", 0, true}, {"<", 1, false},
+			{"<=", -1, true}, {"<=", 0, false},
+		} {
+			r := tbl.Reader().Filter("ts", tt.op, tt.v)
+			if skip := r.skipPartition("1970-01-01"); skip != tt.skip {
+				t.Errorf("ts %s %d: expected skip %v on day 0, got %v", tt.op, tt.v, tt.skip, skip)
+			}
+			r.Close()
+		}
+	})
+
+	t.Run("Drop", func(t *testing.T) {
+		tbl, dir := partitionedTable(t)
+
+		var seen [][]Partition
+		tbl.OnPartitionsChange = func(parts []Partition) { seen = append(seen, parts) }
+
+		if err := tbl.DropPartition("1970-01-02"); err != nil {
+			t.Fatal(err)
+		}
+		if len(seen) != 1 || len(seen[0]) != 2 {
+			t.Errorf("expected one change down to 2 partitions, got %v", seen)
+		}
+		if tbl.RowCount() != 4 {
+			t.Errorf("expected 4 rows left, got %d", tbl.RowCount())
+		}
+		if _, err := os.Stat(filepath.Join(dir, "1970-01-02")); !os.IsNotExist(err) {
+			t.Errorf("expected the partition directory to be removed, got %v", err)
+		}
+		if err := tbl.DropPartition("1970-01-02"); err == nil {
+			t.Error("expected dropping a missing partition to fail")
+		}
+
+		reloaded, err := reloadPartitioned(t, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reloaded.RowCount() != 4 || len(reloaded.Partitions()) != 2 {
+			t.Errorf("expected 4 rows in 2 partitions after reload, got %d in %d", reloaded.RowCount(), len(reloaded.Partitions()))
+		}
+	})
+
+	t.Run("DropWhileReading", func(t *testing.T) {
+		tbl, dir := partitionedTable(t)
+
+		r := tbl.Reader()
+		if err := tbl.DropPartition("1970-01-01"); err != nil {
+			t.Fatal(err)
+		}
+		if files := blockFiles(t, filepath.Join(dir, "1970-01-01")); len(files) != 1 {
+			t.Fatalf("expected the dropped block to stay while a reader uses it, found %d", len(files))
+		}
+		if times := scanTimes(t, r); len(times) != 6 {
+			t.Errorf("expected the older snapshot to read all 6 rows, got %d", len(times))
+		}
+
+		// the empty directory goes on the next load
+		if _, err := reloadPartitioned(t, dir); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "1970-01-01")); !os.IsNotExist(err) {
+			t.Errorf("expected the empty partition directory to be removed on load, got %v", err)
+		}
+	})
+
+	t.Run("Archive", func(t *testing.T) {
+		tbl, _ := partitionedTable(t)
+		dest := filepath.Join(t.TempDir(), "archive")
+
+		if err := tbl.ArchivePartition("1970-01-02", dest); err != nil {
+			t.Fatal(err)
+		}
+		if tbl.RowCount() != 4 || len(tbl.Partitions()) != 2 {
+			t.Errorf("expected the archived partition to be dropped, got %d rows in %d partitions", tbl.RowCount(), len(tbl.Partitions()))
+		}
+		// the copy pins its files like a reader and lets go of them for the drop
+		if n := tbl.liveReaders(); n != 0 {
+			t.Errorf("expected no pinned readers after archiving, got %d", n)
+		}
+		if _, err := os.Stat(filepath.Join(tbl.dir, "1970-01-02")); !os.IsNotExist(err) {
+			t.Errorf("expected the archived files to be removed, got %v", err)
+		}
+
+		archived, err := reload(t, dest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := archived.Verify(); err != nil {
+			t.Error(err)
+		}
+		times := scanTimes(t, archived.Reader())
+		if len(times) != 2 || times[0] != day || times[1] != 3*day/2 {
+			t.Errorf("expected the 2 rows of day 1 in the archive, got %v", times)
+		}
+	})
+
+	t.Run("ArchiveLateFlush", func(t *testing.T) {
+		tbl, _ := partitionedTable(t)
+		dest := filepath.Join(t.TempDir(), "archive")
+
+		// more rows of day 2 flush a block into it after the first copy
+		flushed := false
+		afterArchiveCopy = func() {
+			if flushed {
+				return
+			}
+			flushed = true
+			for i := int64(1); i <= 3; i++ {
+				if err := tbl.AppendRow(map[string]any{"ts": 5*day/2 + i, "price": 9.0, "is_buy": false}); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		defer func() { afterArchiveCopy = func() {} }()
+
+		if err := tbl.ArchivePartition("1970-01-03", dest); err != nil {
+			t.Fatal(err)
+		}
+		if !flushed {
+			t.Fatal("expected the late rows to be flushed during the archive")
+		}
+		if tbl.RowCount() != 4 {
+			t.Errorf("expected only the other partitions to be left, got %d rows", tbl.RowCount())
+		}
+
+		archived, err := reload(t, dest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if times := scanTimes(t, archived.Reader()); len(times) != 5 {
+			t.Errorf("expected the late rows in the archive too, got %v", times)
+		}
+	})
+
+	t.Run("Compaction", func(t *testing.T) {
+		tbl, _ := partitionedTable(t)
+
+		// the two blocks of day 1 merge, nothing merges across midnight
+		n, err := tbl.Compact(CompactionPolicy{TargetRows: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 1 || len(tbl.coldBlocks) != 3 {
+			t.Fatalf("expected one merged block and 3 blocks left, got %d and %d", n, len(tbl.coldBlocks))
+		}
+		if b := tbl.coldBlocks[1]; b.Partition != "1970-01-02" || b.RowCount != 2 {
+			t.Errorf("expected the merged block in 1970-01-02 with 2 rows, got %s with %d", b.Partition, b.RowCount)
+		}
+
+		if _, err := tbl.CompactPartition("1970-01-09", CompactionPolicy{}); err == nil {
+			t.Error("expected compacting a missing partition to fail")
+		}
+	})
+
+	t.Run("CompactPartition", func(t *testing.T) {
+		tbl, _ := partitionedTable(t)
+
+		// days 3 to 5, day 4 ends up in two blocks like day 1
+		for i := 6; i < 12; i++ {
+			if err := tbl.AppendRow(map[string]any{"ts": int64(i) * day / 2, "price": 1.0, "is_buy": false}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if len(tbl.coldBlocks) != 8 {
+			t.Fatalf("expected 8 blocks, got %d", len(tbl.coldBlocks))
+		}
+
+		n, err := tbl.CompactPartition("1970-01-02", CompactionPolicy{TargetRows: 10})
+		if err != nil || n != 1 {
+			t.Errorf("expected the partition to merge into one block, got %d (%v)", n, err)
+		}
+		if len(tbl.coldBlocks) != 7 {
+			t.Errorf("expected the other partitions to be left alone, got %d blocks", len(tbl.coldBlocks))
+		}
+	})
+
+	t.Run("Close", func(t *testing.T) {
+		dir := t.TempDir()
+		tbl, err := CreateTable(manifestSchema(), nil, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tbl.UseDiskStorage = true
+		tbl.Partitioning = Partitioning{By: PartitionDay, Unit: time.Second}
+
+		// an active block spanning two days is split when the table closes
+		for _, ts := range []int64{0, day / 2, day} {
+			tbl.AppendRow(map[string]any{"ts": ts, "price": 1.0, "is_buy": true})
+		}
+		if err := tbl.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if parts := tbl.Partitions(); len(parts) != 2 {
+			t.Errorf("expected 2 partitions after close, got %v", parts)
+		}
+
+		reloaded, err := reloadPartitioned(t, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reloaded.RowCount() != 3 || len(reloaded.Partitions()) != 2 {
+			t.Errorf("expected 3 rows in 2 partitions, got %d in %d", reloaded.RowCount(), len(reloaded.Partitions()))
+		}
+	})
+}
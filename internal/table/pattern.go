@@ -0,0 +1,166 @@
+package table
+
+import (
+	"backtraceDB/internal/schema"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// pattern predicates only apply to string columns and take the pattern as a string. LIKE
+// follows SQL, % matches any run of characters, _ a single one and \ escapes either. A
+// regex is Go syntax and matches anywhere in the value unless it is anchored.
+const (
+	OpPrefix    = "PREFIX"
+	OpNotPrefix = "NOT PREFIX"
+	OpLike      = "LIKE"
+	OpNotLike   = "NOT LIKE"
+	OpRegex     = "REGEX"
+	OpNotRegex  = "NOT REGEX"
+)
+
+// patternOps maps the pattern operators to whether they are negated
+var patternOps = map[string]bool{
+	OpPrefix:    false,
+	OpNotPrefix: true,
+	OpLike:      false,
+	OpNotLike:   true,
+	OpRegex:     false,
+	OpNotRegex:  true,
+}
+
+// stringPattern is the checked value of a pattern predicate.
+type stringPattern struct {
+	pattern string
+	match   func(string) bool
+}
+
+func (p stringPattern) String() string { return p.pattern }
+
+// checkPattern compiles the value of the pattern predicate p on col.
+func checkPattern(col schema.Column, p Predicate) (stringPattern, error) {
+	if col.Type != schema.String {
+		return stringPattern{}, fmt.Errorf("operator %s is not supported for %s column %s", p.Op, col.Type, col.Name)
+	}
+	pattern, ok := p.Value.(string)
+	if !ok {
+		return stringPattern{}, fmt.Errorf("invalid value type for %s on column %s: %T", p.Op, col.Name, p.Value)
+	}
+
+	switch p.Op {
+	case OpPrefix, OpNotPrefix:
+		return stringPattern{pattern, func(s string) bool { return strings.HasPrefix(s, pattern) }}, nil
+	case OpLike, OpNotLike:
+		re, err := regexp.Compile(likeToRegexp(pattern))
+		if err != nil {
+			return stringPattern{}, fmt.Errorf("invalid LIKE pattern %q: %v", pattern, err)
+		}
+		return stringPattern{pattern, re.MatchString}, nil
+	default:
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return stringPattern{}, fmt.Errorf("invalid regex %q: %v", pattern, err)
+		}
+		return stringPattern{pattern, re.MatchString}, nil
+	}
+}
+
+// likeToRegexp translates a LIKE pattern to an anchored regular expression.
+func likeToRegexp(pattern string) string {
+	var b strings.Builder
+	b.WriteString(`(?s)^`)
+
+	runes := []rune(pattern)
+	for i := 0; i < len(runes); i++ {
+		switch r := runes[i]; {
+		case r == '\\' && i+1 < len(runes):
+			i++
+			b.WriteString(regexp.QuoteMeta(string(runes[i])))
+		case r == '%':
+			b.WriteString(`.*`)
+		case r == '_':
+			b.WriteString(`.`)
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+
+	b.WriteString(`$`)
+	return b.String()
+}
+
+// matchString evaluates the comparison, set or pattern predicate p against v.
+func (tr *TableReader) matchString(p Predicate, v string) bool {
+	switch p.Op {
+	case OpIn, OpNotIn:
+		return inSet(p.Value, v) == (p.Op == OpIn)
+	}
+	if pattern, ok := p.Value.(stringPattern); ok {
+		return pattern.match(v) != patternOps[p.Op]
+	}
+	target, _ := p.Value.(string)
+	return tr.evalString(v, p.Op, target)
+}
+
+// applyStringPredicate clears the rows of mask that p does not match. p is evaluated
+// once per entry of the block dictionary into a bitmap of matching ids, rows then only
+// look up their id.
+func (tr *TableReader) applyStringPredicate(p Predicate, loc ColumnLocation, logicalIdx int, mask []bool) {
+	var matching Bitmap
+	for _, v := range tr.currentStorage.StringReads[loc.Index] {
+		matching.Append(tr.matchString(p, v))
+	}
+
+	ids := tr.currentStorage.StringCols[loc.Index]
+	for i := range mask {
+		if mask[i] && (tr.currentStorage.IsNull(logicalIdx, i) || !matching.Get(ids[i])) {
+			mask[i] = false
+		}
+	}
+}
+
+// skipString reports whether the string stats of block prove no row of the column col,
+// at idx among the string columns of the block, matches p. The distinct values settle
+// any predicate, without them min and max still rule out equality and prefixes outside
+// their range.
+func (tr *TableReader) skipString(block *Block, col schema.Column, idx int, p Predicate) (bool, error) {
+	var pattern stringPattern
+	if _, ok := patternOps[p.Op]; ok {
+		var err error
+		if pattern, ok = p.Value.(stringPattern); !ok {
+			if pattern, err = checkPattern(col, p); err != nil {
+				return false, err
+			}
+			p.Value = pattern
+		}
+	} else if _, ok := p.Value.(string); !ok {
+		return false, fmt.Errorf("invalid value type for string column %s: %T", col.Name, p.Value)
+	}
+
+	if idx >= len(block.StringMin) {
+		return false, nil
+	}
+
+	if idx < len(block.StringValues) && block.StringValues[idx] != nil {
+		for _, v := range block.StringValues[idx] {
+			if tr.matchString(p, v) {
+				return false, nil
+			}
+		}
+		return true, nil
+	}
+
+	min, max := block.StringMin[idx], block.StringMax[idx]
+	switch p.Op {
+	case "==":
+		target := p.Value.(string)
+		return target < min || target > max, nil
+	case "!=":
+		target := p.Value.(string)
+		return min == target && max == target, nil
+	case OpPrefix:
+		// values with the prefix sort from it on, and before any greater value without it
+		return max < pattern.pattern || (min > pattern.pattern && !strings.HasPrefix(min, pattern.pattern)), nil
+	}
+	return false, nil
+}
@@ -0,0 +1,238 @@
+package table
+
+import (
+	"backtraceDB/internal/schema"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"time"
+)
+
+// RetentionPolicy keeps the rows of the last Keep by the time column and expires older
+// ones. The zero value keeps everything.
+type RetentionPolicy struct {
+	Keep     time.Duration `json:"keep"`               // age of the oldest row kept, 0 keeps every row
+	Unit     time.Duration `json:"unit,omitempty"`     // unit of the time column, nanoseconds when 0
+	Interval time.Duration `json:"interval,omitempty"` // time between background passes, 0 disables them
+}
+
+func (p RetentionPolicy) validate() error {
+	if p.Keep < 0 {
+		return fmt.Errorf("retention must not be negative, got %v", p.Keep)
+	}
+	if p.Unit < 0 {
+		return fmt.Errorf("retention unit must not be negative, got %v", p.Unit)
+	}
+	if p.Interval < 0 {
+		return fmt.Errorf("retention interval must not be negative, got %v", p.Interval)
+	}
+	return nil
+}
+
+// Horizon returns the oldest time column value p keeps at now.
+func (p RetentionPolicy) Horizon(now time.Time) int64 {
+	unit := p.Unit
+	if unit == 0 {
+		unit = time.Nanosecond
+	}
+	return now.Add(-p.Keep).UnixNano() / int64(unit)
+}
+
+// StartRetention enforces p every p.Interval until the table is closed or
+// StopRetention is called. A failed pass is retried on the next tick and reported by
+// RetentionErr until then.
+func (t *Table) StartRetention(p RetentionPolicy) error {
+	if err := p.validate(); err != nil {
+		return err
+	}
+
+	interval := p.Interval
+	if p.Keep == 0 {
+		interval = 0
+	}
+
+	t.expirer.start(interval, func() error {
+		_, err := t.Expire(p.Horizon(time.Now()))
+		return err
+	})
+	return nil
+}
+
+// StopRetention stops the background expirer, waiting for a running pass to finish.
+func (t *Table) StopRetention() {
+	t.expirer.halt()
+}
+
+// RetentionErr returns the error of the last background pass, nil once one succeeds.
+func (t *Table) RetentionErr() error {
+	return t.expirer.lastErr()
+}
+
+// Expire deletes the flushed rows older than horizon and returns how many it deleted.
+// Blocks entirely before horizon are dropped, blocks straddling it are rewritten with
+// their newer rows. Both happen in a single manifest entry, so a crash leaves either
+// the old blocks or the new ones, and readers that took their snapshot before keep
+// reading the old files until they are done. Rows still in the active block expire once
+// they are flushed.
+func (t *Table) Expire(horizon int64) (int, error) {
+	// compaction rewrites the same blocks, the two passes take turns
+	t.compactMu.Lock()
+	defer t.compactMu.Unlock()
+
+	t.mu.RLock()
+	s, locations, timeIdx := t.schema, t.locations, t.timeColIdx
+
+	var expired, boundary []*Block
+	var loads []Block
+	for _, block := range t.coldBlocks {
+		switch {
+		case block.MaxTs < horizon:
+			expired = append(expired, block)
+		case block.MinTs < horizon:
+			// copied because Close persists cold blocks in place
+			boundary = append(boundary, block)
+			loads = append(loads, *block)
+		}
+	}
+	t.mu.RUnlock()
+
+	if len(expired) == 0 && len(boundary) == 0 {
+		return 0, nil
+	}
+
+	// the blocks are immutable, so they are read and cut without the lock
+	kept := make([]*Block, len(boundary))
+	var written []string
+	removeWritten := func() {
+		for _, path := range written {
+			os.Remove(path)
+		}
+	}
+
+	for i := range loads {
+		block, err := t.cutBlock(&loads[i], horizon, s, locations, timeIdx)
+		if err != nil {
+			removeWritten()
+			return 0, err
+		}
+		kept[i] = block
+		if block.isOnDisk {
+			written = append(written, block.Path)
+		}
+	}
+
+	t.mu.Lock()
+
+	// a partition dropped meanwhile may have taken some of the blocks already
+	var entry manifestEntry
+	var gone []*Block
+	var paths []string
+	var partitions []string
+	removed := 0
+
+	drop := func(block *Block) {
+		gone = append(gone, block)
+		removed += block.RowCount
+		if block.Partition != "" {
+			partitions = append(partitions, block.Partition)
+		}
+		if block.isOnDisk {
+			entry.Remove = append(entry.Remove, filepath.Base(block.Path))
+			paths = append(paths, block.Path)
+		}
+	}
+
+	for _, block := range expired {
+		if slices.Contains(t.coldBlocks, block) {
+			drop(block)
+		}
+	}
+
+	var added []*Block
+	for i, block := range boundary {
+		if !slices.Contains(t.coldBlocks, block) {
+			if kept[i].isOnDisk {
+				os.Remove(kept[i].Path)
+			}
+			continue
+		}
+		drop(block)
+		removed -= kept[i].RowCount
+		added = append(added, kept[i])
+		if kept[i].isOnDisk {
+			entry.Add = append(entry.Add, newBlockMeta(kept[i]))
+		}
+	}
+
+	if len(entry.Add) > 0 || len(entry.Remove) > 0 {
+		if err := appendManifest(t.dir, entry); err != nil {
+			t.mu.Unlock()
+			removeWritten()
+			return 0, fmt.Errorf("failed to record expired blocks in manifest: %v", err)
+		}
+	}
+
+	t.coldBlocks = slices.DeleteFunc(t.coldBlocks, func(b *Block) bool {
+		return slices.Contains(gone, b)
+	})
+	for _, block := range added {
+		t.addColdBlock(block)
+	}
+	t.rowCount -= removed
+
+	if len(paths) > 0 {
+		t.retire(paths)
+	}
+	t.notePartitions()
+	t.mu.Unlock()
+
+	if err := t.removeRetired(); err != nil {
+		return removed, err
+	}
+	t.removePartitionDirs(partitions)
+
+	return removed, nil
+}
+
+// cutBlock writes the rows of block from horizon on to a new block in the same
+// partition, stored the way block is.
+func (t *Table) cutBlock(block *Block, horizon int64, s schema.Schema, locations []ColumnLocation, timeIdx int) (*Block, error) {
+	storage, _, err := NewColumnStorage(columnTypes(s))
+	if err != nil {
+		return nil, err
+	}
+	if err := block.LoadInto(storage, s, locations); err != nil {
+		return nil, fmt.Errorf("failed to read block %s for retention: %v", filepath.Base(block.Path), err)
+	}
+
+	// blocks are sorted by time when they are flushed
+	ts := storage.Int64Cols[locations[timeIdx].Index]
+	lo := sort.Search(len(ts), func(i int) bool { return ts[i] >= horizon })
+
+	order := make([]int, len(ts)-lo)
+	for i := range order {
+		order[i] = lo + i
+	}
+
+	kept := &Block{
+		Storage:       storage.permute(order),
+		RowCount:      len(order),
+		MinTs:         ts[lo],
+		MaxTs:         ts[len(ts)-1],
+		SchemaVersion: s.Version,
+		MaxLSN:        block.MaxLSN,
+		Partition:     block.Partition,
+	}
+	kept.allocStats(columnTypes(s))
+
+	t.mu.Lock()
+	path := t.blockPath(kept)
+	t.mu.Unlock()
+
+	if err := kept.Rotate(block.isOnDisk, path, s, locations); err != nil {
+		return nil, fmt.Errorf("failed to write block cut at the retention horizon: %v", err)
+	}
+	return kept, nil
+}
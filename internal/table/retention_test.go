@@ -0,0 +1,141 @@
+package table
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRetention(t *testing.T) {
+	t.Run("Expire", func(t *testing.T) {
+		tbl, dir := smallBlocks(t)
+
+		// blocks 0-10 and 20-30 go, 40-50 is cut down to its row at 50
+		n, err := tbl.Expire(45)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 5 || tbl.RowCount() != 5 {
+			t.Errorf("expected 5 rows expired and 5 left, got %d and %d", n, tbl.RowCount())
+		}
+		if first := tbl.coldBlocks[0]; first.MinTs != 50 || first.MaxTs != 50 || first.RowCount != 1 || first.FloatMin[0] != 5 {
+			t.Errorf("expected the boundary block to keep ts 50 only, got %d to %d in %d rows", first.MinTs, first.MaxTs, first.RowCount)
+		}
+		if files := blockFiles(t, dir); len(files) != 3 {
+			t.Errorf("expected 3 block files left, found %d", len(files))
+		}
+		if err := tbl.Verify(); err != nil {
+			t.Error(err)
+		}
+
+		times := scanTimes(t, tbl.Reader())
+		if len(times) != 5 || times[0] != 50 {
+			t.Errorf("expected ts 50 to 90, got %v", times)
+		}
+
+		if n, err := tbl.Expire(45); err != nil || n != 0 {
+			t.Errorf("expected nothing left to expire, got %d (%v)", n, err)
+		}
+
+		reloaded, err := reload(t, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reloaded.RowCount() != 5 || len(reloaded.coldBlocks) != 3 {
+			t.Errorf("expected 5 rows in 3 blocks after reload, got %d in %d", reloaded.RowCount(), len(reloaded.coldBlocks))
+		}
+	})
+
+	t.Run("ReaderInFlight", func(t *testing.T) {
+		tbl, dir := smallBlocks(t)
+
+		r := tbl.Reader()
+		if _, ok := r.Next(); !ok {
+			t.Fatal("expected a first row")
+		}
+		if _, err := tbl.Expire(45); err != nil {
+			t.Fatal(err)
+		}
+
+		// the snapshot still reads the expired and the uncut blocks
+		rest := scanTimes(t, r)
+		checkTimes(t, append([]int64{0}, rest...), 10)
+
+		if files := blockFiles(t, dir); len(files) != 3 {
+			t.Errorf("expected the expired files to go once the reader is done, found %d", len(files))
+		}
+	})
+
+	t.Run("Partitions", func(t *testing.T) {
+		tbl, dir := partitionedTable(t)
+
+		if _, err := tbl.Expire(day + day/2); err != nil {
+			t.Fatal(err)
+		}
+		if parts := tbl.Partitions(); len(parts) != 2 || parts[0].Name != "1970-01-02" {
+			t.Errorf("expected day 0 to expire, got %v", parts)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "1970-01-01")); !os.IsNotExist(err) {
+			t.Errorf("expected the expired partition directory to be removed, got %v", err)
+		}
+		if files := blockFiles(t, filepath.Join(dir, "1970-01-02")); len(files) != 1 {
+			t.Errorf("expected one block of day 1 left, found %d", len(files))
+		}
+	})
+
+	t.Run("InMemory", func(t *testing.T) {
+		tbl, err := CreateTable(manifestSchema(), nil, t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		tbl.MaxBlockSize = 2
+		for i := 0; i < 6; i++ {
+			tbl.AppendRow(map[string]any{"ts": int64(i * 10), "price": 1.0, "is_buy": true})
+		}
+
+		if n, err := tbl.Expire(25); err != nil || n != 3 {
+			t.Errorf("expected 3 rows expired, got %d (%v)", n, err)
+		}
+		times := scanTimes(t, tbl.Reader())
+		if len(times) != 3 || times[0] != 30 {
+			t.Errorf("expected ts 30 to 50, got %v", times)
+		}
+	})
+
+	t.Run("Background", func(t *testing.T) {
+		tbl, _ := smallBlocks(t)
+
+		if err := tbl.StartRetention(RetentionPolicy{Keep: -time.Hour}); err == nil {
+			t.Error("expected a negative retention to be rejected")
+		}
+
+		// every row dates from 1970
+		if err := tbl.StartRetention(RetentionPolicy{Keep: time.Hour, Interval: 5 * time.Millisecond}); err != nil {
+			t.Fatal(err)
+		}
+
+		deadline := time.Now().Add(5 * time.Second)
+		for tbl.RowCount() > 0 && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+
+		if err := tbl.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := tbl.RetentionErr(); err != nil {
+			t.Error(err)
+		}
+		if tbl.RowCount() != 0 {
+			t.Errorf("expected every row to expire, %d left", tbl.RowCount())
+		}
+	})
+
+	t.Run("Horizon", func(t *testing.T) {
+		now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+		p := RetentionPolicy{Keep: 90 * 24 * time.Hour, Unit: time.Millisecond}
+		if got, want := p.Horizon(now), now.AddDate(0, 0, -90).UnixMilli(); got != want {
+			t.Errorf("expected horizon %d, got %d", want, got)
+		}
+	})
+}
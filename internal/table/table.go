@@ -4,16 +4,28 @@ package table
 import (
 	"backtraceDB/internal/schema"
 	"backtraceDB/internal/wal"
+	"cmp"
+	"errors"
 	"fmt"
+	"math"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/parquet-go/parquet-go"
 )
 
+// Table is safe for concurrent use. Appends, alters and flushes take the write lock,
+// Reader only holds the read lock while it snapshots the blocks, so scans never block
+// ingestion. MaxBlockSize, UseDiskStorage, AllowOutOfOrder, Partitioning and
+// OnPartitionsChange must be set before the table is shared.
 type Table struct {
+	mu sync.RWMutex
+
 	schema         schema.Schema
 	activeBlock    *Block
 	coldBlocks     []*Block
@@ -24,43 +36,168 @@ type Table struct {
 	wal            *wal.WAL
 	MaxBlockSize   int
 	UseDiskStorage bool
-	dbName         string
+	SyncPolicy     wal.SyncPolicy // applied to the WAL when the table creates it
+	dir            string         // holds the parquet blocks and the wal of this table
+	nextBlockID    int
+
+	// AllowOutOfOrder accepts rows older than the newest one. They are sorted into the
+	// active block, rows older than every flushed block go to a block of their own
+	AllowOutOfOrder bool
+
+	// Partitioning files flushed blocks under one sub-directory per time bucket.
+	// OnPartitionsChange is called with every partition, oldest first, whenever one is
+	// created or dropped; it runs under the table lock and must not call back into it
+	Partitioning       Partitioning
+	OnPartitionsChange func([]Partition)
+	partitions         map[string]Partition
+
+	// every schema version a block or WAL record of this table may use, with the
+	// column layout of that version so old block stats can still be resolved
+	versions map[int]schema.Schema
+	layouts  map[int][]ColumnLocation
+
+	// compactMu serializes the passes that rewrite blocks, compaction and retention,
+	// which run in the background as the compactor and the expirer
+	compactMu sync.Mutex
+	compactor backgroundJob
+	expirer   backgroundJob
+
+	// detached is set once Detach took the table out of service, closed once Close wrote
+	// out the active block and closed the WAL
+	detached bool
+	closed   bool
+
+	// readers pin the epoch their snapshot was taken in, block files retired by
+	// compaction are deleted once no reader of their epoch or an older one is left
+	filesMu sync.Mutex
+	epoch   uint64
+	readers map[uint64]int
+	retired []retiredFiles
 }
 
+// null predicates take no value, every other operator never matches a null row
+const (
+	OpIsNull    = "IS NULL"
+	OpIsNotNull = "IS NOT NULL"
+)
+
+// errNoMoreBlocks ends a scan, it is never reported through Err
+var errNoMoreBlocks = errors.New("no more blocks to load")
+
 type Predicate struct {
 	ColName string
 	Op      string
 	Value   any
 }
 
+// TableReader scans a snapshot of the table taken when Reader was called. Rows appended
+// and blocks flushed afterwards are not visible to it.
 type TableReader struct {
 	table           *Table
+	schema          schema.Schema
+	locations       []ColumnLocation
+	versions        map[int]schema.Schema
+	layouts         map[int][]ColumnLocation
 	blocks          []*Block
+	rowCount        int
 	currentBlockIdx int
 	currentStorage  *ColumnStorage
-	predicates      []Predicate
+	filters         []Expr // every one has to match
+
+	// logical columns returned by Next and NextBatch, every column when nil
+	projection []int
+
+	// err is the first error of the scan, Next and NextBatch stop once it is set
+	err error
 
 	localMask   []bool
 	localCursor int
+
+	// epoch the snapshot was taken in, its block files stay on disk until Close
+	epoch  uint64
+	closed bool
+
+	ordered bool
+
+	// bounds of the partitions at snapshot time, they prune blocks before their stats
+	partitions map[string]Partition
 }
 
 func (t *Table) Reader() *TableReader {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	// nothing to pin, the files may be gone already
+	if t.detached {
+		return &TableReader{
+			table:     t,
+			schema:    t.schema,
+			locations: t.locations,
+			err:       fmt.Errorf("table %s has been detached", t.schema.Name),
+			closed:    true,
+		}
+	}
+
+	// cold blocks are copied because Close persists them in place, the active block is
+	// cut at its current row count since appends keep writing into its storage
 	allBlocks := make([]*Block, 0, len(t.coldBlocks)+1)
-	allBlocks = append(allBlocks, t.coldBlocks...)
-	allBlocks = append(allBlocks, t.activeBlock)
+	for _, block := range t.coldBlocks {
+		b := *block
+		allBlocks = append(allBlocks, &b)
+	}
+	if t.activeBlock.Storage != nil {
+		allBlocks = append(allBlocks, &Block{
+			Storage:       t.activeBlock.Storage.snapshot(t.activeBlock.RowCount),
+			RowCount:      t.activeBlock.RowCount,
+			MinTs:         t.activeBlock.MinTs,
+			MaxTs:         t.activeBlock.MaxTs,
+			SchemaVersion: t.activeBlock.SchemaVersion,
+			unsorted:      t.activeBlock.unsorted,
+		})
+	} else {
+		// a closed table has persisted its active block
+		b := *t.activeBlock
+		allBlocks = append(allBlocks, &b)
+	}
+
+	versions := make(map[int]schema.Schema, len(t.versions))
+	layouts := make(map[int][]ColumnLocation, len(t.layouts))
+	for v, s := range t.versions {
+		versions[v] = s
+		layouts[v] = t.layouts[v]
+	}
+
+	partitions := make(map[string]Partition, len(t.partitions))
+	for name, part := range t.partitions {
+		partitions[name] = part
+	}
 
 	return &TableReader{
 		table:           t,
+		epoch:           t.pin(),
+		schema:          t.schema,
+		locations:       t.locations,
+		versions:        versions,
+		layouts:         layouts,
 		blocks:          allBlocks,
+		rowCount:        t.rowCount,
 		currentBlockIdx: 0,
 		currentStorage:  nil,
-		predicates:      []Predicate{},
+		filters:         []Expr{},
 		localMask:       nil,
 		localCursor:     0,
+		partitions:      partitions,
 	}
 }
 
-func (tr *TableReader) Next() (map[string]any, bool) { // L1 optimization : instead of retruning single row, return vectorized values at single next pass
+// Next returns the next matching row as a map. Bulk scans should use NextBatch, which
+// hands out whole column vectors instead of boxing every value. Pages of a block the
+// filters rule out are never decoded, their rows are not even looked at.
+func (tr *TableReader) Next() (map[string]any, bool) {
+	if tr.err != nil {
+		tr.Close()
+		return nil, false
+	}
 
 	for {
 		if tr.currentStorage == nil || tr.localCursor >= len(tr.localMask) {
@@ -72,7 +209,7 @@ func (tr *TableReader) Next() (map[string]any, bool) { // L1 optimization : inst
 				tr.localCursor = 0
 			}
 
-			if err := tr.LoadNextBlock(); err != nil {
+			if err := tr.loadNextBlock(); err != nil {
 				return nil, false
 			}
 		}
@@ -84,16 +221,13 @@ func (tr *TableReader) Next() (map[string]any, bool) { // L1 optimization : inst
 		if tr.localCursor < len(tr.localMask) {
 			row := make(map[string]any)
 
-			for logicalIdx, col := range tr.table.schema.Columns {
-				loc := tr.table.locations[logicalIdx]
-				switch col.Type {
-				case schema.Int64:
-					row[col.Name] = tr.currentStorage.Int64Cols[loc.Index][tr.localCursor]
-				case schema.Float64:
-					row[col.Name] = tr.currentStorage.Float64Cols[loc.Index][tr.localCursor]
-				case schema.String:
-					strID := tr.currentStorage.StringCols[loc.Index][tr.localCursor]
-					row[col.Name] = tr.currentStorage.StringReads[loc.Index][strID]
+			if tr.projection == nil {
+				for logicalIdx, col := range tr.schema.Columns {
+					row[col.Name] = tr.currentStorage.Value(logicalIdx, tr.localCursor)
+				}
+			} else {
+				for _, logicalIdx := range tr.projection {
+					row[tr.schema.Columns[logicalIdx].Name] = tr.currentStorage.Value(logicalIdx, tr.localCursor)
 				}
 			}
 
@@ -105,10 +239,91 @@ func (tr *TableReader) Next() (map[string]any, bool) { // L1 optimization : inst
 
 }
 
-func (t *Table) getColumnLocation(colName string) (ColumnLocation, bool) {
-	for i, col := range t.schema.Columns {
+// NextBatch returns the next block that has matching rows as a RecordBatch, holding
+// only the rows of the pages the filters may match. It shares its position with Next:
+// when Next stopped inside a block, the batch holds the rows of that block Next has not
+// returned yet.
+func (tr *TableReader) NextBatch() (*RecordBatch, bool) {
+	if tr.err != nil {
+		tr.Close()
+		return nil, false
+	}
+
+	for {
+		if tr.currentStorage == nil || tr.localCursor >= len(tr.localMask) {
+			if tr.currentStorage != nil {
+				tr.currentBlockIdx++
+				tr.currentStorage = nil
+				tr.localMask = nil
+				tr.localCursor = 0
+			}
+
+			if err := tr.loadNextBlock(); err != nil {
+				return nil, false
+			}
+		}
+
+		selection := tr.localMask
+		for i := 0; i < tr.localCursor; i++ {
+			selection[i] = false
+		}
+		tr.localCursor = len(selection)
+
+		batch := newRecordBatch(tr.schema, tr.currentStorage, selection, tr.projection)
+		if batch.Selected() > 0 {
+			return batch, true
+		}
+	}
+}
+
+// Err returns the error that stopped the scan, nil when Next or NextBatch returned false
+// because every row has been read.
+func (tr *TableReader) Err() error {
+	return tr.err
+}
+
+// loadNextBlock is LoadNextBlock for Next and NextBatch, it keeps any error other than
+// the end of the table for Err.
+func (tr *TableReader) loadNextBlock() error {
+	err := tr.LoadNextBlock()
+	if err != nil && err != errNoMoreBlocks {
+		tr.err = err
+	}
+	if err != nil {
+		tr.Close()
+	}
+	return err
+}
+
+// Close releases the snapshot so block files compacted away since it was taken can be
+// deleted. Next and NextBatch close the reader once they return false, only readers
+// abandoned halfway need to be closed by hand.
+func (tr *TableReader) Close() error {
+	if tr.closed {
+		return nil
+	}
+	tr.closed = true
+	return tr.table.unpin(tr.epoch)
+}
+
+// RowCount returns the number of rows in the snapshot before any filter is applied.
+func (tr *TableReader) RowCount() int {
+	return tr.rowCount
+}
+
+// layout returns a schema version as it was known when the snapshot was taken.
+func (tr *TableReader) layout(version int) (schema.Schema, []ColumnLocation, bool) {
+	s, ok := tr.versions[version]
+	if !ok {
+		return schema.Schema{}, nil, false
+	}
+	return s, tr.layouts[version], true
+}
+
+func (tr *TableReader) getColumnLocation(colName string) (ColumnLocation, bool) {
+	for i, col := range tr.schema.Columns {
 		if col.Name == colName {
-			return t.locations[i], true
+			return tr.locations[i], true
 		}
 	}
 	return ColumnLocation{}, false
@@ -160,13 +375,66 @@ func (tr *TableReader) evalString(a string, op string, b string) bool {
 	return false
 }
 
+func (tr *TableReader) evalBool(a bool, op string, b bool) bool {
+	switch op {
+	case "==":
+		return a == b
+	case "!=":
+		return a != b
+	}
+	return false
+}
+
+// Filter adds a predicate to the reader. The column, operator and value are checked
+// right away, a bad predicate makes Next return false and is reported by Err.
 func (tr *TableReader) Filter(colName string, op string, value any) *TableReader {
+	return tr.Where(Predicate{ColName: colName, Op: op, Value: value})
+}
 
-	tr.predicates = append(tr.predicates,
-		Predicate{ColName: colName,
-			Op:    op,
-			Value: value,
-		})
+// Where adds the expression e to the reader, for filters Filter cannot chain:
+//
+//	r.Where(table.And(
+//		table.In("symbol", "BTC", "ETH"),
+//		table.Or(table.Compare("price", "<", 100), table.Compare("qty", ">", 1000)),
+//	))
+//
+// It is checked like a predicate passed to Filter.
+func (tr *TableReader) Where(e Expr) *TableReader {
+	e, err := tr.checkExpr(e)
+	if err != nil {
+		if tr.err == nil {
+			tr.err = err
+		}
+		return tr
+	}
+
+	tr.filters = append(tr.filters, e)
+
+	tr.currentBlockIdx = 0
+	tr.currentStorage = nil
+	tr.localCursor = 0
+	tr.localMask = nil
+
+	return tr
+}
+
+// Select restricts the columns returned by Next and NextBatch to cols. Parquet blocks
+// then only decode the selected columns and the columns used by filters.
+func (tr *TableReader) Select(cols ...string) *TableReader {
+	tr.projection = make([]int, 0, len(cols))
+	for _, name := range cols {
+		found := false
+		for i, col := range tr.schema.Columns {
+			if col.Name == name {
+				tr.projection = append(tr.projection, i)
+				found = true
+				break
+			}
+		}
+		if !found && tr.err == nil {
+			tr.err = fmt.Errorf("column %s not found", name)
+		}
+	}
 
 	tr.currentBlockIdx = 0
 	tr.currentStorage = nil
@@ -176,83 +444,346 @@ func (tr *TableReader) Filter(colName string, op string, value any) *TableReader
 	return tr
 }
 
+// neededColumns returns the logical columns a block has to decode, nil for all of them.
+func (tr *TableReader) neededColumns() []bool {
+	if tr.projection == nil {
+		return nil
+	}
+
+	want := make([]bool, len(tr.schema.Columns))
+	for _, i := range tr.projection {
+		want[i] = true
+	}
+	walkPredicates(andExpr(tr.filters), func(p Predicate) {
+		for i, col := range tr.schema.Columns {
+			if col.Name == p.ColName {
+				want[i] = true
+			}
+		}
+	})
+	return want
+}
+
 func (tr *TableReader) LoadNextBlock() error {
 
 	if tr.currentBlockIdx >= len(tr.blocks) {
-		return fmt.Errorf("no more blocks to load")
+		return errNoMoreBlocks
 	}
 
 	for tr.currentBlockIdx < len(tr.blocks) {
-		block := tr.blocks[tr.currentBlockIdx]
-		
-		if block.Storage != nil {
-			break
-		}
-
-		skipBlock := false
-		for _, pred := range tr.predicates {
-			skip, err := tr.CanSkip(block, pred)
-			if err != nil {
-				return err
-			}
-			if skip {
-				skipBlock = true
-				break
-			}
+		skip, err := tr.canSkipBlock(tr.blocks[tr.currentBlockIdx])
+		if err != nil {
+			return err
 		}
-
-		if !skipBlock {
+		if !skip {
 			break
 		}
 
 		tr.currentBlockIdx++
 	}
 
+	// every remaining block was pruned
+	if tr.currentBlockIdx >= len(tr.blocks) {
+		return errNoMoreBlocks
+	}
+
 	block := tr.blocks[tr.currentBlockIdx]
+	rows := block.RowCount
 
-	if !block.isOnDisk && block.Storage != nil {
+	if block.parts != nil {
+		storage, n, err := tr.loadGroup(block.parts)
+		if err != nil {
+			return fmt.Errorf("failed to load block %d: %v", tr.currentBlockIdx, err)
+		}
+		tr.currentStorage, rows = storage, n
+	} else if !block.isOnDisk && block.Storage != nil {
 		tr.currentStorage = block.Storage
 	} else {
-		colTypes := make([]schema.ColumnType, len(tr.table.schema.Columns))
-
-		for i, col := range tr.table.schema.Columns {
-			colTypes[i] = col.Type
-		}
-
-		storage, _, err := NewColumnStorage(colTypes)
+		storage, _, err := NewColumnStorage(columnTypes(tr.schema))
 		if err != nil {
 			return err
 		}
 
-		if err := block.LoadInto(storage, tr.table.schema, tr.table.locations); err != nil {
-			return err
+		// only the pages the filters may match are decoded
+		n, err := tr.loadBlock(block, storage)
+		if err != nil {
+			return fmt.Errorf("failed to load block %d: %v", tr.currentBlockIdx, err)
 		}
 
-		tr.currentStorage = storage
+		tr.currentStorage, rows = storage, n
 	}
 
-	tr.localMask = make([]bool, block.RowCount)
+	tr.localMask = make([]bool, rows)
 	for i := range tr.localMask {
 		tr.localMask[i] = true
 	}
 
-	for _, pred := range tr.predicates {
-		tr.applyPredicates(pred)
+	return tr.applyExpr(andExpr(tr.filters), tr.localMask)
+
+}
+
+// canSkipBlock reports whether the predicates rule out every row of block. The active
+// block has no stats yet and is never skipped, a group only when all its parts are.
+// Blocks of a partition outside the time predicates are skipped without their stats.
+func (tr *TableReader) canSkipBlock(block *Block) (bool, error) {
+	if block.Storage != nil {
+		return false, nil
 	}
 
-	return nil
+	if block.parts != nil {
+		for _, part := range block.parts {
+			skip, err := tr.canSkipBlock(part)
+			if err != nil || !skip {
+				return false, err
+			}
+		}
+		return true, nil
+	}
 
+	if block.Partition != "" && tr.skipPartition(block.Partition) {
+		return true, nil
+	}
+
+	return prune(andExpr(tr.filters), func(p Predicate) (bool, error) {
+		return tr.CanSkip(block, p)
+	})
+}
+
+// OrderByTime makes Next and NextBatch return rows in time order, which only takes extra
+// work for tables that allow rows out of order. Blocks whose time ranges overlap are
+// read together and sorted, so a scan costs more the more late rows compaction has not
+// merged yet. It has to be called before the scan starts.
+func (tr *TableReader) OrderByTime() *TableReader {
+	if tr.err != nil {
+		return tr
+	}
+	if tr.currentStorage != nil || tr.currentBlockIdx > 0 {
+		tr.err = fmt.Errorf("OrderByTime must be called before the scan starts")
+		return tr
+	}
+	if tr.ordered {
+		return tr
+	}
+	tr.ordered = true
+
+	blocks := make([]*Block, 0, len(tr.blocks))
+	for _, block := range tr.blocks {
+		if block.RowCount > 0 {
+			blocks = append(blocks, block)
+		}
+	}
+	slices.SortStableFunc(blocks, func(a, b *Block) int {
+		if c := cmp.Compare(a.MinTs, b.MinTs); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.MaxTs, b.MaxTs)
+	})
+
+	// a block starting before the newest row of the group overlaps it, blocks that only
+	// touch at a shared timestamp are already in order
+	var grouped, group []*Block
+	var groupMax int64
+	emit := func() {
+		if len(group) == 1 && !group[0].unsorted {
+			grouped = append(grouped, group[0])
+		} else if len(group) > 0 {
+			g := &Block{parts: group, MinTs: group[0].MinTs, MaxTs: groupMax}
+			for _, part := range group {
+				g.RowCount += part.RowCount
+			}
+			grouped = append(grouped, g)
+		}
+		group = nil
+	}
+
+	for _, block := range blocks {
+		if len(group) > 0 && block.MinTs >= groupMax {
+			emit()
+		}
+		if len(group) == 0 || block.MaxTs > groupMax {
+			groupMax = block.MaxTs
+		}
+		group = append(group, block)
+	}
+	emit()
+
+	tr.blocks = grouped
+	return tr
+}
+
+// loadGroup reads the parts of a group the predicates do not rule out into one storage
+// sorted by time and returns it with its row count.
+func (tr *TableReader) loadGroup(parts []*Block) (*ColumnStorage, int, error) {
+	storage, _, err := NewColumnStorage(columnTypes(tr.schema))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	timeIdx := -1
+	for i, col := range tr.schema.Columns {
+		if col.Name == tr.schema.TimeColumn {
+			timeIdx = i
+		}
+	}
+
+	want := tr.neededColumns()
+	if want != nil {
+		want[timeIdx] = true
+	}
+
+	for _, part := range parts {
+		skip, err := tr.canSkipBlock(part)
+		if err != nil {
+			return nil, 0, err
+		}
+		if skip {
+			continue
+		}
+
+		if part.Storage != nil {
+			storage.appendRows(part.Storage, part.RowCount, want)
+		} else if err := part.LoadColumnsInto(storage, tr.schema, tr.locations, want); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	rows := storage.columnLen(timeIdx)
+	return storage.permute(storage.timeOrder(timeIdx)), rows, nil
+}
+
+// checkPredicate validates p against the reader schema. Untyped Go ints are converted to
+// the type of the column so a literal like 100 works for both int64 and float64 columns.
+func (tr *TableReader) checkPredicate(p Predicate) (Predicate, error) {
+	col, ok := tr.schema.Column(p.ColName)
+	if !ok {
+		return p, fmt.Errorf("column %s not found", p.ColName)
+	}
+
+	switch p.Op {
+	case OpIsNull, OpIsNotNull:
+		return p, nil
+	case OpIn, OpNotIn:
+		set, err := checkSet(col, p.Value)
+		if err != nil {
+			return p, err
+		}
+		p.Value = set
+		return p, nil
+	case OpPrefix, OpNotPrefix, OpLike, OpNotLike, OpRegex, OpNotRegex:
+		pattern, err := checkPattern(col, p)
+		if err != nil {
+			return p, err
+		}
+		p.Value = pattern
+		return p, nil
+	case "==", "!=":
+	case ">", ">=", "<", "<=":
+		if col.Type == schema.String || col.Type == schema.Boolean {
+			return p, fmt.Errorf("operator %s is not supported for %s column %s", p.Op, col.Type, p.ColName)
+		}
+	default:
+		return p, fmt.Errorf("unknown operator %q", p.Op)
+	}
+
+	v, err := convertValue(col, p.Value)
+	if err != nil {
+		return p, err
+	}
+	p.Value = v
+	return p, nil
+}
+
+// convertValue checks that v can be compared with col, converting Go ints.
+func convertValue(col schema.Column, v any) (any, error) {
+	switch col.Type {
+	case schema.Int64:
+		if i, ok := v.(int); ok {
+			v = int64(i)
+		}
+		if _, ok := v.(int64); !ok {
+			return v, fmt.Errorf("invalid value type for int64 column %s: %T", col.Name, v)
+		}
+	case schema.Float64:
+		switch i := v.(type) {
+		case int:
+			v = float64(i)
+		case int64:
+			v = float64(i)
+		}
+		if _, ok := v.(float64); !ok {
+			return v, fmt.Errorf("invalid value type for float64 column %s: %T", col.Name, v)
+		}
+	case schema.String:
+		if _, ok := v.(string); !ok {
+			return v, fmt.Errorf("invalid value type for string column %s: %T", col.Name, v)
+		}
+	case schema.Boolean:
+		if _, ok := v.(bool); !ok {
+			return v, fmt.Errorf("invalid value type for bool column %s: %T", col.Name, v)
+		}
+	}
+	return v, nil
 }
 
+// CanSkip reports whether the block stats prove no row of the block matches predicate.
+// Stats are laid out by the schema version the block was written with, so the column
+// is resolved in that version rather than in the reader schema. Bloom filters are not
+// among the stats, they live in the block file and pageRows checks them per row group.
 func (tr *TableReader) CanSkip(block *Block, predicate Predicate) (bool, error) {
-	loc, found := tr.table.getColumnLocation(predicate.ColName)
+	col, found := tr.schema.Column(predicate.ColName)
 	if !found {
 		return false, fmt.Errorf("column %s not found", predicate.ColName)
 	}
 
+	if predicate.Op == OpIn || predicate.Op == OpNotIn {
+		return tr.skipSet(block, col, predicate)
+	}
+
+	blockSchema, blockLocations, ok := tr.layout(block.SchemaVersion)
+	if !ok {
+		return false, fmt.Errorf("block uses unknown schema version %d", block.SchemaVersion)
+	}
+
+	blockIdx := -1
+	for i, c := range blockSchema.Columns {
+		if c.Name == predicate.ColName {
+			blockIdx = i
+			break
+		}
+	}
+
+	// the column was added after this block was written, every row holds the default
+	// which is null for nullable columns added without one
+	if blockIdx == -1 {
+		match, err := tr.evalValue(col.Type, col.DefaultValue(), predicate)
+		if err != nil {
+			return false, err
+		}
+		return !match, nil
+	}
+
+	loc := blockLocations[blockIdx]
+
+	nullCount := 0
+	if blockIdx < len(block.NullCounts) {
+		nullCount = block.NullCounts[blockIdx]
+	}
+
+	switch predicate.Op {
+	case OpIsNull:
+		return nullCount == 0, nil
+	case OpIsNotNull:
+		return nullCount == block.RowCount, nil
+	}
+
+	// min/max of an all-null column mean nothing, and no comparison matches a null
+	if nullCount == block.RowCount {
+		return true, nil
+	}
+
 	var min, max, target float64
-	
-	switch loc.Type {
+
+	switch col.Type {
 	case schema.Int64:
 		target = float64(0)
 		if v, ok := predicate.Value.(int64); ok {
@@ -276,8 +807,33 @@ func (tr *TableReader) CanSkip(block *Block, predicate Predicate) (bool, error)
 			return false, fmt.Errorf("invalid value type for float64 column: %T", predicate.Value)
 		}
 
-		min = block.FloatMin[loc.Index]
-		max = block.FloatMax[loc.Index]
+		// widened columns still carry int64 stats in blocks written before the widening
+		if loc.Type == schema.Int64 {
+			min = float64(block.IntMin[loc.Index])
+			max = float64(block.IntMax[loc.Index])
+		} else {
+			min = block.FloatMin[loc.Index]
+			max = block.FloatMax[loc.Index]
+		}
+
+	case schema.Boolean:
+		target, ok := predicate.Value.(bool)
+		if !ok {
+			return false, fmt.Errorf("invalid value type for bool column: %T", predicate.Value)
+		}
+
+		min, max := block.BoolMin[loc.Index], block.BoolMax[loc.Index]
+
+		switch predicate.Op {
+		case "==":
+			return min != target && max != target, nil
+		case "!=":
+			return min == target && max == target, nil
+		default:
+			return false, nil
+		}
+	case schema.String:
+		return tr.skipString(block, col, loc.Index, predicate)
 	default:
 		return false, nil
 	}
@@ -301,13 +857,57 @@ func (tr *TableReader) CanSkip(block *Block, predicate Predicate) (bool, error)
 
 }
 
-func (tr *TableReader) applyPredicates(p Predicate) error {
+// evalValue evaluates predicate against a single value of a column of type colType.
+func (tr *TableReader) evalValue(colType schema.ColumnType, val any, p Predicate) (bool, error) {
+	switch p.Op {
+	case OpIsNull:
+		return val == nil, nil
+	case OpIsNotNull:
+		return val != nil, nil
+	}
+
+	if val == nil {
+		return false, nil
+	}
+
+	if p.Op == OpIn || p.Op == OpNotIn {
+		return inSet(p.Value, val) == (p.Op == OpIn), nil
+	}
+
+	switch colType {
+	case schema.Int64:
+		if target, ok := p.Value.(int64); ok {
+			return tr.evalInt64(val.(int64), p.Op, target), nil
+		} else if target, ok := p.Value.(int); ok {
+			return tr.evalInt64(val.(int64), p.Op, int64(target)), nil
+		}
+		return false, fmt.Errorf("invalid value type for int64 column %s: %T", p.ColName, p.Value)
+	case schema.Float64:
+		if target, ok := p.Value.(float64); ok {
+			return tr.evalFloat64(val.(float64), p.Op, target), nil
+		}
+		return false, fmt.Errorf("invalid value type for float64 column %s: %T", p.ColName, p.Value)
+	case schema.String:
+		return tr.matchString(p, val.(string)), nil
+	case schema.Boolean:
+		if target, ok := p.Value.(bool); ok {
+			return tr.evalBool(val.(bool), p.Op, target), nil
+		}
+		return false, fmt.Errorf("invalid value type for bool column %s: %T", p.ColName, p.Value)
+	}
+	return false, fmt.Errorf("unsupported column type: %v", colType)
+}
+
+// applyPredicate clears the rows of mask that p does not match.
+func (tr *TableReader) applyPredicate(p Predicate, mask []bool) error {
 	var loc ColumnLocation
 	var found bool
+	logicalIdx := -1
 
-	for i, col := range tr.table.schema.Columns {
+	for i, col := range tr.schema.Columns {
 		if col.Name == p.ColName {
-			loc = tr.table.locations[i]
+			loc = tr.locations[i]
+			logicalIdx = i
 			found = true
 			break
 		}
@@ -317,17 +917,52 @@ func (tr *TableReader) applyPredicates(p Predicate) error {
 		return fmt.Errorf("column %s not found", p.ColName)
 	}
 
-	count := len(tr.localMask)
+	count := len(mask)
+
+	if p.Op == OpIsNull || p.Op == OpIsNotNull {
+		for i := 0; i < count; i++ {
+			if mask[i] && tr.currentStorage.IsNull(logicalIdx, i) != (p.Op == OpIsNull) {
+				mask[i] = false
+			}
+		}
+		return nil
+	}
+
+	if loc.Type == schema.String {
+		tr.applyStringPredicate(p, loc, logicalIdx, mask)
+		return nil
+	}
 
 	for i := 0; i < count; i++ {
-		if !tr.localMask[i] {
+		if !mask[i] {
+			continue
+		}
+
+		if tr.currentStorage.IsNull(logicalIdx, i) {
+			mask[i] = false
 			continue
 		}
 
 		match := false
 
-		switch loc.Type {
-		case schema.Int64:
+		if p.Op == OpIn || p.Op == OpNotIn {
+			var val any
+			switch loc.Type {
+			case schema.Int64:
+				val = tr.currentStorage.Int64Cols[loc.Index][i]
+			case schema.Float64:
+				val = tr.currentStorage.Float64Cols[loc.Index][i]
+			case schema.Boolean:
+				val = tr.currentStorage.BoolCols[loc.Index].Get(i)
+			}
+			if inSet(p.Value, val) != (p.Op == OpIn) {
+				mask[i] = false
+			}
+			continue
+		}
+
+		switch loc.Type {
+		case schema.Int64:
 			val := tr.currentStorage.Int64Cols[loc.Index][i]
 
 			if target, ok := p.Value.(int64); ok {
@@ -344,36 +979,34 @@ func (tr *TableReader) applyPredicates(p Predicate) error {
 			} else {
 				return fmt.Errorf("invalid value type for float64 column %s: %T", p.ColName, p.Value)
 			}
-		case schema.String:
-			strID := tr.currentStorage.StringCols[loc.Index][i]
-			val := tr.currentStorage.StringReads[loc.Index][strID]
-			if target, ok := p.Value.(string); ok {
-				match = tr.evalString(val, p.Op, target)
+		case schema.Boolean:
+			val := tr.currentStorage.BoolCols[loc.Index].Get(i)
+			if target, ok := p.Value.(bool); ok {
+				match = tr.evalBool(val, p.Op, target)
 			} else {
-				return fmt.Errorf("invalid value type for string column %s: %T", p.ColName, p.Value)
+				return fmt.Errorf("invalid value type for bool column %s: %T", p.ColName, p.Value)
 			}
 		}
 
 		if !match {
-			tr.localMask[i] = false
+			mask[i] = false
 		}
 	}
 
 	return nil
 }
 
-func CreateTable(s schema.Schema, w *wal.WAL, dbName string) (*Table, error) {
+// CreateTable builds an empty table whose blocks and WAL live under dir. history holds
+// the earlier versions of s for tables that have been altered.
+func CreateTable(s schema.Schema, w *wal.WAL, dir string, history ...schema.Schema) (*Table, error) {
 
 	if err := s.Validate(); err != nil {
 		return nil, err
 	}
 
-	colTypes := make([]schema.ColumnType, len(s.Columns))
 	timeIdx := -1
 
 	for i, col := range s.Columns {
-		colTypes[i] = col.Type
-
 		if col.Name == s.TimeColumn {
 			timeIdx = i
 		}
@@ -383,10 +1016,11 @@ func CreateTable(s schema.Schema, w *wal.WAL, dbName string) (*Table, error) {
 		return nil, fmt.Errorf("time column %s not found", s.TimeColumn)
 	}
 
-	block, locations, err := NewBlock(colTypes)
+	block, locations, err := NewBlock(columnTypes(s))
 	if err != nil {
 		return nil, err
 	}
+	block.SchemaVersion = s.Version
 
 	t := &Table{
 		schema:       s,
@@ -398,163 +1032,848 @@ func CreateTable(s schema.Schema, w *wal.WAL, dbName string) (*Table, error) {
 		rowCount:     0,
 		wal:          w,
 		MaxBlockSize: 10_000_000,
-		dbName:       dbName,
+		dir:          dir,
+		versions:     map[int]schema.Schema{s.Version: s},
+		layouts:      map[int][]ColumnLocation{s.Version: locations},
+		partitions:   make(map[string]Partition),
+		readers:      make(map[uint64]int),
+	}
+
+	for _, old := range history {
+		if old.Version >= s.Version {
+			return nil, fmt.Errorf("schema history version %d is not older than current version %d", old.Version, s.Version)
+		}
+
+		_, oldLocations, err := NewColumnStorage(columnTypes(old))
+		if err != nil {
+			return nil, err
+		}
+		t.versions[old.Version] = old
+		t.layouts[old.Version] = oldLocations
 	}
 
 	return t, nil
 }
 
-func (t *Table) AppendHelper(row map[string]any) error {
+func columnTypes(s schema.Schema) []schema.ColumnType {
+	colTypes := make([]schema.ColumnType, len(s.Columns))
+	for i, col := range s.Columns {
+		colTypes[i] = col.Type
+	}
+	return colTypes
+}
+
+// layout returns a schema version of the table and its column locations.
+func (t *Table) layout(version int) (schema.Schema, []ColumnLocation, bool) {
+	s, ok := t.versions[version]
+	if !ok {
+		return schema.Schema{}, nil, false
+	}
+	return s, t.layouts[version], true
+}
+
+// History returns the schema versions older than the current one, oldest first.
+func (t *Table) History() []schema.Schema {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return t.history()
+}
+
+func (t *Table) history() []schema.Schema {
+	history := make([]schema.Schema, 0, len(t.versions)-1)
+	for version, s := range t.versions {
+		if version != t.schema.Version {
+			history = append(history, s)
+		}
+	}
+	sort.Slice(history, func(i, j int) bool {
+		return history[i].Version < history[j].Version
+	})
+	return history
+}
+
+// PlanAlter applies changes to the current schema and checks the result against the
+// table history without touching the table. The result is handed to Alter.
+func (t *Table) PlanAlter(changes ...schema.Change) (schema.Schema, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	next, err := t.schema.Apply(changes...)
+	if err != nil {
+		return schema.Schema{}, err
+	}
 
-	if len(row) != len(t.schema.Columns) {
-		return fmt.Errorf("row must have %d columns, got %d", len(t.schema.Columns), len(row))
+	// old blocks are matched to the current schema by column name, so a name that
+	// was dropped earlier would resurrect the old data if it were added again
+	for _, col := range next.Columns {
+		if _, ok := t.schema.Column(col.Name); ok {
+			continue
+		}
+		for _, old := range t.versions {
+			if _, ok := old.Column(col.Name); ok {
+				return schema.Schema{}, fmt.Errorf("column %s was dropped in an earlier schema version and cannot be added again", col.Name)
+			}
+		}
+	}
+
+	return next, nil
+}
+
+// Alter switches the table to next, a schema produced by PlanAlter. The active block
+// still holds rows of the old layout, so it is flushed under the old version first.
+func (t *Table) Alter(next schema.Schema) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if next.Version != t.schema.Version+1 {
+		return fmt.Errorf("schema version %d does not follow current version %d", next.Version, t.schema.Version)
+	}
+
+	if err := next.Validate(); err != nil {
+		return err
+	}
+
+	if t.activeBlock.RowCount > 0 {
+		if err := t.rotateActiveBlock(); err != nil {
+			return err
+		}
+	}
+
+	block, locations, err := NewBlock(columnTypes(next))
+	if err != nil {
+		return err
+	}
+	block.SchemaVersion = next.Version
+
+	for i, col := range next.Columns {
+		if col.Name == next.TimeColumn {
+			t.timeColIdx = i
+		}
+	}
+
+	t.schema = next
+	t.locations = locations
+	t.activeBlock = block
+	t.versions[next.Version] = next
+	t.layouts[next.Version] = locations
+
+	if t.wal != nil {
+		t.wal.SetSchema(next)
+	}
+
+	return nil
+}
+
+// validateRow checks row against the current schema without modifying the table and
+// returns its timestamp, which may not be older than lastTs. Nullable columns may be
+// missing or nil.
+func (t *Table) validateRow(row map[string]any, lastTs int64) (int64, error) {
+
+	for name := range row {
+		if _, ok := t.schema.Column(name); !ok {
+			return 0, fmt.Errorf("row has unknown column %s", name)
+		}
 	}
 
 	timeColName := t.schema.Columns[t.timeColIdx].Name
 
 	rowTs, ok := row[timeColName]
 	if !ok {
-		return fmt.Errorf("row must have time column %s", timeColName)
+		return 0, fmt.Errorf("row must have time column %s", timeColName)
 	}
 
 	ts, ok := rowTs.(int64)
 	if !ok {
-		return fmt.Errorf("time column %s must be of type int64", timeColName)
+		return 0, fmt.Errorf("time column %s must be of type int64", timeColName)
 	}
 
-	if ts < int64(t.lastTs) {
-		return fmt.Errorf("time column %s must be in non-decreasing order", timeColName)
+	if ts < lastTs {
+		return 0, fmt.Errorf("time column %s must be in non-decreasing order", timeColName)
 	}
 
-	for logicalIdx, col := range t.schema.Columns {
+	for _, col := range t.schema.Columns {
 
 		val, ok := row[col.Name]
-		if !ok {
-			return fmt.Errorf("row must have column %s", col.Name)
+		if !ok || val == nil {
+			if col.Nullable {
+				continue
+			}
+			return 0, fmt.Errorf("row must have column %s", col.Name)
 		}
 
-		loc := t.locations[logicalIdx]
+		switch col.Type {
+		case schema.Int64:
+			if _, ok := val.(int64); !ok {
+				return 0, fmt.Errorf("column %s must be of type int64", col.Name)
+			}
+		case schema.Float64:
+			if _, ok := val.(float64); !ok {
+				return 0, fmt.Errorf("column %s must be of type float64", col.Name)
+			}
+		case schema.String:
+			if _, ok := val.(string); !ok {
+				return 0, fmt.Errorf("column %s must be of type string", col.Name)
+			}
+		case schema.Boolean:
+			if _, ok := val.(bool); !ok {
+				return 0, fmt.Errorf("column %s must be of type bool", col.Name)
+			}
+		default:
+			return 0, fmt.Errorf("unsupported column type: %v", col.Type)
+		}
+	}
 
-		switch loc.Type {
+	return ts, nil
+}
+
+// validateBatch checks every row of a batch. Unless inOrder is false, rows have to be in
+// time order among themselves as well as after the rows already in the table.
+func (t *Table) validateBatch(rows []map[string]any, inOrder bool) error {
+	lastTs := t.oldestAllowed(inOrder)
+	for i, row := range rows {
+		ts, err := t.validateRow(row, lastTs)
+		if err != nil {
+			return fmt.Errorf("row %d: %v", i, err)
+		}
+		if inOrder {
+			lastTs = ts
+		}
+	}
+	return nil
+}
+
+// oldestAllowed is the oldest timestamp an append may carry, callers must hold t.mu.
+func (t *Table) oldestAllowed(inOrder bool) int64 {
+	if !inOrder {
+		return math.MinInt64
+	}
+	return int64(t.lastTs)
+}
+
+// validateColumns checks a column-wise batch and returns how many rows it holds.
+// Nullable columns may be missing, which makes every row of the batch null.
+func (t *Table) validateColumns(cols map[string]any) (int, error) {
+
+	for name := range cols {
+		if _, ok := t.schema.Column(name); !ok {
+			return 0, fmt.Errorf("batch has unknown column %s", name)
+		}
+	}
+
+	n := -1
+	for _, col := range t.schema.Columns {
+		vals, ok := cols[col.Name]
+		if !ok || vals == nil {
+			if col.Nullable {
+				continue
+			}
+			return 0, fmt.Errorf("batch must have column %s", col.Name)
+		}
+
+		var l int
+		switch col.Type {
 		case schema.Int64:
-			v, ok := val.(int64)
+			v, ok := vals.([]int64)
 			if !ok {
-				return fmt.Errorf("column %s must be of type int64", col.Name)
+				return 0, fmt.Errorf("column %s must be of type []int64", col.Name)
 			}
-			t.activeBlock.Storage.Int64Cols[loc.Index] = append(t.activeBlock.Storage.Int64Cols[loc.Index], v)
-
+			l = len(v)
 		case schema.Float64:
-			v, ok := val.(float64)
+			v, ok := vals.([]float64)
 			if !ok {
-				return fmt.Errorf("column %s must be of type float64", col.Name)
+				return 0, fmt.Errorf("column %s must be of type []float64", col.Name)
 			}
-			t.activeBlock.Storage.Float64Cols[loc.Index] = append(t.activeBlock.Storage.Float64Cols[loc.Index], v)
-
+			l = len(v)
 		case schema.String:
-			v, ok := val.(string)
+			v, ok := vals.([]string)
 			if !ok {
-				return fmt.Errorf("column %s must be of type string", col.Name)
+				return 0, fmt.Errorf("column %s must be of type []string", col.Name)
 			}
-
-			dict := t.activeBlock.Storage.StringDicts[loc.Index]
-			id, exists := dict[v]
-			if !exists {
-				id = len(dict)
-				dict[v] = id
-				t.activeBlock.Storage.StringReads[loc.Index] = append(t.activeBlock.Storage.StringReads[loc.Index], v)
+			l = len(v)
+		case schema.Boolean:
+			v, ok := vals.([]bool)
+			if !ok {
+				return 0, fmt.Errorf("column %s must be of type []bool", col.Name)
 			}
-			t.activeBlock.Storage.StringCols[loc.Index] = append(t.activeBlock.Storage.StringCols[loc.Index], id)
-
+			l = len(v)
 		default:
-			return fmt.Errorf("unsupported column type: %v", loc.Type)
+			return 0, fmt.Errorf("unsupported column type: %v", col.Type)
+		}
+
+		if n != -1 && l != n {
+			return 0, fmt.Errorf("column %s has %d values, expected %d", col.Name, l, n)
+		}
+		n = l
+	}
+
+	// the time column is never nullable, so it was checked above
+	timeColName := t.schema.Columns[t.timeColIdx].Name
+	lastTs := t.oldestAllowed(!t.AllowOutOfOrder)
+	for i, ts := range cols[timeColName].([]int64) {
+		if ts < lastTs {
+			return 0, fmt.Errorf("row %d: time column %s must be in non-decreasing order", i, timeColName)
 		}
+		if !t.AllowOutOfOrder {
+			lastTs = ts
+		}
+	}
+
+	return n, nil
+}
+
+func (t *Table) AppendHelper(row map[string]any) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err := t.writable(); err != nil {
+		return err
+	}
+	ts, err := t.validateRow(row, t.oldestAllowed(!t.AllowOutOfOrder))
+	if err != nil {
+		return err
+	}
+
+	t.applyRow(row, ts)
+	return t.rotateIfFull()
+}
+
+// applyRow adds a validated row to the active block, callers must hold t.mu. The row
+// is fully validated before the first column is touched so a bad row can never leave
+// the columns of the active block with different lengths.
+func (t *Table) applyRow(row map[string]any, ts int64) {
+	for logicalIdx, col := range t.schema.Columns {
+		t.activeBlock.Storage.AppendValue(logicalIdx, row[col.Name])
 	}
 
+	t.trackTimes(ts, ts, false)
 	t.activeBlock.RowCount++
 	t.rowCount++
-	t.lastTs = int(ts)
-	t.activeBlock.MaxTs = ts
+}
+
+// trackTimes updates the time range of the active block and the table for rows from
+// minTs to maxTs being appended, unsorted tells that they are not in order among
+// themselves. Callers must hold t.mu and call it before bumping the row count.
+func (t *Table) trackTimes(minTs, maxTs int64, unsorted bool) {
+	b := t.activeBlock
+	if b.RowCount == 0 {
+		b.MinTs, b.MaxTs = minTs, maxTs
+	} else {
+		if minTs < b.MaxTs {
+			b.unsorted = true
+		}
+		b.MinTs = min(b.MinTs, minTs)
+		b.MaxTs = max(b.MaxTs, maxTs)
+	}
+	if unsorted {
+		b.unsorted = true
+	}
 
+	if int(maxTs) > t.lastTs {
+		t.lastTs = int(maxTs)
+	}
+}
+
+// rotateIfFull flushes the active block once it holds MaxBlockSize rows. Batches are
+// never split across blocks, so a block may end up larger than MaxBlockSize.
+func (t *Table) rotateIfFull() error {
 	if t.activeBlock.RowCount >= t.MaxBlockSize {
-		path := ""
+		return t.rotateActiveBlock()
+	}
+	return nil
+}
 
-		if t.UseDiskStorage {
-			path = filepath.Join("_data_internal", t.dbName, t.schema.Name, fmt.Sprintf("Ts%dR%di%d.parquet", t.activeBlock.MaxTs, t.activeBlock.RowCount, len(t.coldBlocks)))
-		}
+// rotateActiveBlock freezes the active block into a parquet block and starts a new one.
+func (t *Table) rotateActiveBlock() error {
+	blocks := t.splitByPartition(t.splitActive())
+	metas := make([]blockMeta, len(blocks))
 
-		if err := t.activeBlock.Rotate(t.UseDiskStorage, path, t.schema, t.locations); err != nil {
+	for i, block := range blocks {
+		if err := block.Rotate(t.UseDiskStorage, t.blockPath(block), t.schema, t.locations); err != nil {
 			return fmt.Errorf("failed to flush block: %v", err)
 		}
+		metas[i] = newBlockMeta(block)
+	}
 
-		if t.wal != nil && t.UseDiskStorage {
-			if err := t.wal.Reset(); err != nil {
-				return fmt.Errorf("failed to reset WAL after rotation: %v", err)
-			}
+	if t.UseDiskStorage {
+		if err := appendManifest(t.dir, manifestEntry{Add: metas}); err != nil {
+			return fmt.Errorf("failed to record block in manifest: %v", err)
 		}
+	}
 
-		t.coldBlocks = append(t.coldBlocks, t.activeBlock)
-		colTypes := make([]schema.ColumnType, len(t.schema.Columns))
-		for i, col := range t.schema.Columns {
-			colTypes[i] = col.Type
+	// a crash before the checkpoint leaves records in the WAL that the block holds as
+	// well, replay skips them because they are not above the LSN stored in the block
+	if t.wal != nil && t.UseDiskStorage {
+		if err := t.wal.Checkpoint(t.activeBlock.MaxLSN); err != nil {
+			return fmt.Errorf("failed to checkpoint WAL after rotation: %v", err)
 		}
-		nextBlock, _, err := NewBlock(colTypes)
-		if err != nil {
-			return fmt.Errorf("failed to create new block: %v", err)
+	}
+
+	for _, block := range blocks {
+		t.addColdBlock(block)
+	}
+	t.notePartitions()
+
+	nextBlock, _, err := NewBlock(columnTypes(t.schema))
+	if err != nil {
+		return fmt.Errorf("failed to create new block: %v", err)
+	}
+	nextBlock.SchemaVersion = t.schema.Version
+	t.activeBlock = nextBlock
+
+	return nil
+}
+
+// splitActive sorts the active block by time if its rows came in out of order. Rows older
+// than every flushed block are split off into a block of their own, so the active block
+// keeps a tight time range and only the late rows overlap older blocks. It returns the
+// blocks to write, the late rows first. Callers must hold t.mu.
+func (t *Table) splitActive() []*Block {
+	b := t.activeBlock
+	if !b.unsorted {
+		return []*Block{b}
+	}
+
+	order := b.Storage.timeOrder(t.timeColIdx)
+	ts := b.Storage.Int64Cols[t.locations[t.timeColIdx].Index]
+
+	late := 0
+	if len(t.coldBlocks) > 0 {
+		flushed := t.coldBlocks[len(t.coldBlocks)-1].MaxTs
+		late = sort.Search(len(order), func(i int) bool { return ts[order[i]] >= flushed })
+	}
+
+	b.unsorted = false
+
+	if late == 0 || late == len(order) {
+		b.Storage = b.Storage.permute(order)
+		return []*Block{b}
+	}
+
+	lateBlock := &Block{
+		Storage:       b.Storage.permute(order[:late]),
+		RowCount:      late,
+		MinTs:         ts[order[0]],
+		MaxTs:         ts[order[late-1]],
+		SchemaVersion: b.SchemaVersion,
+		MaxLSN:        b.MaxLSN,
+	}
+	lateBlock.allocStats(columnTypes(t.schema))
+
+	b.Storage = b.Storage.permute(order[late:])
+	b.RowCount -= late
+	b.MinTs = ts[order[late]]
+
+	return []*Block{lateBlock, b}
+}
+
+// addColdBlock inserts b among the cold blocks, which stay sorted by MaxTs and then
+// MinTs like LoadFromDisk sorts them. Callers must hold t.mu.
+func (t *Table) addColdBlock(b *Block) {
+	i := sort.Search(len(t.coldBlocks), func(i int) bool {
+		c := t.coldBlocks[i]
+		return c.MaxTs > b.MaxTs || (c.MaxTs == b.MaxTs && c.MinTs > b.MinTs)
+	})
+	t.coldBlocks = slices.Insert(t.coldBlocks, i, b)
+}
+
+// blockPath gives b the next block ID and returns the file it is written to, in the
+// directory of its partition. The name is only there for people browsing the
+// directory, the manifest is what LoadFromDisk reads.
+func (t *Table) blockPath(b *Block) string {
+	b.ID = t.nextBlockID
+	t.nextBlockID++
+	return filepath.Join(t.dir, b.Partition, fmt.Sprintf("Ts%dR%di%d.parquet", b.MaxTs, b.RowCount, b.ID))
+}
+
+// writable fails once the table is detached or closed, callers must hold t.mu
+func (t *Table) writable() error {
+	if t.detached {
+		return fmt.Errorf("table %s has been detached", t.schema.Name)
+	}
+	if t.closed {
+		return fmt.Errorf("table %s has been closed", t.schema.Name)
+	}
+	return nil
+}
+
+// openWAL creates the WAL of a disk backed table on its first append, callers must
+// hold t.mu
+func (t *Table) openWAL() error {
+	if !t.UseDiskStorage || t.wal != nil {
+		return nil
+	}
+
+	w, err := wal.NewWAL(filepath.Join(t.dir, "wal"), t.schema, t.history()...)
+	if err != nil {
+		return fmt.Errorf("failed to create WAL: %v", err)
+	}
+	if err := w.SetSyncPolicy(t.SyncPolicy); err != nil {
+		w.Close()
+		return err
+	}
+	// blocks may outlive the log, its LSNs have to continue above theirs
+	if err := w.Checkpoint(t.durableLSN()); err != nil {
+		w.Close()
+		return err
+	}
+	t.wal = w
+	return nil
+}
+
+// DurableLSN returns the LSN up to which the rows of the WAL are stored in blocks on
+// disk, replay only needs the records above it.
+func (t *Table) DurableLSN() uint64 {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return t.durableLSN()
+}
+
+func (t *Table) durableLSN() uint64 {
+	var lsn uint64
+	for _, block := range t.coldBlocks {
+		if block.isOnDisk && block.MaxLSN > lsn {
+			lsn = block.MaxLSN
 		}
-		t.activeBlock = nextBlock
 	}
+	return lsn
+}
 
+// waitDurable finishes an append once the table lock is released, so appends from
+// other goroutines can join the same group commit. w is nil for tables without a WAL.
+func (t *Table) waitDurable(w *wal.WAL, seq uint64, err error) error {
+	if err != nil || w == nil {
+		return err
+	}
+	if err := w.WaitDurable(seq); err != nil {
+		return fmt.Errorf("failed to sync WAL: %v", err)
+	}
 	return nil
 }
+
 func (t *Table) AppendRow(row map[string]any) error {
+	return t.waitDurable(t.appendRow(row))
+}
+
+func (t *Table) appendRow(row map[string]any) (*wal.WAL, uint64, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err := t.writable(); err != nil {
+		return nil, 0, err
+	}
+	if err := t.openWAL(); err != nil {
+		return nil, 0, err
+	}
+
+	// rejected rows must never reach the WAL or they would fail again on every replay
+	ts, err := t.validateRow(row, t.oldestAllowed(!t.AllowOutOfOrder))
+	if err != nil {
+		return nil, 0, err
+	}
 
-	if t.UseDiskStorage && t.wal == nil {
-		tablePath := filepath.Join("_data_internal", t.dbName, t.schema.Name)
-		walPath := filepath.Join(tablePath, "wal")
+	var seq uint64
+	if t.wal != nil {
+		if seq, err = t.wal.WriteBatch([]map[string]any{row}); err != nil {
+			return nil, 0, fmt.Errorf("failed to append row to WAL: %v", err)
+		}
+		t.activeBlock.MaxLSN = seq
+	}
+
+	t.applyRow(row, ts)
+	return t.wal, seq, t.rotateIfFull()
+}
+
+// AppendBatch appends rows as one unit: the whole batch is validated before anything
+// is written, goes to the WAL as a single record and lands in the active block together,
+// so readers see all of it or none of it.
+func (t *Table) AppendBatch(rows []map[string]any) error {
+	return t.waitDurable(t.appendBatch(rows))
+}
+
+func (t *Table) appendBatch(rows []map[string]any) (*wal.WAL, uint64, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(rows) == 0 {
+		return nil, 0, nil
+	}
+
+	if err := t.writable(); err != nil {
+		return nil, 0, err
+	}
+	if err := t.openWAL(); err != nil {
+		return nil, 0, err
+	}
+
+	if err := t.validateBatch(rows, !t.AllowOutOfOrder); err != nil {
+		return nil, 0, err
+	}
+
+	var seq uint64
+	if t.wal != nil {
 		var err error
-		t.wal, err = wal.NewWAL(walPath, t.schema)
-		if err != nil {
-			return fmt.Errorf("failed to create WAL: %v", err)
+		if seq, err = t.wal.WriteBatch(rows); err != nil {
+			return nil, 0, fmt.Errorf("failed to append batch to WAL: %v", err)
 		}
+		t.activeBlock.MaxLSN = seq
+	}
+
+	for _, row := range rows {
+		t.applyRow(row, row[t.schema.TimeColumn].(int64))
+	}
+	return t.wal, seq, t.rotateIfFull()
+}
+
+// AppendColumns is AppendBatch for data that is already column-wise. cols maps column
+// names to []int64, []float64, []string or []bool slices of equal length. The slices
+// are copied into the active block without boxing every value.
+func (t *Table) AppendColumns(cols map[string]any) error {
+	return t.waitDurable(t.appendColumns(cols))
+}
+
+func (t *Table) appendColumns(cols map[string]any) (*wal.WAL, uint64, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err := t.writable(); err != nil {
+		return nil, 0, err
+	}
+	if err := t.openWAL(); err != nil {
+		return nil, 0, err
 	}
 
+	n, err := t.validateColumns(cols)
+	if err != nil {
+		return nil, 0, err
+	}
+	if n == 0 {
+		return nil, 0, nil
+	}
+
+	var seq uint64
 	if t.wal != nil {
-		if err := t.wal.AppendRow(row); err != nil {
-			return fmt.Errorf("failed to append row to WAL: %v", err)
+		if seq, err = t.wal.WriteColumns(cols, n); err != nil {
+			return nil, 0, fmt.Errorf("failed to append batch to WAL: %v", err)
 		}
+		t.activeBlock.MaxLSN = seq
+	}
+
+	for logicalIdx, col := range t.schema.Columns {
+		t.activeBlock.Storage.AppendColumn(logicalIdx, cols[col.Name], n)
 	}
 
-	return t.AppendHelper(row)
+	times := cols[t.schema.TimeColumn].([]int64)
+	minTs, maxTs, unsorted := times[0], times[0], false
+	for _, ts := range times[1:] {
+		if ts < maxTs {
+			unsorted = true
+		}
+		minTs = min(minTs, ts)
+		maxTs = max(maxTs, ts)
+	}
+
+	t.trackTimes(minTs, maxTs, unsorted)
+	t.activeBlock.RowCount += n
+	t.rowCount += n
+
+	return t.wal, seq, t.rotateIfFull()
 }
 
-func (t *Table) LoadRowNoWAL(row map[string]any) error {
-	return t.AppendHelper(row)
+// LoadBatchNoWAL applies the rows of the record with LSN lsn replayed from the WAL. The
+// rows may have been written under an older schema version, so they are upgraded to
+// the current schema first.
+func (t *Table) LoadBatchNoWAL(rows []map[string]any, lsn uint64) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	upgraded := make([]map[string]any, len(rows))
+	for i, row := range rows {
+		upgraded[i] = t.upgradeRow(row)
+	}
+
+	// the records were accepted once, the table may have allowed rows out of order then
+	if err := t.validateBatch(upgraded, false); err != nil {
+		return err
+	}
+
+	for _, row := range upgraded {
+		t.applyRow(row, row[t.schema.TimeColumn].(int64))
+	}
+	t.activeBlock.MaxLSN = lsn
+	return t.rotateIfFull()
+}
+
+// upgradeRow fills columns added since the row was written with their default, converts
+// widened columns and forgets columns that were dropped.
+func (t *Table) upgradeRow(row map[string]any) map[string]any {
+	out := make(map[string]any, len(t.schema.Columns))
+
+	for _, col := range t.schema.Columns {
+		val, ok := row[col.Name]
+		if !ok {
+			if def := col.DefaultValue(); def != nil {
+				out[col.Name] = def
+			}
+			continue
+		}
+
+		if v, isInt := val.(int64); isInt && col.Type == schema.Float64 {
+			val = float64(v)
+		}
+		out[col.Name] = val
+	}
+
+	return out
 }
 
 func (t *Table) RowCount() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
 	return t.rowCount
 }
 
+func (t *Table) Schema() schema.Schema {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return t.schema
+}
+
+// Close writes out the rows still in memory, checkpoints the WAL and closes it. The
+// table can still be read, appends fail from then on.
 func (t *Table) Close() error {
-	if t.activeBlock.RowCount > 0 {
-		path := filepath.Join("_data_internal", t.dbName, t.schema.Name, fmt.Sprintf("Ts%dR%di%d.parquet", t.activeBlock.MaxTs, t.activeBlock.RowCount, len(t.coldBlocks)))
-		if err := t.activeBlock.Persist(path, t.schema, t.locations); err != nil {
-			return fmt.Errorf("failed to persist active block: %v", err)
-		}
+	t.StopCompaction()
+	t.StopRetention()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err := t.persistLocked(); err != nil {
+		return err
+	}
+	// the active block is on disk now and has no storage left to append to
+	t.closed = true
+	return t.closeWALLocked()
+}
 
-		if t.wal != nil {
-			if err := t.wal.Reset(); err != nil {
-				return fmt.Errorf("failed to reset WAL on close: %v", err)
+// persistLocked writes the cold and active blocks still in memory to disk, records them
+// in the manifest and checkpoints the WAL past them. t.mu must be held.
+func (t *Table) persistLocked() error {
+	var persisted []blockMeta
+
+	for _, block := range t.coldBlocks {
+		if !block.isOnDisk {
+			if err := block.Persist(t.blockPath(block), t.schema, t.locations); err != nil {
+				return fmt.Errorf("failed to persist cold block %d: %v", block.ID, err)
 			}
+			persisted = append(persisted, newBlockMeta(block))
 		}
 	}
 
-	for i, block := range t.coldBlocks {
-		if !block.isOnDisk {
-			path := filepath.Join("_data_internal", t.dbName, t.schema.Name, fmt.Sprintf("Ts%dR%di%d.parquet", block.MaxTs, block.RowCount, i))
-			if err := block.Persist(path, t.schema, t.locations); err != nil {
-				return fmt.Errorf("failed to persist cold block %d: %v", i, err)
+	if t.activeBlock.RowCount > 0 && !t.activeBlock.isOnDisk {
+		blocks := t.splitByPartition(t.splitActive())
+		for _, block := range blocks {
+			if err := block.Persist(t.blockPath(block), t.schema, t.locations); err != nil {
+				return fmt.Errorf("failed to persist active block: %v", err)
 			}
+			persisted = append(persisted, newBlockMeta(block))
+		}
+
+		// late rows and older partitions split off join the cold blocks, the newest
+		// block stays the active block
+		for _, block := range blocks[:len(blocks)-1] {
+			t.addColdBlock(block)
+		}
+		t.activeBlock = blocks[len(blocks)-1]
+	}
+
+	if len(persisted) == 0 {
+		return nil
+	}
+
+	if err := appendManifest(t.dir, manifestEntry{Add: persisted}); err != nil {
+		return fmt.Errorf("failed to record blocks in manifest: %v", err)
+	}
+	t.notePartitions()
+
+	// the WAL may only forget the rows once the manifest knows their block
+	if t.wal != nil {
+		if err := t.wal.Checkpoint(t.activeBlock.MaxLSN); err != nil {
+			return fmt.Errorf("failed to checkpoint WAL on close: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// Detach takes the table out of service so its directory can be moved or removed. It
+// refuses while a reader holds a snapshot of the table; otherwise it waits for running
+// appends, stops the background jobs and closes the WAL. With persist the rows still in
+// memory are written out first like Close does, without it they are left to the WAL.
+// Appends fail from then on and readers return no rows.
+func (t *Table) Detach(persist bool) error {
+	t.mu.Lock()
+	if n := t.liveReaders(); n > 0 {
+		t.mu.Unlock()
+		return fmt.Errorf("table %s has %d open readers", t.schema.Name, n)
+	}
+	if t.detached {
+		t.mu.Unlock()
+		return fmt.Errorf("table %s has been detached", t.schema.Name)
+	}
+	t.detached = true
+	t.mu.Unlock()
+
+	t.StopCompaction()
+	t.StopRetention()
+
+	if persist {
+		if err := t.Close(); err != nil {
+			return err
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.closeWALLocked()
+}
+
+func (t *Table) closeWALLocked() error {
+	if t.wal == nil {
+		return nil
+	}
+	err := t.wal.Close()
+	t.wal = nil
+	if err != nil {
+		return fmt.Errorf("failed to close WAL: %v", err)
+	}
+	return nil
+}
+
+// Verify re-reads every block file and checks it against the size and checksum
+// recorded in the manifest when the block was written.
+func (t *Table) Verify() error {
+	t.mu.RLock()
+	blocks := make([]Block, 0, len(t.coldBlocks))
+	for _, block := range t.coldBlocks {
+		if block.isOnDisk {
+			blocks = append(blocks, *block)
+		}
+	}
+	t.mu.RUnlock()
+
+	for _, block := range blocks {
+		size, sum, err := fileChecksum(block.Path)
+		if err != nil {
+			return fmt.Errorf("failed to read block %s: %v", filepath.Base(block.Path), err)
+		}
+		if size != block.Size || sum != block.Checksum {
+			return fmt.Errorf("block %s does not match its checksum: %d bytes with crc %08x, expected %d bytes with crc %08x",
+				filepath.Base(block.Path), size, sum, block.Size, block.Checksum)
 		}
 	}
 
@@ -562,9 +1881,10 @@ func (t *Table) Close() error {
 }
 
 func (t *Table) LoadFromDisk() error {
-	dirPath := filepath.Join("_data_internal", t.dbName, t.schema.Name)
+	t.mu.Lock()
+	defer t.mu.Unlock()
 
-	info, err := os.Stat(dirPath)
+	info, err := os.Stat(t.dir)
 
 	if os.IsNotExist(err) {
 		return nil
@@ -574,104 +1894,148 @@ func (t *Table) LoadFromDisk() error {
 		return fmt.Errorf("failed to check table directory: %v", err)
 	}
 	if !info.IsDir() {
-		return fmt.Errorf("path %s is not a directory", dirPath)
+		return fmt.Errorf("path %s is not a directory", t.dir)
+	}
+
+	// sizes of the parquet files by path relative to the table directory, whatever the
+	// manifest does not claim is left over
+	files := make(map[string]int64)
+	var names []string
+	var partitionDirs []string
+
+	var scan func(sub string) error
+	scan = func(sub string) error {
+		entries, err := os.ReadDir(filepath.Join(t.dir, sub))
+		if err != nil {
+			return fmt.Errorf("failed to read table directory: %v", err)
+		}
+
+		for _, entry := range entries {
+			name := filepath.Join(sub, entry.Name())
+
+			// partitions only nest one level deep, the WAL lives next to them
+			if entry.IsDir() {
+				if _, err := t.Partitioning.parsePartition(entry.Name()); err == nil && sub == "" {
+					partitionDirs = append(partitionDirs, name)
+					if err := scan(name); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+
+			// a flush that never got to its rename, the WAL still holds its rows
+			if strings.HasSuffix(name, ".parquet"+tempSuffix) {
+				if err := os.Remove(filepath.Join(t.dir, name)); err != nil {
+					return fmt.Errorf("failed to remove stray block file %s: %v", name, err)
+				}
+				continue
+			}
+
+			if strings.HasSuffix(name, ".parquet") {
+				info, err := entry.Info()
+				if err != nil {
+					return fmt.Errorf("failed to stat block %s: %v", name, err)
+				}
+				files[name] = info.Size()
+				names = append(names, name)
+			}
+		}
+		return nil
+	}
+
+	if err := scan(""); err != nil {
+		return err
 	}
 
-	entries, err := os.ReadDir(dirPath)
+	metas, ok, rewrite, err := loadManifest(t.dir)
 	if err != nil {
-		return fmt.Errorf("failed to read table directory: %v", err)
+		return err
 	}
 
 	var loadedBlocks []*Block
 
-	for _, entry := range entries {
-		if entry.IsDir() {
-			continue
-		}
+	if ok {
+		for _, meta := range metas {
+			name := filepath.Join(meta.Partition, meta.File)
+			size, found := files[name]
+			if !found {
+				return fmt.Errorf("block %s listed in the manifest is missing", name)
+			}
+			delete(files, name)
 
-		name := entry.Name()
-		if !strings.HasSuffix(name, ".parquet") {
-			continue
-		}
+			// a full checksum needs the whole file, Verify does that on request
+			if size != meta.Size {
+				return fmt.Errorf("block %s is %d bytes, the manifest recorded %d", name, size, meta.Size)
+			}
 
-		fullPath := filepath.Join(dirPath, name)
+			if _, _, ok := t.layout(meta.SchemaVersion); !ok {
+				return fmt.Errorf("block %s uses unknown schema version %d", meta.File, meta.SchemaVersion)
+			}
 
-		var maxTs int64
-		var rowCount int
-		var iter int
-		_, err := fmt.Sscanf(name, "Ts%dR%di%d.parquet", &maxTs, &rowCount, &iter)
-		if err != nil {
-			continue
+			block, err := meta.block(t.dir)
+			if err != nil {
+				return err
+			}
+			loadedBlocks = append(loadedBlocks, block)
 		}
 
-		block := &Block{
-			Path:     fullPath,
-			MaxTs:    maxTs,
-			RowCount: rowCount,
-			isOnDisk: true,
-			isClosed: true,
-		}
-		
-		// fill the statistics of the loaded blocks for filter to be efficient
-		f, err := os.Open(fullPath)
-		if err != nil {
-			continue // or handle error
+		// written but never recorded because of a crash, their rows are still in the WAL
+		for name := range files {
+			if err := os.Remove(filepath.Join(t.dir, name)); err != nil {
+				return fmt.Errorf("failed to remove unrecorded block file %s: %v", name, err)
+			}
 		}
-		defer f.Close()
-		stat, _ := f.Stat()
-		pf, err := parquet.OpenFile(f, stat.Size())
-		if err != nil {
-			continue
+
+		// partitions dropped while a reader still used their files
+		for _, dir := range partitionDirs {
+			if entries, err := os.ReadDir(filepath.Join(t.dir, dir)); err == nil && len(entries) == 0 {
+				os.Remove(filepath.Join(t.dir, dir))
+			}
 		}
-		numInt, numFloat := 0, 0
-		for _, col := range t.schema.Columns {
-			if col.Type == schema.Int64 {
-				numInt++
+	} else {
+		// tables written before the manifest existed keep their metadata in the file
+		// names and the parquet footers, it moves to a manifest once read
+		for _, name := range names {
+			block, err := t.scanBlock(name)
+			if err != nil {
+				return err
 			}
-			if col.Type == schema.Float64 {
-				numFloat++
+			if block != nil {
+				loadedBlocks = append(loadedBlocks, block)
 			}
 		}
-		block.IntMin = make([]int64, numInt)
-		block.IntMax = make([]int64, numInt)
-		block.FloatMin = make([]float64, numFloat)
-		block.FloatMax = make([]float64, numFloat)
-
-		intCount, floatCount := 0, 0
-		rowGroup := pf.RowGroups()[0]
-		for i, col := range t.schema.Columns {
-
-			if i >= len(rowGroup.ColumnChunks()) {
-				break
-			}
-
-			chunk := rowGroup.ColumnChunks()[i]
-			idx, err := chunk.ColumnIndex()
+		rewrite = len(loadedBlocks) > 0
+	}
 
-			if err == nil && idx != nil && idx.NumPages() > 0 {
-				switch col.Type {
-				case schema.Int64:
-					block.IntMin[intCount] = idx.MinValue(0).Int64()
-					block.IntMax[intCount] = idx.MaxValue(0).Int64()
-					intCount++
-				case schema.Float64:
-					block.FloatMin[floatCount] = idx.MinValue(0).Double()
-					block.FloatMax[floatCount] = idx.MaxValue(0).Double()
-					floatCount++
-				}
-			}
+	// a compacted block is added after the blocks that follow it, it may share its
+	// MaxTs with the next one but starts earlier
+	sort.SliceStable(loadedBlocks, func(i, j int) bool {
+		if loadedBlocks[i].MaxTs != loadedBlocks[j].MaxTs {
+			return loadedBlocks[i].MaxTs < loadedBlocks[j].MaxTs
 		}
+		return loadedBlocks[i].MinTs < loadedBlocks[j].MinTs
+	})
 
-		f.Close()
-		loadedBlocks = append(loadedBlocks, block)
-		t.rowCount += rowCount
+	if rewrite {
+		live := make([]blockMeta, len(loadedBlocks))
+		for i, block := range loadedBlocks {
+			live[i] = newBlockMeta(block)
+		}
+		if err := writeManifest(t.dir, live); err != nil {
+			return fmt.Errorf("failed to rewrite manifest: %v", err)
+		}
 	}
 
-	sort.Slice(loadedBlocks, func(i, j int) bool {
-		return loadedBlocks[i].MaxTs < loadedBlocks[j].MaxTs
-	})
+	for _, block := range loadedBlocks {
+		t.rowCount += block.RowCount
+		if block.ID >= t.nextBlockID {
+			t.nextBlockID = block.ID + 1
+		}
+	}
 
 	t.coldBlocks = append(t.coldBlocks, loadedBlocks...)
+	t.notePartitions()
 
 	if len(loadedBlocks) > 0 {
 		lastBlock := loadedBlocks[len(loadedBlocks)-1]
@@ -683,3 +2047,105 @@ func (t *Table) LoadFromDisk() error {
 	t.UseDiskStorage = true //when using opentable if we find a evidence of disk storage, we set this to true
 	return nil
 }
+
+// scanBlock rebuilds the metadata of a block written before the manifest existed from
+// its file name and parquet footer. Stats are aggregated over the column index of every
+// page of every row group, or computed from the rows when the file has no index. It
+// returns nil for files whose name does not follow the block naming.
+func (t *Table) scanBlock(name string) (*Block, error) {
+	fullPath := filepath.Join(t.dir, name)
+
+	var maxTs int64
+	var rowCount int
+	var iter int
+	_, err := fmt.Sscanf(name, "Ts%dR%di%d.parquet", &maxTs, &rowCount, &iter)
+	if err != nil {
+		return nil, nil
+	}
+
+	block := &Block{
+		Path:     fullPath,
+		ID:       iter,
+		MaxTs:    maxTs,
+		RowCount: rowCount,
+		isOnDisk: true,
+		isClosed: true,
+	}
+
+	block.Size, block.Checksum, err = fileChecksum(fullPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read block %s: %v", name, err)
+	}
+
+	// blocks only appear under their final name once complete, so one that does not
+	// open is damaged rather than half written and must not be skipped quietly
+	f, err := os.Open(fullPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open block %s: %v", name, err)
+	}
+	defer f.Close()
+
+	pf, err := parquet.OpenFile(f, block.Size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read block %s: %v", name, err)
+	}
+
+	version := 0
+	if v, ok := pf.Lookup(schemaVersionKey); ok {
+		version, err = strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("block %s has an invalid schema version %q", name, v)
+		}
+	}
+
+	blockSchema, blockLocations, ok := t.layout(version)
+	if !ok {
+		return nil, fmt.Errorf("block %s uses unknown schema version %d", name, version)
+	}
+
+	// blocks written before the WAL had LSNs carry none and keep MaxLSN 0
+	if v, ok := pf.Lookup(maxLSNKey); ok {
+		block.MaxLSN, err = strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("block %s has an invalid max LSN %q", name, v)
+		}
+	}
+
+	block.SchemaVersion = version
+	block.allocStats(columnTypes(blockSchema))
+	// string stats stay nil unless the rows are decoded below, the column index may
+	// truncate long values
+
+	// parquet orders the leaf columns by name, not by schema position
+	chunkIndices := make(map[string]int)
+	for i, field := range pf.Schema().Fields() {
+		chunkIndices[field.Name()] = i
+	}
+
+	// the stats cover every page of every row group, files without a column index are
+	// decoded instead
+	indexed := true
+	for logicalIdx, col := range blockSchema.Columns {
+		chunkIdx, ok := chunkIndices[col.Name]
+		if !ok {
+			continue
+		}
+		if !block.statsFromIndex(pf, chunkIdx, logicalIdx, blockLocations[logicalIdx]) {
+			indexed = false
+			break
+		}
+	}
+	if !indexed {
+		if err := block.statsFromRows(pf, blockSchema, blockLocations); err != nil {
+			return nil, fmt.Errorf("failed to read stats of block %s: %v", name, err)
+		}
+	}
+
+	if idx := slices.IndexFunc(blockSchema.Columns, func(c schema.Column) bool { return c.Name == blockSchema.TimeColumn }); idx != -1 {
+		if loc := blockLocations[idx]; loc.Type == schema.Int64 {
+			block.MinTs = block.IntMin[loc.Index]
+		}
+	}
+
+	return block, nil
+}
@@ -8,7 +8,7 @@ import (
 	"testing"
 )
 
-func setupTestTable() (*Table, error) {
+func setupTestTable(t *testing.T) (*Table, error) {
 	s := schema.Schema{
 		Name:       "test_table",
 		TimeColumn: "ts",
@@ -20,7 +20,7 @@ func setupTestTable() (*Table, error) {
 		},
 	}
 
-	tbl, err := CreateTable(s, nil, "test_db")
+	tbl, err := CreateTable(s, nil, t.TempDir())
 	if err != nil {
 		return nil, err
 	}
@@ -43,7 +43,7 @@ func setupTestTable() (*Table, error) {
 }
 
 func TestTimeFilters(t *testing.T) {
-	tbl, _ := setupTestTable()
+	tbl, _ := setupTestTable(t)
 
 	tests := []struct {
 		name     string
@@ -84,7 +84,7 @@ func TestTimeFilters(t *testing.T) {
 }
 
 func TestChainedFilters(t *testing.T) {
-	tbl, _ := setupTestTable()
+	tbl, _ := setupTestTable(t)
 
 	// ts > 150 AND price < 1000
 	// 200 (GOOG 2800) - NO (price looks wrong, 2800 > 1000)
@@ -119,7 +119,7 @@ func TestMultiTimestampEquality(t *testing.T) {
 		Name: "multi_ts", TimeColumn: "ts",
 		Columns: []schema.Column{{Name: "ts", Type: schema.Int64}},
 	}
-	tbl, _ := CreateTable(s, nil, "test_db")
+	tbl, _ := CreateTable(s, nil, t.TempDir())
 	tbl.AppendRow(map[string]any{"ts": int64(100)})
 	tbl.AppendRow(map[string]any{"ts": int64(200)})
 	tbl.AppendRow(map[string]any{"ts": int64(200)}) // Duplicate
@@ -160,7 +160,7 @@ func TestMultiTimestampEquality(t *testing.T) {
 }
 
 func TestStringFilters(t *testing.T) {
-	tbl, _ := setupTestTable()
+	tbl, _ := setupTestTable(t)
 
 	t.Run("EQ", func(t *testing.T) {
 		r := tbl.Reader().Filter("symbol", "==", "AAPL")
@@ -200,7 +200,7 @@ func TestStringFilters(t *testing.T) {
 }
 
 func TestComplexFilterCombinations(t *testing.T) {
-	tbl, _ := setupTestTable()
+	tbl, _ := setupTestTable(t)
 
 	// ts >= 200 AND ts <= 400 AND symbol != MSFT
 	// 200 (GOOG) - YES
@@ -250,9 +250,7 @@ func TestBlockFlushing(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			defer os.RemoveAll(filepath.Join("_data_internal", "test_db"))
-
-			tbl, err := CreateTable(s, nil, "test_db")
+			tbl, err := CreateTable(s, nil, t.TempDir())
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -359,11 +357,10 @@ func TestBlockLoadInto(t *testing.T) {
 	block.RowCount = 2
 
 	// 3. Flush to Disk
-	path := "_data_internal/test_db/load_test/test.parquet"
+	path := filepath.Join(t.TempDir(), "load_test", "test.parquet")
 	if err := block.Rotate(true, path, s, locations); err != nil {
 		t.Fatalf("Flush failed: %v", err)
 	}
-	defer os.RemoveAll(filepath.Join("_data_internal", "test_db"))
 
 	// 4. Test LoadInto
 	// Create a FRESH storage destination
@@ -406,12 +403,11 @@ func TestEndToEndIntegration(t *testing.T) {
 		},
 	}
 
-	dbName := "e2e_test_db"
-	defer os.RemoveAll(filepath.Join("_data_internal", dbName))
+	dir := t.TempDir()
 
 	// Phase 1: Create, write, and close
 	{
-		tbl, err := CreateTable(s, nil, dbName)
+		tbl, err := CreateTable(s, nil, dir)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -435,7 +431,7 @@ func TestEndToEndIntegration(t *testing.T) {
 	}
 
 	// Phase 2: Open fresh table and load from disk
-	tbl, err := CreateTable(s, nil, dbName)
+	tbl, err := CreateTable(s, nil, dir)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -539,3 +535,1107 @@ func TestBlockStats(t *testing.T) {
 		t.Errorf("Int64[1] Mismatch: Expected [-50, -1], got [%d, %d]", b.IntMin[1], b.IntMax[1])
 	}
 }
+
+func TestStringStats(t *testing.T) {
+	s := schema.Schema{
+		Name:       "quotes",
+		TimeColumn: "ts",
+		Columns: []schema.Column{
+			{Name: "ts", Type: schema.Int64},
+			{Name: "symbol", Type: schema.String, Nullable: true},
+		},
+	}
+
+	dir := t.TempDir()
+	tbl, err := CreateTable(s, nil, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tbl.MaxBlockSize = 4
+	tbl.UseDiskStorage = true
+
+	for i, symbol := range []any{"BTC", "ETH", nil, "BTC", "SOL", "XRP", "SOL", "SOL", "ADA"} {
+		if err := tbl.AppendRow(map[string]any{"ts": int64(i), "symbol": symbol}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b0 := tbl.coldBlocks[0]
+	if b0.StringMin[0] != "BTC" || b0.StringMax[0] != "ETH" || fmt.Sprint(b0.StringValues[0]) != "[BTC ETH]" {
+		t.Errorf("expected BTC to ETH with values [BTC ETH], got %s to %s with %v", b0.StringMin[0], b0.StringMax[0], b0.StringValues[0])
+	}
+
+	// which of the two flushed blocks each filter has to read
+	type reads struct {
+		name string
+		expr Expr
+		read [2]bool
+	}
+
+	tests := []reads{
+		{"Missing", Compare("symbol", "==", "XYZ"), [2]bool{false, false}},
+		{"InRangeNotInBlock", Compare("symbol", "==", "DOGE"), [2]bool{false, false}},
+		{"Present", Compare("symbol", "==", "ETH"), [2]bool{true, false}},
+		{"NotEqual", Compare("symbol", "!=", "SOL"), [2]bool{true, true}},
+		{"In", In("symbol", "ADA", "XRP"), [2]bool{false, true}},
+		{"Like", Compare("symbol", OpLike, "%TH"), [2]bool{true, false}},
+		{"NotPrefix", Not(Compare("symbol", OpPrefix, "B")), [2]bool{true, true}},
+	}
+
+	check := func(t *testing.T, tbl *Table, tests []reads, drop bool) {
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				r := tbl.Reader().Where(tt.expr)
+				defer r.Close()
+
+				for i := range tt.read {
+					block := r.blocks[i]
+					if drop {
+						block.StringValues = nil
+					}
+					skip, err := r.canSkipBlock(block)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if skip == tt.read[i] {
+						t.Errorf("expected read %v for block %d, got %v", tt.read[i], i, !skip)
+					}
+				}
+			})
+		}
+	}
+
+	check(t, tbl, tests, false)
+
+	t.Run("Reload", func(t *testing.T) {
+		reloaded, err := CreateTable(s, nil, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := reloaded.LoadFromDisk(); err != nil {
+			t.Fatal(err)
+		}
+		b := reloaded.coldBlocks[1]
+		if b.StringMin[0] != "SOL" || b.StringMax[0] != "XRP" || fmt.Sprint(b.StringValues[0]) != "[SOL XRP]" {
+			t.Errorf("expected the string stats to survive a reload, got %s to %s with %v", b.StringMin[0], b.StringMax[0], b.StringValues[0])
+		}
+		check(t, reloaded, tests, false)
+	})
+
+	// without the distinct values, min and max only rule out what lies outside them
+	t.Run("MinMaxOnly", func(t *testing.T) {
+		check(t, tbl, []reads{
+			{"InRange", Compare("symbol", "==", "DOGE"), [2]bool{true, false}},
+			{"Below", Compare("symbol", "==", "AAA"), [2]bool{false, false}},
+			{"Prefix", Compare("symbol", OpPrefix, "ET"), [2]bool{true, false}},
+			{"PrefixPastMax", Compare("symbol", OpPrefix, "Z"), [2]bool{false, false}},
+			{"PrefixBeforeMin", Compare("symbol", OpPrefix, "A"), [2]bool{false, false}},
+			{"NotEqual", Compare("symbol", "!=", "SOL"), [2]bool{true, true}},
+		}, true)
+	})
+
+	t.Run("TooManyValues", func(t *testing.T) {
+		b, _, err := NewBlock([]schema.ColumnType{schema.Int64, schema.String})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i <= maxStringValues; i++ {
+			b.Storage.AppendValue(1, fmt.Sprintf("S%04d", i))
+		}
+		b.UpdateStats()
+
+		if b.StringValues[0] != nil || b.StringMin[0] != "S0000" || b.StringMax[0] != fmt.Sprintf("S%04d", maxStringValues) {
+			t.Errorf("expected only min and max past %d values, got %s to %s with %d values", maxStringValues, b.StringMin[0], b.StringMax[0], len(b.StringValues[0]))
+		}
+	})
+
+	// JSON would turn the values into U+FFFD, and the reloaded stats rule out the block
+	t.Run("InvalidUTF8", func(t *testing.T) {
+		dir := t.TempDir()
+		tbl, err := CreateTable(s, nil, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tbl.MaxBlockSize = 4
+		tbl.UseDiskStorage = true
+
+		for i, symbol := range []string{"\xff", "\xfe\x80", "\xff", "A\xff"} {
+			if err := tbl.AppendRow(map[string]any{"ts": int64(i), "symbol": symbol}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		reloaded, err := CreateTable(s, nil, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := reloaded.LoadFromDisk(); err != nil {
+			t.Fatal(err)
+		}
+		b := reloaded.coldBlocks[0]
+		if b.StringMin[0] != "A\xff" || b.StringMax[0] != "\xff" || len(b.StringValues[0]) != 3 {
+			t.Errorf("expected the bytes to survive a reload, got %q to %q with %q", b.StringMin[0], b.StringMax[0], b.StringValues[0])
+		}
+		for symbol, n := range map[string]int{"\xff": 2, "\xfe\x80": 1, "A\xff": 1} {
+			if times := scanTimes(t, reloaded.Reader().Filter("symbol", "==", symbol)); len(times) != n {
+				t.Errorf("expected %d rows of %q after a reload, got %v", n, symbol, times)
+			}
+		}
+	})
+}
+
+func TestBooleanColumns(t *testing.T) {
+	s := schema.Schema{
+		Name:       "bool_test",
+		TimeColumn: "ts",
+		Columns: []schema.Column{
+			{Name: "ts", Type: schema.Int64},
+			{Name: "is_buy", Type: schema.Boolean},
+			{Name: "is_auction", Type: schema.Boolean},
+		},
+	}
+
+	dir := t.TempDir()
+
+	tbl, err := CreateTable(s, nil, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tbl.MaxBlockSize = 100
+	tbl.UseDiskStorage = true
+
+	// block 0 is all buys and has no auctions, so is_auction == true can skip it
+	for i := 0; i < 250; i++ {
+		row := map[string]any{
+			"ts":         int64(i),
+			"is_buy":     i < 100 || i%2 == 0,
+			"is_auction": i >= 100 && i%5 == 0,
+		}
+		if err := tbl.AppendRow(row); err != nil {
+			t.Fatalf("failed to append row %d: %v", i, err)
+		}
+	}
+
+	b0 := tbl.coldBlocks[0]
+	if !b0.BoolMin[0] || !b0.BoolMax[0] {
+		t.Errorf("expected is_buy stats [true, true] for block 0, got [%v, %v]", b0.BoolMin[0], b0.BoolMax[0])
+	}
+	if b0.BoolMin[1] || b0.BoolMax[1] {
+		t.Errorf("expected is_auction stats [false, false] for block 0, got [%v, %v]", b0.BoolMin[1], b0.BoolMax[1])
+	}
+
+	skip, err := tbl.Reader().CanSkip(b0, Predicate{ColName: "is_auction", Op: "==", Value: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !skip {
+		t.Error("expected block 0 to be skipped for is_auction == true")
+	}
+
+	tests := []struct {
+		name     string
+		col      string
+		op       string
+		val      bool
+		expected int
+	}{
+		{"BuyEQ", "is_buy", "==", true, 175},
+		{"BuyNEQ", "is_buy", "!=", true, 75},
+		{"AuctionEQ", "is_auction", "==", true, 30},
+		{"AuctionNEQ", "is_auction", "!=", true, 220},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := tbl.Reader().Filter(tt.col, tt.op, tt.val)
+			count := 0
+			for {
+				row, ok := r.Next()
+				if !ok {
+					break
+				}
+				if (row[tt.col].(bool) == tt.val) != (tt.op == "==") {
+					t.Errorf("row ts=%v does not satisfy %s %s %v", row["ts"], tt.col, tt.op, tt.val)
+				}
+				count++
+			}
+			if count != tt.expected {
+				t.Errorf("expected %d rows, got %d", tt.expected, count)
+			}
+		})
+	}
+
+	t.Run("ReloadStats", func(t *testing.T) {
+		if err := tbl.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		reloaded, err := CreateTable(s, nil, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := reloaded.LoadFromDisk(); err != nil {
+			t.Fatal(err)
+		}
+
+		b0 := reloaded.coldBlocks[0]
+		if !b0.BoolMin[0] || !b0.BoolMax[0] || b0.BoolMin[1] || b0.BoolMax[1] {
+			t.Errorf("bool stats not recovered from parquet: is_buy [%v, %v], is_auction [%v, %v]",
+				b0.BoolMin[0], b0.BoolMax[0], b0.BoolMin[1], b0.BoolMax[1])
+		}
+
+		r := reloaded.Reader().Filter("is_auction", "==", true)
+		count := 0
+		for {
+			if _, ok := r.Next(); !ok {
+				break
+			}
+			count++
+		}
+		if count != 30 {
+			t.Errorf("expected 30 auction rows after reload, got %d", count)
+		}
+	})
+}
+
+func TestNullableColumns(t *testing.T) {
+	s := schema.Schema{
+		Name:       "null_test",
+		TimeColumn: "ts",
+		Columns: []schema.Column{
+			{Name: "ts", Type: schema.Int64},
+			{Name: "price", Type: schema.Float64, Nullable: true},
+			{Name: "venue", Type: schema.String, Nullable: true},
+		},
+	}
+
+	dir := t.TempDir()
+
+	tbl, err := CreateTable(s, nil, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tbl.MaxBlockSize = 100
+	tbl.UseDiskStorage = true
+
+	// venue is missing for all of block 0, price is null for every 4th row after it
+	for i := 0; i < 250; i++ {
+		row := map[string]any{"ts": int64(i)}
+		if i < 100 || i%4 != 0 {
+			row["price"] = float64(i) + 1
+		} else {
+			row["price"] = nil
+		}
+		if i >= 100 && i%3 != 0 {
+			row["venue"] = "X"
+		}
+		if err := tbl.AppendRow(row); err != nil {
+			t.Fatalf("failed to append row %d: %v", i, err)
+		}
+	}
+
+	b0 := tbl.coldBlocks[0]
+	if b0.NullCounts[1] != 0 || b0.NullCounts[2] != 100 {
+		t.Errorf("expected null counts [0, 100] for block 0, got %v", b0.NullCounts[1:])
+	}
+
+	skips := []Predicate{
+		{ColName: "price", Op: OpIsNull},
+		{ColName: "venue", Op: OpIsNotNull},
+		{ColName: "venue", Op: "==", Value: "X"},
+	}
+	for _, p := range skips {
+		skip, err := tbl.Reader().CanSkip(b0, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !skip {
+			t.Errorf("expected block 0 to be skipped for %s %s", p.ColName, p.Op)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		col      string
+		op       string
+		val      any
+		expected int
+	}{
+		{"PriceIsNull", "price", OpIsNull, nil, 38},
+		{"PriceIsNotNull", "price", OpIsNotNull, nil, 212},
+		{"PriceGT", "price", ">", 0.0, 212},
+		{"VenueIsNull", "venue", OpIsNull, nil, 150},
+		{"VenueEQ", "venue", "==", "X", 100},
+		{"VenueNEQ", "venue", "!=", "Y", 100},
+	}
+
+	count := func(tbl *Table, col, op string, val any) int {
+		r := tbl.Reader().Filter(col, op, val)
+		n := 0
+		for {
+			row, ok := r.Next()
+			if !ok {
+				break
+			}
+			if (op == OpIsNull) != (row[col] == nil) {
+				t.Errorf("row ts=%v has %s=%v for %s", row["ts"], col, row[col], op)
+			}
+			n++
+		}
+		return n
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := count(tbl, tt.col, tt.op, tt.val); got != tt.expected {
+				t.Errorf("expected %d rows, got %d", tt.expected, got)
+			}
+		})
+	}
+
+	t.Run("Reload", func(t *testing.T) {
+		if err := tbl.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		reloaded, err := CreateTable(s, nil, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := reloaded.LoadFromDisk(); err != nil {
+			t.Fatal(err)
+		}
+
+		b0 := reloaded.coldBlocks[0]
+		if b0.NullCounts[1] != 0 || b0.NullCounts[2] != 100 {
+			t.Errorf("null counts not recovered from parquet: %v", b0.NullCounts)
+		}
+
+		for _, tt := range tests {
+			if got := count(reloaded, tt.col, tt.op, tt.val); got != tt.expected {
+				t.Errorf("%s: expected %d rows after reload, got %d", tt.name, tt.expected, got)
+			}
+		}
+	})
+
+	t.Run("RejectsNullInRequiredColumn", func(t *testing.T) {
+		if err := tbl.AppendRow(map[string]any{"ts": nil}); err == nil {
+			t.Error("expected an error for a null time column")
+		}
+	})
+}
+
+func TestNullableZeroValues(t *testing.T) {
+	s := schema.Schema{
+		Name:       "zeros",
+		TimeColumn: "ts",
+		Columns: []schema.Column{
+			{Name: "ts", Type: schema.Int64},
+			{Name: "n", Type: schema.Int64, Nullable: true},
+			{Name: "s", Type: schema.String, Nullable: true},
+			{Name: "b", Type: schema.Boolean, Nullable: true},
+			{Name: "f", Type: schema.Float64, Nullable: true},
+		},
+	}
+
+	tbl, err := CreateTable(s, nil, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tbl.MaxBlockSize = 4
+	tbl.UseDiskStorage = true
+
+	// zero values on even rows, nulls on odd ones, the first four rows get flushed
+	for i := 0; i < 6; i++ {
+		row := map[string]any{"ts": int64(i), "n": int64(0), "s": "", "b": false, "f": 0.0}
+		if i%2 == 1 {
+			row = map[string]any{"ts": int64(i), "n": nil, "s": nil, "b": nil, "f": nil}
+		}
+		if err := tbl.AppendRow(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(tbl.coldBlocks) != 1 || !tbl.coldBlocks[0].isOnDisk {
+		t.Fatalf("expected one block on disk, got %d", len(tbl.coldBlocks))
+	}
+
+	r := tbl.Reader()
+	for {
+		row, ok := r.Next()
+		if !ok {
+			break
+		}
+		zero := row["ts"].(int64)%2 == 0
+		for _, col := range []string{"n", "s", "b", "f"} {
+			if (row[col] != nil) != zero {
+				t.Errorf("row ts=%v: unexpected %s=%v", row["ts"], col, row[col])
+			}
+		}
+	}
+	if err := r.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(scanTimes(t, tbl.Reader().Filter("f", OpIsNotNull, nil))); n != 3 {
+		t.Errorf("expected 3 rows with f set, got %d", n)
+	}
+}
+
+func TestAppendBatch(t *testing.T) {
+	s := schema.Schema{
+		Name:       "batch_test",
+		TimeColumn: "ts",
+		Columns: []schema.Column{
+			{Name: "ts", Type: schema.Int64},
+			{Name: "symbol", Type: schema.String},
+			{Name: "price", Type: schema.Float64},
+			{Name: "is_buy", Type: schema.Boolean},
+			{Name: "venue", Type: schema.String, Nullable: true},
+		},
+	}
+
+	tbl, err := CreateTable(s, nil, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tbl.MaxBlockSize = 100
+	tbl.UseDiskStorage = true
+
+	rows := make([]map[string]any, 150)
+	for i := range rows {
+		rows[i] = map[string]any{"ts": int64(i), "symbol": "AAPL", "price": float64(i), "is_buy": i%2 == 0}
+	}
+
+	t.Run("Rows", func(t *testing.T) {
+		if err := tbl.AppendBatch(rows); err != nil {
+			t.Fatal(err)
+		}
+
+		// the batch lands in one block even though it is larger than MaxBlockSize
+		if len(tbl.coldBlocks) != 1 || tbl.coldBlocks[0].RowCount != 150 {
+			t.Fatalf("expected a single cold block of 150 rows, got %d blocks", len(tbl.coldBlocks))
+		}
+		if tbl.RowCount() != 150 {
+			t.Errorf("expected 150 rows, got %d", tbl.RowCount())
+		}
+	})
+
+	t.Run("Columns", func(t *testing.T) {
+		cols := map[string]any{
+			"ts":     []int64{200, 201, 202},
+			"symbol": []string{"MSFT", "MSFT", "GOOG"},
+			"price":  []float64{1.5, 2.5, 3.5},
+			"is_buy": []bool{true, false, true},
+		}
+		if err := tbl.AppendColumns(cols); err != nil {
+			t.Fatal(err)
+		}
+
+		r := tbl.Reader().Filter("ts", ">=", int64(200))
+		var got []map[string]any
+		for {
+			row, ok := r.Next()
+			if !ok {
+				break
+			}
+			got = append(got, row)
+		}
+		if len(got) != 3 {
+			t.Fatalf("expected 3 rows, got %d", len(got))
+		}
+		if got[2]["symbol"] != "GOOG" || got[1]["is_buy"] != false || got[0]["price"] != 1.5 || got[0]["venue"] != nil {
+			t.Errorf("unexpected rows: %v", got)
+		}
+	})
+
+	rejects := []struct {
+		name  string
+		apply func() error
+	}{
+		{"RowOutOfOrderInBatch", func() error {
+			return tbl.AppendBatch([]map[string]any{
+				{"ts": int64(300), "symbol": "A", "price": 1.0, "is_buy": true},
+				{"ts": int64(299), "symbol": "A", "price": 1.0, "is_buy": true},
+			})
+		}},
+		{"RowWrongType", func() error {
+			return tbl.AppendBatch([]map[string]any{
+				{"ts": int64(300), "symbol": "A", "price": 1.0, "is_buy": true},
+				{"ts": int64(301), "symbol": "A", "price": int64(1), "is_buy": true},
+			})
+		}},
+		{"ColumnsLengthMismatch", func() error {
+			return tbl.AppendColumns(map[string]any{
+				"ts": []int64{300, 301}, "symbol": []string{"A"}, "price": []float64{1, 2}, "is_buy": []bool{true, true},
+			})
+		}},
+		{"ColumnsWrongType", func() error {
+			return tbl.AppendColumns(map[string]any{
+				"ts": []int64{300}, "symbol": []string{"A"}, "price": []int64{1}, "is_buy": []bool{true},
+			})
+		}},
+		{"ColumnsMissingRequired", func() error {
+			return tbl.AppendColumns(map[string]any{"ts": []int64{300}, "symbol": []string{"A"}, "price": []float64{1}})
+		}},
+		{"ColumnsOlderThanTable", func() error {
+			return tbl.AppendColumns(map[string]any{
+				"ts": []int64{10}, "symbol": []string{"A"}, "price": []float64{1}, "is_buy": []bool{true},
+			})
+		}},
+	}
+
+	for _, tt := range rejects {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.apply(); err == nil {
+				t.Fatal("expected the batch to be rejected")
+			}
+			if tbl.RowCount() != 153 {
+				t.Errorf("a rejected batch changed the row count to %d", tbl.RowCount())
+			}
+		})
+	}
+}
+
+func TestNextBatch(t *testing.T) {
+	s := schema.Schema{
+		Name:       "batch_reader_test",
+		TimeColumn: "ts",
+		Columns: []schema.Column{
+			{Name: "ts", Type: schema.Int64},
+			{Name: "symbol", Type: schema.String},
+			{Name: "price", Type: schema.Float64},
+			{Name: "is_buy", Type: schema.Boolean},
+			{Name: "venue", Type: schema.String, Nullable: true},
+		},
+	}
+
+	tbl, err := CreateTable(s, nil, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tbl.MaxBlockSize = 100
+	tbl.UseDiskStorage = true
+
+	symbols := []string{"AAPL", "MSFT", "GOOG"}
+	for i := 0; i < 250; i++ {
+		row := map[string]any{"ts": int64(i), "symbol": symbols[i%3], "price": float64(i) / 2, "is_buy": i%2 == 0}
+		if i%5 == 0 {
+			row["venue"] = "NYSE"
+		}
+		if err := tbl.AppendRow(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("Vectors", func(t *testing.T) {
+		r := tbl.Reader().Filter("symbol", "==", "MSFT")
+
+		batches, selected := 0, 0
+		for {
+			batch, ok := r.NextBatch()
+			if !ok {
+				break
+			}
+			batches++
+
+			ts, _ := batch.Int64("ts")
+			prices, _ := batch.Float64("price")
+			ids, dict, _ := batch.Strings("symbol")
+			buys, _ := batch.Bool("is_buy")
+			venues := batch.Validity("venue")
+
+			for i, ok := range batch.Selection {
+				if !ok {
+					continue
+				}
+				selected++
+				if dict[ids[i]] != "MSFT" {
+					t.Errorf("selected row ts=%d has symbol %s", ts[i], dict[ids[i]])
+				}
+				if prices[i] != float64(ts[i])/2 || buys.Get(i) != (ts[i]%2 == 0) {
+					t.Errorf("row ts=%d has price %v and is_buy %v", ts[i], prices[i], buys.Get(i))
+				}
+				if venues == nil || venues.Get(i) != (ts[i]%5 == 0) {
+					t.Errorf("row ts=%d has the wrong validity for venue", ts[i])
+				}
+			}
+		}
+
+		if batches != 3 {
+			t.Errorf("expected 3 batches, got %d", batches)
+		}
+		if selected != 83 {
+			t.Errorf("expected 83 selected rows, got %d", selected)
+		}
+	})
+
+	t.Run("SkipsEmptyBlocks", func(t *testing.T) {
+		r := tbl.Reader().Filter("ts", ">=", int64(200))
+
+		batch, ok := r.NextBatch()
+		if !ok {
+			t.Fatal("expected a batch")
+		}
+		if batch.Selected() != 50 {
+			t.Errorf("expected 50 selected rows, got %d", batch.Selected())
+		}
+		if _, ok := r.NextBatch(); ok {
+			t.Error("expected no more batches")
+		}
+	})
+
+	t.Run("AfterNext", func(t *testing.T) {
+		r := tbl.Reader()
+		for i := 0; i < 10; i++ {
+			if _, ok := r.Next(); !ok {
+				t.Fatal("expected a row")
+			}
+		}
+
+		batch, ok := r.NextBatch()
+		if !ok {
+			t.Fatal("expected a batch")
+		}
+		if batch.Selected() != 90 {
+			t.Errorf("expected the 90 rows Next did not return, got %d", batch.Selected())
+		}
+
+		row, ok := r.Next()
+		if !ok || row["ts"] != int64(100) {
+			t.Errorf("expected Next to continue at ts=100, got %v", row["ts"])
+		}
+	})
+
+	t.Run("WrongType", func(t *testing.T) {
+		batch, ok := tbl.Reader().NextBatch()
+		if !ok {
+			t.Fatal("expected a batch")
+		}
+		if _, ok := batch.Float64("ts"); ok {
+			t.Error("expected ts not to be readable as float64")
+		}
+		if _, ok := batch.Int64("missing"); ok {
+			t.Error("expected an unknown column to be reported")
+		}
+	})
+}
+
+func TestSelect(t *testing.T) {
+	s := schema.Schema{
+		Name:       "select_test",
+		TimeColumn: "ts",
+		Columns: []schema.Column{
+			{Name: "ts", Type: schema.Int64},
+			{Name: "symbol", Type: schema.String},
+			{Name: "price", Type: schema.Float64},
+			{Name: "volume", Type: schema.Int64},
+			{Name: "is_buy", Type: schema.Boolean},
+		},
+	}
+
+	tbl, err := CreateTable(s, nil, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tbl.MaxBlockSize = 2
+	tbl.UseDiskStorage = true
+
+	rows := []map[string]any{
+		{"ts": int64(100), "symbol": "AAPL", "price": 150.0, "volume": int64(100), "is_buy": true},
+		{"ts": int64(200), "symbol": "GOOG", "price": 2800.0, "volume": int64(200), "is_buy": false},
+		{"ts": int64(300), "symbol": "AAPL", "price": 155.0, "volume": int64(300), "is_buy": true},
+	}
+	for _, row := range rows {
+		if err := tbl.AppendRow(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("Next", func(t *testing.T) {
+		r := tbl.Reader().Select("ts", "price").Filter("symbol", "==", "AAPL")
+
+		count := 0
+		for {
+			row, ok := r.Next()
+			if !ok {
+				break
+			}
+			if len(row) != 2 {
+				t.Errorf("expected only the selected columns, got %v", row)
+			}
+			if _, ok := row["price"]; !ok {
+				t.Errorf("row is missing price: %v", row)
+			}
+			count++
+		}
+		if count != 2 {
+			t.Errorf("expected 2 rows, got %d", count)
+		}
+	})
+
+	t.Run("NextBatch", func(t *testing.T) {
+		r := tbl.Reader().Select("volume")
+
+		batch, ok := r.NextBatch()
+		if !ok {
+			t.Fatal("expected a batch")
+		}
+		if len(batch.Schema.Columns) != 1 || batch.Schema.Columns[0].Name != "volume" {
+			t.Errorf("expected the batch schema to hold only volume, got %v", batch.Schema.Columns)
+		}
+		if _, ok := batch.Int64("volume"); !ok {
+			t.Error("expected volume in the batch")
+		}
+		if _, ok := batch.Float64("price"); ok {
+			t.Error("expected price to be left out of the batch")
+		}
+	})
+
+	t.Run("OnlyNeededColumnsDecoded", func(t *testing.T) {
+		r := tbl.Reader().Select("ts").Filter("volume", ">", int64(150))
+		if _, ok := r.Next(); !ok {
+			t.Fatal("expected a row")
+		}
+
+		// block 0 is on disk, so its storage was decoded for this reader
+		storage := r.currentStorage
+		for logicalIdx, col := range s.Columns {
+			loaded := storage.columnLen(logicalIdx) > 0
+			wanted := col.Name == "ts" || col.Name == "volume"
+			if loaded != wanted {
+				t.Errorf("column %s: expected loaded=%v, got %v", col.Name, wanted, loaded)
+			}
+		}
+	})
+}
+
+func TestReaderErrors(t *testing.T) {
+	tbl, err := setupTestTable(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		reader func() *TableReader
+	}{
+		{"UnknownFilterColumn", func() *TableReader { return tbl.Reader().Filter("symbl", "==", "AAPL") }},
+		{"WrongValueType", func() *TableReader { return tbl.Reader().Filter("price", ">", "150") }},
+		{"UnknownOperator", func() *TableReader { return tbl.Reader().Filter("price", "=>", 150.0) }},
+		{"OrderingOnString", func() *TableReader { return tbl.Reader().Filter("symbol", ">", "AAPL") }},
+		{"UnknownSelectColumn", func() *TableReader { return tbl.Reader().Select("ts", "prce") }},
+		{"ErrorAfterValidFilter", func() *TableReader {
+			return tbl.Reader().Filter("volume", ">", int64(0)).Filter("volume", ">", 1.5)
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := tt.reader()
+			if _, ok := r.Next(); ok {
+				t.Error("expected Next to stop on an invalid reader")
+			}
+			if _, ok := r.NextBatch(); ok {
+				t.Error("expected NextBatch to stop on an invalid reader")
+			}
+			if r.Err() == nil {
+				t.Error("expected Err to report the problem")
+			}
+		})
+	}
+
+	t.Run("UntypedInts", func(t *testing.T) {
+		r := tbl.Reader().Filter("price", ">", 200).Filter("volume", "<", 500)
+		count := 0
+		for {
+			if _, ok := r.Next(); !ok {
+				break
+			}
+			count++
+		}
+		if r.Err() != nil {
+			t.Fatal(r.Err())
+		}
+		if count != 2 {
+			t.Errorf("expected 2 rows, got %d", count)
+		}
+	})
+
+	t.Run("EndOfData", func(t *testing.T) {
+		r := tbl.Reader().Filter("volume", "<", int64(0))
+		if _, ok := r.Next(); ok {
+			t.Error("expected no rows")
+		}
+		if r.Err() != nil {
+			t.Errorf("expected no error at the end of the data, got %v", r.Err())
+		}
+	})
+
+	t.Run("CorruptBlock", func(t *testing.T) {
+		tbl.MaxBlockSize = 1
+		tbl.UseDiskStorage = true
+		if err := tbl.AppendRow(map[string]any{"ts": int64(600), "symbol": "AAPL", "price": 1.0, "volume": int64(1)}); err != nil {
+			t.Fatal(err)
+		}
+
+		block := tbl.coldBlocks[len(tbl.coldBlocks)-1]
+		if err := os.WriteFile(block.Path, []byte("not a parquet file"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		r := tbl.Reader().Filter("ts", ">=", int64(600))
+		if _, ok := r.Next(); ok {
+			t.Error("expected Next to stop at the corrupt block")
+		}
+		if r.Err() == nil {
+			t.Error("expected Err to report the corrupt block")
+		}
+	})
+}
+
+func TestOutOfOrder(t *testing.T) {
+	row := func(ts int64) map[string]any {
+		return map[string]any{"ts": ts, "price": float64(ts), "is_buy": ts%20 == 0}
+	}
+
+	newTable := func(t *testing.T, maxBlockSize int) *Table {
+		tbl, err := CreateTable(manifestSchema(), nil, t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		tbl.MaxBlockSize = maxBlockSize
+		tbl.UseDiskStorage = true
+		tbl.AllowOutOfOrder = true
+		return tbl
+	}
+
+	appendAll := func(t *testing.T, tbl *Table, times ...int64) {
+		for _, ts := range times {
+			if err := tbl.AppendRow(row(ts)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	t.Run("RejectedByDefault", func(t *testing.T) {
+		tbl := newTable(t, 10)
+		tbl.AllowOutOfOrder = false
+		appendAll(t, tbl, 20)
+
+		if err := tbl.AppendRow(row(10)); err == nil {
+			t.Error("expected an older row to be rejected")
+		}
+		if err := tbl.AppendBatch([]map[string]any{row(30), row(25)}); err == nil {
+			t.Error("expected a batch out of order to be rejected")
+		}
+		if err := tbl.AppendColumns(map[string]any{"ts": []int64{15}, "price": []float64{1}, "is_buy": []bool{true}}); err == nil {
+			t.Error("expected older columns to be rejected")
+		}
+	})
+
+	t.Run("SortedOnFlush", func(t *testing.T) {
+		tbl := newTable(t, 4)
+		appendAll(t, tbl, 30, 10, 20, 0)
+
+		if len(tbl.coldBlocks) != 1 {
+			t.Fatalf("expected 1 flushed block, got %d", len(tbl.coldBlocks))
+		}
+		b := tbl.coldBlocks[0]
+		if b.MinTs != 0 || b.MaxTs != 30 || b.FloatMin[0] != 0 || b.FloatMax[0] != 30 {
+			t.Errorf("expected the block to cover ts 0 to 30, got %d to %d", b.MinTs, b.MaxTs)
+		}
+		checkTimes(t, scanTimes(t, tbl.Reader()), 4)
+	})
+
+	t.Run("LateRows", func(t *testing.T) {
+		tbl := newTable(t, 4)
+		appendAll(t, tbl, 0, 10, 20, 30, 40, 50, 60, 70)
+
+		// two late rows and two new ones fill the active block
+		appendAll(t, tbl, 80, 35, 15, 90)
+
+		if len(tbl.coldBlocks) != 4 {
+			t.Fatalf("expected the late rows in a block of their own, got %d blocks", len(tbl.coldBlocks))
+		}
+		late, fresh := tbl.coldBlocks[1], tbl.coldBlocks[3]
+		if late.RowCount != 2 || late.MinTs != 15 || late.MaxTs != 35 {
+			t.Errorf("expected a late block of 2 rows from ts 15 to 35 after the first block, got %d rows from %d to %d", late.RowCount, late.MinTs, late.MaxTs)
+		}
+		if fresh.RowCount != 2 || fresh.MinTs != 80 || fresh.MaxTs != 90 {
+			t.Errorf("expected the newest block to keep ts 80 to 90, got %d to %d", fresh.MinTs, fresh.MaxTs)
+		}
+
+		if n := len(scanTimes(t, tbl.Reader())); n != 12 {
+			t.Errorf("expected 12 rows, got %d", n)
+		}
+
+		times := scanTimes(t, tbl.Reader().OrderByTime())
+		want := []int64{0, 10, 15, 20, 30, 35, 40, 50, 60, 70, 80, 90}
+		if fmt.Sprint(times) != fmt.Sprint(want) {
+			t.Errorf("expected %v, got %v", want, times)
+		}
+
+		// pruning still applies to the blocks of a group
+		times = scanTimes(t, tbl.Reader().Filter("ts", ">", int64(32)).Filter("ts", "<", int64(45)).OrderByTime())
+		if fmt.Sprint(times) != "[35 40]" {
+			t.Errorf("expected [35 40], got %v", times)
+		}
+	})
+
+	t.Run("ActiveBlock", func(t *testing.T) {
+		tbl := newTable(t, 100)
+		appendAll(t, tbl, 40, 0, 30)
+		if err := tbl.AppendBatch([]map[string]any{row(20), row(10)}); err != nil {
+			t.Fatal(err)
+		}
+		err := tbl.AppendColumns(map[string]any{
+			"ts":     []int64{60, 50},
+			"price":  []float64{60, 50},
+			"is_buy": []bool{true, false},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		checkTimes(t, scanTimes(t, tbl.Reader().OrderByTime()), 7)
+
+		// the time column is read for sorting even when it is not selected
+		r := tbl.Reader().Select("price").Filter("is_buy", "==", true).OrderByTime()
+		var prices []float64
+		for {
+			row, ok := r.Next()
+			if !ok {
+				break
+			}
+			if _, ok := row["ts"]; ok {
+				t.Fatal("expected only the selected column")
+			}
+			prices = append(prices, row["price"].(float64))
+		}
+		if r.Err() != nil || fmt.Sprint(prices) != "[0 20 40 60]" {
+			t.Errorf("expected prices [0 20 40 60], got %v (%v)", prices, r.Err())
+		}
+
+		r = tbl.Reader()
+		r.Next()
+		if r.OrderByTime().Err() == nil {
+			t.Error("expected OrderByTime to fail once the scan started")
+		}
+	})
+
+	t.Run("Compaction", func(t *testing.T) {
+		tbl := newTable(t, 4)
+		appendAll(t, tbl, 0, 10, 20, 30, 40, 50, 60, 70, 80, 35, 15, 90)
+
+		if _, err := tbl.Compact(CompactionPolicy{}); err != nil {
+			t.Fatal(err)
+		}
+
+		// the late block and the blocks it overlaps become blocks of up to 4 rows
+		for i := 1; i < len(tbl.coldBlocks); i++ {
+			if prev, b := tbl.coldBlocks[i-1], tbl.coldBlocks[i]; b.MinTs < prev.MaxTs {
+				t.Errorf("expected no overlap after compaction, block %d starts at %d before %d", i, b.MinTs, prev.MaxTs)
+			}
+		}
+		for _, b := range tbl.coldBlocks {
+			if b.RowCount > 4 {
+				t.Errorf("expected blocks of at most 4 rows, got %d", b.RowCount)
+			}
+		}
+
+		times := scanTimes(t, tbl.Reader())
+		want := []int64{0, 10, 15, 20, 30, 35, 40, 50, 60, 70, 80, 90}
+		if fmt.Sprint(times) != fmt.Sprint(want) {
+			t.Errorf("expected compaction to restore time order, got %v", times)
+		}
+	})
+
+	t.Run("Reload", func(t *testing.T) {
+		tbl := newTable(t, 4)
+		appendAll(t, tbl, 0, 10, 20, 30, 40, 50, 60, 70, 80, 35, 15, 90, 5)
+		if err := tbl.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		reloaded, err := reload(t, tbl.dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		times := scanTimes(t, reloaded.Reader().OrderByTime())
+		want := []int64{0, 5, 10, 15, 20, 30, 35, 40, 50, 60, 70, 80, 90}
+		if fmt.Sprint(times) != fmt.Sprint(want) {
+			t.Errorf("expected %v, got %v", want, times)
+		}
+	})
+}
+
+func TestDetach(t *testing.T) {
+	t.Run("RefusesReaders", func(t *testing.T) {
+		tbl, _ := smallBlocks(t)
+
+		r := tbl.Reader()
+		if err := tbl.Detach(false); err == nil {
+			t.Fatal("expected an open reader to keep the table attached")
+		}
+		r.Close()
+
+		if err := tbl.Detach(false); err != nil {
+			t.Fatal(err)
+		}
+		if err := tbl.AppendRow(map[string]any{"ts": int64(100), "price": 1.0, "is_buy": true}); err == nil {
+			t.Error("expected appends to fail once detached")
+		}
+		if _, ok := tbl.Reader().Next(); ok {
+			t.Error("expected a reader of a detached table to return nothing")
+		}
+		if err := tbl.Reader().Err(); err == nil {
+			t.Error("expected a reader of a detached table to report it")
+		}
+	})
+
+	t.Run("Persist", func(t *testing.T) {
+		tbl, dir := smallBlocks(t)
+		if err := tbl.AppendRow(map[string]any{"ts": int64(100), "price": 1.0, "is_buy": true}); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := tbl.Detach(true); err != nil {
+			t.Fatal(err)
+		}
+		if files := blockFiles(t, dir); len(files) != 6 {
+			t.Errorf("expected the active block to be written, found %d files", len(files))
+		}
+	})
+}
+
+func TestClose(t *testing.T) {
+	tbl, _ := smallBlocks(t)
+	if err := tbl.AppendRow(map[string]any{"ts": int64(100), "price": 1.0, "is_buy": true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tbl.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the active block is on disk, appending to it used to panic
+	row := map[string]any{"ts": int64(110), "price": 1.0, "is_buy": true}
+	if err := tbl.AppendRow(row); err == nil {
+		t.Error("expected AppendRow to fail once closed")
+	}
+	if err := tbl.AppendBatch([]map[string]any{row}); err == nil {
+		t.Error("expected AppendBatch to fail once closed")
+	}
+	if err := tbl.AppendColumns(map[string]any{"ts": []int64{110}, "price": []float64{1}, "is_buy": []bool{true}}); err == nil {
+		t.Error("expected AppendColumns to fail once closed")
+	}
+	if tbl.wal != nil {
+		t.Error("expected the WAL to stay closed")
+	}
+
+	if times := scanTimes(t, tbl.Reader()); len(times) != 11 {
+		t.Errorf("expected the 11 rows to stay readable, got %v", times)
+	}
+	if err := tbl.Close(); err != nil {
+		t.Errorf("expected a second Close to do nothing, got %v", err)
+	}
+}
@@ -0,0 +1,257 @@
+package wal
+
+import (
+	"fmt"
+	"time"
+)
+
+// SyncMode decides when records written to the log are fsynced.
+type SyncMode int
+
+const (
+	// SyncNone leaves flushing to the OS, a crash of the machine (not only the
+	// process) can lose records that were acknowledged
+	SyncNone SyncMode = iota
+
+	// SyncAlways fsyncs every record before the append returns
+	SyncAlways
+
+	// SyncEveryN fsyncs once N records have been written since the last fsync
+	SyncEveryN
+
+	// SyncInterval fsyncs from a background goroutine every Interval
+	SyncInterval
+
+	// SyncGroupCommit makes every append wait until its record is fsynced, but
+	// appends that arrive while an fsync is running share the next one
+	SyncGroupCommit
+)
+
+func (m SyncMode) String() string {
+	switch m {
+	case SyncNone:
+		return "none"
+	case SyncAlways:
+		return "always"
+	case SyncEveryN:
+		return "every-n"
+	case SyncInterval:
+		return "interval"
+	case SyncGroupCommit:
+		return "group-commit"
+	}
+	return fmt.Sprintf("SyncMode(%d)", int(m))
+}
+
+// SyncPolicy is the durability policy of a log. The zero value is SyncNone.
+type SyncPolicy struct {
+	Mode     SyncMode
+	N        int           // records per fsync for SyncEveryN
+	Interval time.Duration // time between fsyncs for SyncInterval
+}
+
+func (p SyncPolicy) validate() error {
+	switch p.Mode {
+	case SyncNone, SyncAlways, SyncGroupCommit:
+	case SyncEveryN:
+		if p.N <= 0 {
+			return fmt.Errorf("sync policy %s needs N > 0, got %d", p.Mode, p.N)
+		}
+	case SyncInterval:
+		if p.Interval <= 0 {
+			return fmt.Errorf("sync policy %s needs a positive interval, got %v", p.Mode, p.Interval)
+		}
+	default:
+		return fmt.Errorf("unknown sync mode %d", int(p.Mode))
+	}
+	return nil
+}
+
+// SetSyncPolicy switches the durability policy of the log, starting or stopping the
+// background syncer as needed.
+func (w *WAL) SetSyncPolicy(p SyncPolicy) error {
+	if err := p.validate(); err != nil {
+		return err
+	}
+
+	w.stopSyncer()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.policy = p
+
+	if p.Mode == SyncInterval {
+		w.stop = make(chan struct{})
+		w.stopped = make(chan struct{})
+		go w.runSyncer(p.Interval, w.stop, w.stopped)
+	}
+
+	return nil
+}
+
+// SyncPolicy returns the durability policy of the log.
+func (w *WAL) SyncPolicy() SyncPolicy {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.policy
+}
+
+func (w *WAL) runSyncer(interval time.Duration, stop, stopped chan struct{}) {
+	defer close(stopped)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			// a failed background fsync is retried on the next tick, records stay
+			// marked as not synced until one succeeds
+			_ = w.syncUnlocked()
+		}
+	}
+}
+
+// stopSyncer stops the background syncer if one runs, callers must not hold w.mu
+func (w *WAL) stopSyncer() {
+	w.mu.Lock()
+	stop, stopped := w.stop, w.stopped
+	w.stop, w.stopped = nil, nil
+	w.mu.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-stopped
+	}
+}
+
+// Unsynced returns how many records were written but not fsynced yet, the records a
+// power failure could lose right now.
+func (w *WAL) Unsynced() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.written - w.synced
+}
+
+// Sync fsyncs every record written so far, whatever the policy.
+func (w *WAL) Sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.syncLocked()
+}
+
+// syncLocked fsyncs the log if records were written since the last fsync, callers must
+// hold w.mu
+func (w *WAL) syncLocked() error {
+	if w.synced == w.written {
+		return nil
+	}
+	if w.file == nil {
+		return fmt.Errorf("wal is closed")
+	}
+
+	target := w.written
+	if err := w.file.Sync(); err != nil {
+		return err
+	}
+	w.synced = target
+
+	return nil
+}
+
+// syncUnlocked is syncLocked for callers that do not hold w.mu. The fsync runs without
+// it, so appends keep writing meanwhile and only wait for the fsync they asked for.
+func (w *WAL) syncUnlocked() error {
+	w.mu.Lock()
+	target, file, synced := w.written, w.file, w.synced
+	w.mu.Unlock()
+
+	if synced == target {
+		return nil
+	}
+
+	err := fmt.Errorf("wal is closed")
+	if file != nil {
+		err = file.Sync()
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// a new segment or Close syncs the file before closing it, so a failure on a file
+	// closed meanwhile is no failure when its records got synced that way
+	if w.synced >= target {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	w.synced = target
+	return nil
+}
+
+// applyPolicy runs the fsync a policy asks for right after a record is written,
+// callers must hold w.mu
+func (w *WAL) applyPolicy() error {
+	switch w.policy.Mode {
+	case SyncAlways:
+		return w.syncLocked()
+	case SyncEveryN:
+		if w.written-w.synced >= uint64(w.policy.N) {
+			return w.syncLocked()
+		}
+	}
+	return nil
+}
+
+// WaitDurable blocks until the record with sequence number seq, as returned by the
+// Write methods, is durable under the policy of the log. Only SyncGroupCommit waits,
+// the other policies have done all they promise once the write returned. The first
+// waiter fsyncs on behalf of everyone, callers arriving meanwhile wait for the next
+// fsync, which then covers all of their records at once.
+func (w *WAL) WaitDurable(seq uint64) error {
+	w.mu.Lock()
+	mode := w.policy.Mode
+	w.mu.Unlock()
+
+	if mode != SyncGroupCommit {
+		return nil
+	}
+
+	w.commitMu.Lock()
+	defer w.commitMu.Unlock()
+
+	for {
+		w.mu.Lock()
+		durable := w.synced >= seq
+		w.mu.Unlock()
+
+		if durable {
+			return nil
+		}
+
+		if w.syncing {
+			w.commitCond.Wait()
+			continue
+		}
+
+		w.syncing = true
+		w.commitMu.Unlock()
+
+		err := w.syncUnlocked()
+
+		w.commitMu.Lock()
+		w.syncing = false
+		w.commitCond.Broadcast()
+
+		if err != nil {
+			return err
+		}
+	}
+}
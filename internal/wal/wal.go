@@ -3,50 +3,305 @@
 package wal
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"math"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"backtraceDB/internal/schema"
 )
 
+// every record is framed as [payload length][crc32c of payload][payload] and every
+// payload starts with the LSN of the record
+const recordHeaderSize = 8
+const lsnSize = 8
+
+// a payload always holds at least the LSN, the schema version and the row count
+const minPayloadSize = lsnSize + 8
+
+// segments are named after the LSN of their first record
+const segmentExt = ".wal"
+
+// defaultSegmentSize is the size after which the log moves on to a new segment
+const defaultSegmentSize = 64 << 20
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// CorruptionError reports a record in the middle of the log that fails its checksum.
+// Unlike a torn tail it cannot be explained by a crash during the last write, so
+// replay stops instead of dropping the rows that follow it.
+type CorruptionError struct {
+	Path   string
+	Offset int64
+	Reason string
+}
+
+func (e *CorruptionError) Error() string {
+	return fmt.Sprintf("wal %s: corrupt record at offset %d: %s", e.Path, e.Offset, e.Reason)
+}
+
+// ReplayResult describes what ReplayTable found in the log.
+type ReplayResult struct {
+	Records int
+	Rows    int
+
+	// Discarded is the size of the torn tail that was truncated when the log was
+	// opened, a record that was being written when the process died
+	Discarded int64
+}
+
+// RowLoader receives the rows of one WAL record at a time during replay, along with
+// the LSN of the record.
 type RowLoader interface {
-	LoadRowNoWAL(row map[string]any) error
+	LoadBatchNoWAL(rows []map[string]any, lsn uint64) error
 }
 
+// WAL is a log split into segment files under one directory. Every record gets an LSN
+// one above the previous one, and a segment only ever holds records from the LSN in
+// its name up to the first LSN of the next segment. Records that made it into a
+// flushed block are dropped a whole segment at a time by Checkpoint.
 type WAL struct {
 	mu     sync.Mutex
-	file   *os.File
-	path   string
+	file   *os.File // the newest segment, the only one written to
+	dir    string
 	schema schema.Schema
+
+	// every schema version a record in the log may have been written with
+	schemas map[int]schema.Schema
+
+	segments    []uint64 // first LSN of every segment on disk, oldest first
+	segmentSize int64    // size of the newest segment
+	maxSegment  int64    // size after which a new segment is started
+	discarded   int64    // torn tail cut off when the log was opened
+
+	policy  SyncPolicy
+	written uint64 // LSN of the last record written, LSNs double as sequence numbers
+	synced  uint64 // LSN up to which records are known to be on stable storage
+
+	// the background syncer of SyncInterval
+	stop    chan struct{}
+	stopped chan struct{}
+
+	// group commit, syncing is set while a waiter fsyncs for everyone else
+	commitMu   sync.Mutex
+	commitCond *sync.Cond
+	syncing    bool
 }
 
-func NewWAL(path string, schema schema.Schema) (*WAL, error) {
+// NewWAL opens the log under path. New records are encoded with s; history holds the
+// older versions of the table schema so records written before an alter still decode.
+// The newest segment is scanned to find the last LSN, a torn tail left by a crash is
+// cut off and reported by ReplayTable.
+func NewWAL(path string, s schema.Schema, history ...schema.Schema) (*WAL, error) {
 
 	if err := os.MkdirAll(path, 0755); err != nil {
 		return nil, err
 	}
 
-	p := filepath.Join(path, "wal.dat")
+	segments, err := listSegments(path)
+	if err != nil {
+		return nil, err
+	}
 
-	f, err := os.OpenFile(p, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	schemas := make(map[int]schema.Schema, len(history)+1)
+	for _, old := range history {
+		schemas[old.Version] = old
+	}
+	schemas[s.Version] = s
+
+	w := &WAL{
+		dir:        path,
+		schema:     s,
+		schemas:    schemas,
+		maxSegment: defaultSegmentSize,
+	}
+	w.commitCond = sync.NewCond(&w.commitMu)
+
+	if len(segments) == 0 {
+		if err := w.startSegment(1); err != nil {
+			return nil, err
+		}
+		return w, nil
+	}
 
+	if err := w.openNewest(segments); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// Exists reports whether path holds a log with at least one segment.
+func Exists(path string) (bool, error) {
+	segments, err := listSegments(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return len(segments) > 0, err
+}
+
+func segmentPath(dir string, first uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%020d%s", first, segmentExt))
+}
+
+// listSegments returns the first LSN of every segment in dir, oldest first.
+func listSegments(dir string) ([]uint64, error) {
+	entries, err := os.ReadDir(dir)
 	if err != nil {
 		return nil, err
 	}
 
-	return &WAL{
-		file:   f,
-		path:   p,
-		schema: schema,
-	}, nil
+	var segments []uint64
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		first, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, first)
+	}
+
+	sort.Slice(segments, func(i, j int) bool { return segments[i] < segments[j] })
+	return segments, nil
+}
+
+// openNewest reopens the newest of segments for appending. Older segments were synced
+// before the log moved past them, so only the newest one can end in a torn record.
+func (w *WAL) openNewest(segments []uint64) error {
+	first := segments[len(segments)-1]
+	path := segmentPath(w.dir, first)
+
+	last := first - 1
+	end, torn, err := scanSegment(path, func(lsn uint64, _ []byte, _ int64) error {
+		last = lsn
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	if torn {
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Truncate(end); err != nil {
+			f.Close()
+			return fmt.Errorf("failed to truncate torn wal tail: %v", err)
+		}
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return err
+		}
+		w.discarded = info.Size() - end
+	}
+
+	w.file = f
+	w.segments = segments
+	w.segmentSize = end
+	w.written = last
+	w.synced = last
+
+	return nil
+}
+
+// startSegment closes the newest segment and starts a new one whose first record gets
+// LSN first, callers must hold w.mu and sync the old segment if its records matter
+func (w *WAL) startSegment(first uint64) error {
+	if w.file != nil {
+		if err := w.file.Close(); err != nil {
+			return err
+		}
+		w.file = nil
+	}
+
+	f, err := os.OpenFile(segmentPath(w.dir, first), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	// the segment name carries the LSN forward, so it has to survive a crash even
+	// while the segment is still empty
+	if err := syncDir(w.dir); err != nil {
+		f.Close()
+		return err
+	}
+
+	w.file = f
+	w.segments = append(w.segments, first)
+	w.segmentSize = 0
+
+	return nil
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
+
+// SetSegmentSize sets the size after which the log starts a new segment.
+func (w *WAL) SetSegmentSize(size int64) error {
+	if size <= 0 {
+		return fmt.Errorf("segment size must be positive, got %d", size)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.maxSegment = size
+	return nil
+}
+
+// Segments returns the first LSN of every segment of the log, oldest first.
+func (w *WAL) Segments() []uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return append([]uint64(nil), w.segments...)
+}
+
+// LastLSN returns the LSN of the last record written to the log.
+func (w *WAL) LastLSN() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.written
+}
+
+// SetSchema switches the schema new records are encoded with after an alter.
+func (w *WAL) SetSchema(s schema.Schema) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.schemas[s.Version] = s
+	w.schema = s
 }
 
 func (w *WAL) Close() error {
+	w.stopSyncer()
+
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
@@ -54,6 +309,12 @@ func (w *WAL) Close() error {
 		return nil
 	}
 
+	if w.policy.Mode != SyncNone {
+		if err := w.syncLocked(); err != nil {
+			return err
+		}
+	}
+
 	if err := w.file.Close(); err != nil {
 		return err
 	}
@@ -63,96 +324,246 @@ func (w *WAL) Close() error {
 	return nil
 }
 
-// encode rows first before dumping them into wal log
-func (w *WAL) encodeRow(row map[string]any) ([]byte, error) {
+// encode rows first before dumping them into wal log, every record starts with the
+// schema version it was encoded with and the number of rows it holds. A batch is a
+// single record so it is replayed whole or not at all.
+func (w *WAL) encodeRecord(n int, value func(row int, col string) (any, bool)) ([]byte, error) {
 	var buf bytes.Buffer
 
-	for _, col := range w.schema.Columns {
-		val, ok := row[col.Name]
-		if !ok {
-			return nil, fmt.Errorf("column %s not found in row", col.Name)
-		}
-
-		switch col.Type {
-		case schema.Int64:
-			v, ok := val.(int64)
-			if !ok {
-				return nil, fmt.Errorf("column %s must be of type int64", col.Name)
-			}
-			err := binary.Write(&buf, binary.LittleEndian, v)
-			if err != nil {
-				return nil, err
-			}
-		case schema.Float64:
-			v, ok := val.(float64)
-			bits := math.Float64bits(v)
-			if !ok {
-				return nil, fmt.Errorf("column %s must be of type float64", col.Name)
-			}
-			err := binary.Write(&buf, binary.LittleEndian, bits)
-			if err != nil {
-				return nil, err
-			}
-		case schema.String:
-			v, ok := val.(string)
-			if !ok {
-				return nil, fmt.Errorf("column %s must be of type string", col.Name)
-			}
+	if err := binary.Write(&buf, binary.LittleEndian, uint32(w.schema.Version)); err != nil {
+		return nil, err
+	}
 
-			bs := []byte(v)
+	if err := binary.Write(&buf, binary.LittleEndian, uint32(n)); err != nil {
+		return nil, err
+	}
 
-			if err := binary.Write(&buf, binary.LittleEndian, uint32(len(bs))); err != nil {
+	for i := 0; i < n; i++ {
+		for _, col := range w.schema.Columns {
+			val, ok := value(i, col.Name)
+			if err := encodeValue(&buf, col, val, ok); err != nil {
 				return nil, err
 			}
+		}
+	}
 
-			if _, err := buf.Write(bs); err != nil {
-				return nil, err
-			}
+	return buf.Bytes(), nil
+}
 
-		default:
-			return nil, fmt.Errorf("unsupported column type: %v", col.Type)
+func encodeValue(buf *bytes.Buffer, col schema.Column, val any, ok bool) error {
+	// nullable columns carry a marker byte, 0 for null and 1 when a value follows
+	if col.Nullable {
+		if !ok || val == nil {
+			buf.WriteByte(0)
+			return nil
 		}
+		buf.WriteByte(1)
+	} else if !ok {
+		return fmt.Errorf("column %s not found in row", col.Name)
 	}
 
-	return buf.Bytes(), nil
+	switch col.Type {
+	case schema.Int64:
+		v, ok := val.(int64)
+		if !ok {
+			return fmt.Errorf("column %s must be of type int64", col.Name)
+		}
+		return binary.Write(buf, binary.LittleEndian, v)
+	case schema.Float64:
+		v, ok := val.(float64)
+		if !ok {
+			return fmt.Errorf("column %s must be of type float64", col.Name)
+		}
+		return binary.Write(buf, binary.LittleEndian, math.Float64bits(v))
+	case schema.String:
+		v, ok := val.(string)
+		if !ok {
+			return fmt.Errorf("column %s must be of type string", col.Name)
+		}
+
+		bs := []byte(v)
+
+		if err := binary.Write(buf, binary.LittleEndian, uint32(len(bs))); err != nil {
+			return err
+		}
+
+		_, err := buf.Write(bs)
+		return err
+	case schema.Boolean:
+		v, ok := val.(bool)
+		if !ok {
+			return fmt.Errorf("column %s must be of type bool", col.Name)
+		}
+
+		var b byte
+		if v {
+			b = 1
+		}
+
+		return buf.WriteByte(b)
+	default:
+		return fmt.Errorf("unsupported column type: %v", col.Type)
+	}
 }
 
+// AppendRow writes row as a record and returns once it is durable under the sync
+// policy of the log.
 func (w *WAL) AppendRow(row map[string]any) error {
-	payload, err := w.encodeRow(row)
+	return w.AppendBatch([]map[string]any{row})
+}
+
+// AppendBatch writes rows as a single record and returns once it is durable.
+func (w *WAL) AppendBatch(rows []map[string]any) error {
+	seq, err := w.WriteBatch(rows)
+	if err != nil {
+		return err
+	}
+	return w.WaitDurable(seq)
+}
+
+// AppendColumns writes n rows held column-wise as a single record and returns once it
+// is durable. cols maps column names to []int64, []float64, []string or []bool, a
+// missing column is null.
+func (w *WAL) AppendColumns(cols map[string]any, n int) error {
+	seq, err := w.WriteColumns(cols, n)
 	if err != nil {
 		return err
 	}
+	return w.WaitDurable(seq)
+}
 
+// WriteBatch is AppendBatch without the wait for group commit. It returns the sequence
+// number of the record to hand to WaitDurable, so callers can release their own locks
+// before waiting and let concurrent writers share an fsync.
+func (w *WAL) WriteBatch(rows []map[string]any) (uint64, error) {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
-	if w.file == nil {
-		return fmt.Errorf("wal is closed")
+	payload, err := w.encodeRecord(len(rows), func(row int, col string) (any, bool) {
+		val, ok := rows[row][col]
+		return val, ok
+	})
+	if err != nil {
+		return 0, err
 	}
 
-	if err := binary.Write(w.file, binary.LittleEndian, uint32(len(payload))); err != nil {
-		return err
+	return w.writeRecord(payload)
+}
+
+// WriteColumns is AppendColumns without the wait for group commit, see WriteBatch.
+func (w *WAL) WriteColumns(cols map[string]any, n int) (uint64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	payload, err := w.encodeRecord(n, func(row int, col string) (any, bool) {
+		switch vals := cols[col].(type) {
+		case []int64:
+			return vals[row], true
+		case []float64:
+			return vals[row], true
+		case []string:
+			return vals[row], true
+		case []bool:
+			return vals[row], true
+		case nil:
+			return nil, false
+		}
+		return cols[col], true
+	})
+	if err != nil {
+		return 0, err
 	}
 
-	if _, err := w.file.Write(payload); err != nil {
-		return err
+	return w.writeRecord(payload)
+}
+
+// writeRecord gives payload the next LSN, appends it as a framed record and applies the
+// sync policy, callers must hold w.mu. It returns the LSN of the record.
+func (w *WAL) writeRecord(payload []byte) (uint64, error) {
+	if w.file == nil {
+		return 0, fmt.Errorf("wal is closed")
 	}
 
-	//potential optimization by making sync interval based
-	// if err := w.file.Sync(); err != nil {
-	// 	return err
-	// }
+	lsn := w.written + 1
 
-	return nil
+	// the header and payload go out in one write so a record is never interleaved
+	record := make([]byte, recordHeaderSize+lsnSize+len(payload))
+	binary.LittleEndian.PutUint32(record, uint32(lsnSize+len(payload)))
+	binary.LittleEndian.PutUint64(record[recordHeaderSize:], lsn)
+	copy(record[recordHeaderSize+lsnSize:], payload)
+	binary.LittleEndian.PutUint32(record[4:], crc32.Checksum(record[recordHeaderSize:], castagnoli))
+
+	if _, err := w.file.Write(record); err != nil {
+		return 0, err
+	}
+	w.written = lsn
+	w.segmentSize += int64(len(record))
 
+	if err := w.applyPolicy(); err != nil {
+		return 0, err
+	}
+
+	if w.segmentSize >= w.maxSegment {
+		// only the newest segment may end in a torn record, so a full one is synced
+		// before the log moves on
+		if err := w.syncLocked(); err != nil {
+			return 0, err
+		}
+		if err := w.startSegment(lsn + 1); err != nil {
+			return 0, fmt.Errorf("failed to start wal segment: %v", err)
+		}
+	}
+
+	return lsn, nil
 }
 
-func (w *WAL) DecodePayload(payload []byte) (map[string]any, error) {
+// DecodePayload decodes a record using the schema version it was written with. The
+// rows have the columns of that version, callers upgrade them to the current schema.
+func (w *WAL) DecodePayload(payload []byte) ([]map[string]any, error) {
 	r := bytes.NewReader(payload)
 
-	out := make(map[string]any, len(w.schema.Columns))
+	var version uint32
+	if err := binary.Read(r, binary.LittleEndian, &version); err != nil {
+		return nil, err
+	}
+
+	s, ok := w.schemas[int(version)]
+	if !ok {
+		return nil, fmt.Errorf("wal record uses unknown schema version %d", version)
+	}
+
+	var n uint32
+	if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+		return nil, err
+	}
+
+	rows := make([]map[string]any, 0, n)
+	for i := uint32(0); i < n; i++ {
+		row, err := decodeRow(r, s)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+func decodeRow(r *bytes.Reader, s schema.Schema) (map[string]any, error) {
+	out := make(map[string]any, len(s.Columns))
+
+	for _, col := range s.Columns {
+		if col.Nullable {
+			marker, err := r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			if marker == 0 {
+				out[col.Name] = nil
+				continue
+			}
+		}
 
-	for _, col := range w.schema.Columns {
 		switch col.Type {
 		case schema.Int64:
 			var v int64
@@ -176,6 +587,12 @@ func (w *WAL) DecodePayload(payload []byte) (map[string]any, error) {
 				return nil, err
 			}
 			out[col.Name] = string(bs)
+		case schema.Boolean:
+			b, err := r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			out[col.Name] = b != 0
 		default:
 			return nil, fmt.Errorf("unsupported column type: %v", col.Type)
 		}
@@ -184,67 +601,221 @@ func (w *WAL) DecodePayload(payload []byte) (map[string]any, error) {
 	return out, nil
 }
 
-// potential optimization by making this faster
-func (w *WAL) ReplayTable(loader RowLoader) error {
+// ReplayTable feeds every record with an LSN above after to loader, oldest first.
+// Segments holding nothing above after are skipped without being read. A bad record is
+// reported as a *CorruptionError with its offset, the torn tail NewWAL cut off is only
+// reported in the result.
+func (w *WAL) ReplayTable(loader RowLoader, after uint64) (ReplayResult, error) {
 	w.mu.Lock()
-	defer w.mu.Unlock()
+	segments := append([]uint64(nil), w.segments...)
+	result := ReplayResult{Discarded: w.discarded}
+	closed := w.file == nil
+	w.mu.Unlock()
 
-	if w.file == nil {
-		return fmt.Errorf("wal is closed")
+	if closed {
+		return result, fmt.Errorf("wal is closed")
 	}
 
-	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
-		return err
-	}
+	// the log is not locked while the loader runs, a loader that flushes a block may
+	// checkpoint the log, which only drops segments that were replayed already
+	for i, first := range segments {
+		newest := i == len(segments)-1
+		if !newest && segments[i+1]-1 <= after {
+			continue
+		}
 
-	for {
-		var len uint32
-		if err := binary.Read(w.file, binary.LittleEndian, &len); err != nil {
-			if err == io.EOF {
+		path := segmentPath(w.dir, first)
+		end, torn, err := scanSegment(path, func(lsn uint64, payload []byte, offset int64) error {
+			if lsn <= after {
 				return nil
 			}
-			return err
+
+			rows, err := w.DecodePayload(payload)
+			if err != nil {
+				return fmt.Errorf("wal %s: failed to decode record at offset %d: %v", path, offset, err)
+			}
+
+			if err := loader.LoadBatchNoWAL(rows, lsn); err != nil {
+				return err
+			}
+
+			result.Records++
+			result.Rows += len(rows)
+			return nil
+		})
+		if err != nil {
+			return result, err
 		}
 
-		if len == 0 {
-			continue
+		if torn && !newest {
+			return result, &CorruptionError{Path: path, Offset: end, Reason: "segment ends in a partial record"}
 		}
+	}
 
-		payload := make([]byte, len)
-		if _, err := io.ReadFull(w.file, payload); err != nil {
-			return err
+	return result, nil
+}
+
+// scanSegment hands every valid record of the segment at path to fn, oldest first. It
+// returns the offset where the valid records end and whether the bytes after it are a
+// torn tail: a record cut short, left with a bad checksum or zero filled by a crash
+// while it was the last one being written. Any other bad record is returned as a
+// *CorruptionError.
+func scanSegment(path string, fn func(lsn uint64, payload []byte, offset int64) error) (int64, bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, false, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, false, err
+	}
+	size := info.Size()
+
+	r := bufio.NewReader(f)
+
+	var offset int64
+	header := make([]byte, recordHeaderSize)
+
+	for offset < size {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if err == io.ErrUnexpectedEOF || err == io.EOF {
+				return offset, true, nil
+			}
+			return offset, false, err
 		}
 
-		row, err := w.DecodePayload(payload)
-		if err != nil {
-			return err
+		length := int64(binary.LittleEndian.Uint32(header))
+		sum := binary.LittleEndian.Uint32(header[4:])
+		end := offset + recordHeaderSize + length
+
+		// a torn write leaves a short last record, a bad length with whole records after
+		// it is corruption and cutting the segment there would drop them
+		if end > size {
+			rest, err := io.ReadAll(r)
+			if err != nil {
+				return offset, false, err
+			}
+			if holdsRecord(rest) {
+				return offset, false, &CorruptionError{Path: path, Offset: offset, Reason: fmt.Sprintf("record length %d runs past the end of the segment", length)}
+			}
+			return offset, true, nil
 		}
 
-		if err := loader.LoadRowNoWAL(row); err != nil {
-			return err
+		if length < minPayloadSize {
+			// zero filled space after a crash also looks like this
+			if zeroTail, err := onlyZeros(r); err != nil {
+				return offset, false, err
+			} else if zeroTail && length == 0 && sum == 0 {
+				return offset, true, nil
+			}
+			return offset, false, &CorruptionError{Path: path, Offset: offset, Reason: fmt.Sprintf("invalid record length %d", length)}
+		}
+
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return offset, false, err
 		}
 
+		if crc32.Checksum(payload, castagnoli) != sum {
+			if end == size {
+				return offset, true, nil
+			}
+			return offset, false, &CorruptionError{Path: path, Offset: offset, Reason: "checksum mismatch"}
+		}
+
+		lsn := binary.LittleEndian.Uint64(payload)
+		if err := fn(lsn, payload[lsnSize:], offset); err != nil {
+			return offset, false, err
+		}
+
+		offset = end
 	}
+
+	return offset, false, nil
 }
 
-func (w *WAL) Reset() error {
+// holdsRecord reports whether a complete record with a matching checksum starts
+// anywhere in b.
+func holdsRecord(b []byte) bool {
+	for p := 0; p+recordHeaderSize+minPayloadSize <= len(b); p++ {
+		length := int(binary.LittleEndian.Uint32(b[p:]))
+		end := p + recordHeaderSize + length
+		if length < minPayloadSize || end > len(b) {
+			continue
+		}
+		if crc32.Checksum(b[p+recordHeaderSize:end], castagnoli) == binary.LittleEndian.Uint32(b[p+4:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// onlyZeros reports whether r holds nothing but zero bytes until EOF.
+func onlyZeros(r io.Reader) (bool, error) {
+	buf := make([]byte, 4096)
+	for {
+		n, err := r.Read(buf)
+		for _, b := range buf[:n] {
+			if b != 0 {
+				return false, nil
+			}
+		}
+		if err == io.EOF {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+}
+
+// Checkpoint tells the log that every record up to lsn is stored in a flushed block.
+// Segments holding only such records are deleted, starting a new segment first when
+// the newest one is among them. Records written later get LSNs above lsn even when the
+// log itself never got that far, say because its directory was lost.
+func (w *WAL) Checkpoint(lsn uint64) error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
-	if w.file != nil {
-		if err := w.file.Close(); err != nil {
-			return err
+
+	return w.checkpointLocked(lsn)
+}
+
+func (w *WAL) checkpointLocked(lsn uint64) error {
+	if w.file == nil {
+		return fmt.Errorf("wal is closed")
+	}
+
+	if w.written <= lsn {
+		// the covered records are in a block by now, nobody waits on them
+		w.written = lsn
+		w.synced = lsn
+
+		if w.segments[len(w.segments)-1] <= lsn {
+			if err := w.startSegment(lsn + 1); err != nil {
+				return fmt.Errorf("failed to start wal segment: %v", err)
+			}
 		}
-		w.file = nil
 	}
-	if err := os.Remove(w.path); err != nil {
-		if !os.IsNotExist(err) {
+
+	keep := 0
+	for keep < len(w.segments)-1 && w.segments[keep+1]-1 <= lsn {
+		if err := os.Remove(segmentPath(w.dir, w.segments[keep])); err != nil && !os.IsNotExist(err) {
+			w.segments = w.segments[keep:]
 			return err
 		}
+		keep++
 	}
-	f, err := os.OpenFile(w.path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
-	if err != nil {
-		return err
-	}
-	w.file = f
+	w.segments = w.segments[keep:]
+
 	return nil
 }
+
+// Reset drops every record written so far.
+func (w *WAL) Reset() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.checkpointLocked(w.written)
+}
@@ -4,8 +4,13 @@ import (
 	"backtraceDB/internal/schema"
 	"backtraceDB/internal/table"
 	"backtraceDB/internal/wal"
+	"encoding/binary"
+	"errors"
 	"os"
+	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestWAL(t *testing.T) {
@@ -39,8 +44,8 @@ func TestWAL(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		tbl, _ := table.CreateTable(s, w, "test_db")
-		if err := w.ReplayTable(tbl); err != nil {
+		tbl, _ := table.CreateTable(s, w, t.TempDir())
+		if _, err := w.ReplayTable(tbl, 0); err != nil {
 			t.Fatal(err)
 		}
 
@@ -66,8 +71,8 @@ func TestWAL(t *testing.T) {
 		}
 
 		// 3. Replay into fresh table - should be empty
-		tbl, _ := table.CreateTable(s, w, "test_db")
-		if err := w.ReplayTable(tbl); err != nil {
+		tbl, _ := table.CreateTable(s, w, t.TempDir())
+		if _, err := w.ReplayTable(tbl, 0); err != nil {
 			t.Fatal(err)
 		}
 		if tbl.RowCount() != 0 {
@@ -78,12 +83,668 @@ func TestWAL(t *testing.T) {
 		w.AppendRow(map[string]any{"ts": int64(2), "val": 2.2})
 
 		// 5. Replay again - should have only the new row
-		tbl2, _ := table.CreateTable(s, w, "test_db")
-		if err := w.ReplayTable(tbl2); err != nil {
+		tbl2, _ := table.CreateTable(s, w, t.TempDir())
+		if _, err := w.ReplayTable(tbl2, 0); err != nil {
 			t.Fatal(err)
 		}
 		if tbl2.RowCount() != 1 {
 			t.Errorf("Expected 1 row after append-post-reset, got %d", tbl2.RowCount())
 		}
 	})
+
+	t.Run("BooleanRoundTrip", func(t *testing.T) {
+		defer os.RemoveAll(tmpDir)
+
+		bs := schema.Schema{
+			Name:       "bool_table",
+			TimeColumn: "ts",
+			Columns: []schema.Column{
+				{Name: "ts", Type: schema.Int64},
+				{Name: "is_buy", Type: schema.Boolean},
+			},
+		}
+
+		w, err := wal.NewWAL(tmpDir, bs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer w.Close()
+
+		if err := w.AppendRow(map[string]any{"ts": int64(1), "is_buy": true}); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.AppendRow(map[string]any{"ts": int64(2), "is_buy": false}); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.AppendRow(map[string]any{"ts": int64(3), "is_buy": 1}); err == nil {
+			t.Error("expected an error for a non-bool value in a bool column")
+		}
+
+		tbl, _ := table.CreateTable(bs, w, t.TempDir())
+		if _, err := w.ReplayTable(tbl, 0); err != nil {
+			t.Fatal(err)
+		}
+
+		r := tbl.Reader()
+		expected := []bool{true, false}
+		for i, want := range expected {
+			row, ok := r.Next()
+			if !ok {
+				t.Fatalf("expected %d rows after replay, got %d", len(expected), i)
+			}
+			if row["is_buy"] != want {
+				t.Errorf("row %d: expected is_buy %v, got %v", i, want, row["is_buy"])
+			}
+		}
+	})
+
+	t.Run("NullRoundTrip", func(t *testing.T) {
+		defer os.RemoveAll(tmpDir)
+
+		ns := schema.Schema{
+			Name:       "null_table",
+			TimeColumn: "ts",
+			Columns: []schema.Column{
+				{Name: "ts", Type: schema.Int64},
+				{Name: "venue", Type: schema.String, Nullable: true},
+			},
+		}
+
+		w, err := wal.NewWAL(tmpDir, ns)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer w.Close()
+
+		rows := []map[string]any{
+			{"ts": int64(1), "venue": "X"},
+			{"ts": int64(2), "venue": nil},
+			{"ts": int64(3)},
+		}
+		for _, row := range rows {
+			if err := w.AppendRow(row); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		tbl, _ := table.CreateTable(ns, w, t.TempDir())
+		if _, err := w.ReplayTable(tbl, 0); err != nil {
+			t.Fatal(err)
+		}
+
+		r := tbl.Reader()
+		expected := []any{"X", nil, nil}
+		for i, want := range expected {
+			row, ok := r.Next()
+			if !ok {
+				t.Fatalf("expected %d rows after replay, got %d", len(expected), i)
+			}
+			if row["venue"] != want {
+				t.Errorf("row %d: expected venue %v, got %v", i, want, row["venue"])
+			}
+		}
+	})
+
+	t.Run("BatchRecords", func(t *testing.T) {
+		defer os.RemoveAll(tmpDir)
+
+		w, err := wal.NewWAL(tmpDir, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer w.Close()
+
+		batch := []map[string]any{
+			{"ts": int64(1), "val": 1.0},
+			{"ts": int64(2), "val": 2.0},
+			{"ts": int64(3), "val": 3.0},
+		}
+		if err := w.AppendBatch(batch); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.AppendColumns(map[string]any{"ts": []int64{4, 5}, "val": []float64{4.0, 5.0}}, 2); err != nil {
+			t.Fatal(err)
+		}
+
+		loader := &batchRecorder{}
+		if _, err := w.ReplayTable(loader, 0); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(loader.batches) != 2 || len(loader.batches[0]) != 3 || len(loader.batches[1]) != 2 {
+			t.Fatalf("expected records of 3 and 2 rows, got %v", loader.batches)
+		}
+		if loader.batches[1][1]["val"] != 5.0 {
+			t.Errorf("expected val 5.0 in the last row, got %v", loader.batches[1][1]["val"])
+		}
+	})
+
+	t.Run("SchemaVersions", func(t *testing.T) {
+		defer os.RemoveAll(tmpDir)
+
+		v1, err := s.Apply(schema.AddColumn(schema.Column{Name: "side", Type: schema.String, Default: "buy"}))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w, err := wal.NewWAL(tmpDir, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer w.Close()
+
+		if err := w.AppendRow(map[string]any{"ts": int64(1), "val": 1.1}); err != nil {
+			t.Fatal(err)
+		}
+
+		w.SetSchema(v1)
+
+		if err := w.AppendRow(map[string]any{"ts": int64(2), "val": 2.2, "side": "sell"}); err != nil {
+			t.Fatal(err)
+		}
+
+		// a fresh WAL only decodes the old record if it is given the history
+		reopened, err := wal.NewWAL(tmpDir, v1, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer reopened.Close()
+
+		tbl, _ := table.CreateTable(v1, reopened, t.TempDir(), s)
+		if _, err := reopened.ReplayTable(tbl, 0); err != nil {
+			t.Fatal(err)
+		}
+
+		r := tbl.Reader()
+		for _, want := range []string{"buy", "sell"} {
+			row, ok := r.Next()
+			if !ok {
+				t.Fatal("expected 2 rows after replay")
+			}
+			if row["side"] != want {
+				t.Errorf("expected side %s, got %v", want, row["side"])
+			}
+		}
+	})
+}
+
+func TestWALRecovery(t *testing.T) {
+	s := schema.Schema{
+		Name:       "test_table",
+		TimeColumn: "ts",
+		Columns: []schema.Column{
+			{Name: "ts", Type: schema.Int64},
+			{Name: "val", Type: schema.Float64},
+		},
+	}
+
+	// writes three single row records and returns the segment path and the record size
+	setup := func(t *testing.T) (string, string, int64) {
+		dir := t.TempDir()
+		w, err := wal.NewWAL(dir, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 3; i++ {
+			if err := w.AppendRow(map[string]any{"ts": int64(i), "val": float64(i)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		w.Close()
+
+		path := filepath.Join(dir, "00000000000000000001.wal")
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return dir, path, info.Size() / 3
+	}
+
+	replay := func(t *testing.T, dir string) (*batchRecorder, wal.ReplayResult, error) {
+		w, err := wal.NewWAL(dir, s)
+		if err != nil {
+			return nil, wal.ReplayResult{}, err
+		}
+		defer w.Close()
+
+		loader := &batchRecorder{}
+		result, err := w.ReplayTable(loader, 0)
+		return loader, result, err
+	}
+
+	corrupt := func(t *testing.T, path string, edit func([]byte) []byte) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, edit(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("TornTail", func(t *testing.T) {
+		dir, path, recordSize := setup(t)
+		corrupt(t, path, func(b []byte) []byte { return b[:len(b)-5] })
+
+		loader, result, err := replay(t, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(loader.batches) != 2 || result.Records != 2 {
+			t.Errorf("expected 2 records before the torn tail, got %d", len(loader.batches))
+		}
+		if result.Discarded != recordSize-5 {
+			t.Errorf("expected %d discarded bytes, got %d", recordSize-5, result.Discarded)
+		}
+
+		info, _ := os.Stat(path)
+		if info.Size() != 2*recordSize {
+			t.Errorf("expected the log to be truncated to %d bytes, got %d", 2*recordSize, info.Size())
+		}
+
+		// the log keeps working after the tail is cut
+		w, err := wal.NewWAL(dir, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.AppendRow(map[string]any{"ts": int64(9), "val": 9.0}); err != nil {
+			t.Fatal(err)
+		}
+		w.Close()
+
+		if loader, result, err := replay(t, dir); err != nil || len(loader.batches) != 3 || result.Discarded != 0 {
+			t.Errorf("expected 3 clean records after appending, got %d (discarded %d, err %v)", len(loader.batches), result.Discarded, err)
+		}
+	})
+
+	t.Run("BadChecksumAtTail", func(t *testing.T) {
+		dir, path, recordSize := setup(t)
+		corrupt(t, path, func(b []byte) []byte { b[len(b)-1] ^= 0xff; return b })
+
+		loader, result, err := replay(t, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(loader.batches) != 2 || result.Discarded != recordSize {
+			t.Errorf("expected the last record to be discarded, got %d records and %d bytes discarded", len(loader.batches), result.Discarded)
+		}
+	})
+
+	t.Run("ZeroFilledTail", func(t *testing.T) {
+		dir, path, _ := setup(t)
+		corrupt(t, path, func(b []byte) []byte { return append(b, make([]byte, 64)...) })
+
+		loader, result, err := replay(t, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(loader.batches) != 3 || result.Discarded != 64 {
+			t.Errorf("expected 3 records and 64 discarded bytes, got %d and %d", len(loader.batches), result.Discarded)
+		}
+	})
+
+	t.Run("CorruptMiddle", func(t *testing.T) {
+		dir, path, recordSize := setup(t)
+		corrupt(t, path, func(b []byte) []byte { b[recordSize+10] ^= 0xff; return b })
+
+		_, _, err := replay(t, dir)
+
+		var corruption *wal.CorruptionError
+		if !errors.As(err, &corruption) {
+			t.Fatalf("expected a CorruptionError, got %v", err)
+		}
+		if corruption.Offset != recordSize {
+			t.Errorf("expected the corruption at offset %d, got %d", recordSize, corruption.Offset)
+		}
+
+		info, _ := os.Stat(path)
+		if info.Size() != 3*recordSize {
+			t.Error("a corrupt middle record must not truncate the log")
+		}
+	})
+	t.Run("CorruptMiddleLength", func(t *testing.T) {
+		dir, path, recordSize := setup(t)
+		corrupt(t, path, func(b []byte) []byte { binary.LittleEndian.PutUint32(b[recordSize:], 1<<20); return b })
+
+		_, _, err := replay(t, dir)
+
+		var corruption *wal.CorruptionError
+		if !errors.As(err, &corruption) || corruption.Offset != recordSize {
+			t.Fatalf("expected a CorruptionError at offset %d, got %v", recordSize, err)
+		}
+
+		info, _ := os.Stat(path)
+		if info.Size() != 3*recordSize {
+			t.Error("a bad length with records after it must not truncate the log")
+		}
+	})
+}
+
+func TestSegments(t *testing.T) {
+	s := schema.Schema{
+		Name:       "test_table",
+		TimeColumn: "ts",
+		Columns: []schema.Column{
+			{Name: "ts", Type: schema.Int64},
+			{Name: "val", Type: schema.Float64},
+		},
+	}
+
+	// opens a log whose segments fill up after two records and writes n records
+	setup := func(t *testing.T, n int) (string, *wal.WAL) {
+		dir := t.TempDir()
+		w, err := wal.NewWAL(dir, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { w.Close() })
+
+		if err := w.AppendRow(map[string]any{"ts": int64(0), "val": 0.0}); err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(filepath.Join(dir, "00000000000000000001.wal"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.SetSegmentSize(2 * info.Size()); err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 1; i < n; i++ {
+			if err := w.AppendRow(map[string]any{"ts": int64(i), "val": float64(i)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return dir, w
+	}
+
+	equal := func(a, b []uint64) bool {
+		if len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if a[i] != b[i] {
+				return false
+			}
+		}
+		return true
+	}
+
+	t.Run("Roll", func(t *testing.T) {
+		_, w := setup(t, 5)
+
+		if got := w.Segments(); !equal(got, []uint64{1, 3, 5}) {
+			t.Errorf("expected segments starting at 1, 3 and 5, got %v", got)
+		}
+		if w.LastLSN() != 5 {
+			t.Errorf("expected last LSN 5, got %d", w.LastLSN())
+		}
+
+		loader := &batchRecorder{}
+		if _, err := w.ReplayTable(loader, 0); err != nil {
+			t.Fatal(err)
+		}
+		if !equal(loader.lsns, []uint64{1, 2, 3, 4, 5}) {
+			t.Errorf("expected LSNs 1 to 5 across the segments, got %v", loader.lsns)
+		}
+	})
+
+	t.Run("ReplayAfter", func(t *testing.T) {
+		_, w := setup(t, 5)
+
+		loader := &batchRecorder{}
+		result, err := w.ReplayTable(loader, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !equal(loader.lsns, []uint64{4, 5}) || result.Records != 2 {
+			t.Errorf("expected only LSNs 4 and 5, got %v", loader.lsns)
+		}
+		if loader.batches[0][0]["ts"] != int64(3) {
+			t.Errorf("expected the record of LSN 4 to hold ts 3, got %v", loader.batches[0][0]["ts"])
+		}
+	})
+
+	t.Run("Checkpoint", func(t *testing.T) {
+		dir, w := setup(t, 5)
+
+		// LSN 3 and 4 share a segment, so it stays until both are covered
+		if err := w.Checkpoint(3); err != nil {
+			t.Fatal(err)
+		}
+		if got := w.Segments(); !equal(got, []uint64{3, 5}) {
+			t.Errorf("expected segments 3 and 5 after checkpoint 3, got %v", got)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "00000000000000000001.wal")); !os.IsNotExist(err) {
+			t.Errorf("expected the first segment to be deleted, got %v", err)
+		}
+
+		if err := w.Checkpoint(5); err != nil {
+			t.Fatal(err)
+		}
+		if got := w.Segments(); !equal(got, []uint64{6}) {
+			t.Errorf("expected a fresh segment 6 once everything is covered, got %v", got)
+		}
+
+		if err := w.AppendRow(map[string]any{"ts": int64(9), "val": 9.0}); err != nil {
+			t.Fatal(err)
+		}
+		if w.LastLSN() != 6 {
+			t.Errorf("expected LSN 6 after the checkpoint, got %d", w.LastLSN())
+		}
+	})
+
+	t.Run("Reopen", func(t *testing.T) {
+		dir, w := setup(t, 3)
+		w.Close()
+
+		reopened, err := wal.NewWAL(dir, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer reopened.Close()
+
+		if reopened.LastLSN() != 3 {
+			t.Errorf("expected the reopened log to continue after LSN 3, got %d", reopened.LastLSN())
+		}
+		if err := reopened.AppendRow(map[string]any{"ts": int64(3), "val": 3.0}); err != nil {
+			t.Fatal(err)
+		}
+
+		loader := &batchRecorder{}
+		if _, err := reopened.ReplayTable(loader, 0); err != nil {
+			t.Fatal(err)
+		}
+		if !equal(loader.lsns, []uint64{1, 2, 3, 4}) {
+			t.Errorf("expected LSNs 1 to 4, got %v", loader.lsns)
+		}
+	})
+
+	t.Run("CheckpointAhead", func(t *testing.T) {
+		// blocks that outlived their log push the LSNs of a new one past theirs
+		dir := t.TempDir()
+		w, err := wal.NewWAL(dir, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Checkpoint(100); err != nil {
+			t.Fatal(err)
+		}
+		w.Close()
+
+		reopened, err := wal.NewWAL(dir, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer reopened.Close()
+
+		if err := reopened.AppendRow(map[string]any{"ts": int64(0), "val": 0.0}); err != nil {
+			t.Fatal(err)
+		}
+		if reopened.LastLSN() != 101 {
+			t.Errorf("expected LSN 101 after a checkpoint at 100, got %d", reopened.LastLSN())
+		}
+	})
+}
+
+func TestSyncPolicy(t *testing.T) {
+	s := schema.Schema{
+		Name:       "test_table",
+		TimeColumn: "ts",
+		Columns: []schema.Column{
+			{Name: "ts", Type: schema.Int64},
+			{Name: "val", Type: schema.Float64},
+		},
+	}
+
+	open := func(t *testing.T, p wal.SyncPolicy) *wal.WAL {
+		w, err := wal.NewWAL(t.TempDir(), s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { w.Close() })
+		if err := w.SetSyncPolicy(p); err != nil {
+			t.Fatal(err)
+		}
+		return w
+	}
+
+	appendRows := func(t *testing.T, w *wal.WAL, n int) {
+		for i := 0; i < n; i++ {
+			if err := w.AppendRow(map[string]any{"ts": int64(i), "val": 1.0}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	t.Run("Invalid", func(t *testing.T) {
+		w, err := wal.NewWAL(t.TempDir(), s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer w.Close()
+
+		for _, p := range []wal.SyncPolicy{
+			{Mode: wal.SyncEveryN},
+			{Mode: wal.SyncInterval},
+			{Mode: wal.SyncMode(42)},
+		} {
+			if err := w.SetSyncPolicy(p); err == nil {
+				t.Errorf("expected policy %+v to be rejected", p)
+			}
+		}
+	})
+
+	t.Run("None", func(t *testing.T) {
+		w := open(t, wal.SyncPolicy{})
+		appendRows(t, w, 3)
+		if w.Unsynced() != 3 {
+			t.Errorf("expected 3 unsynced records, got %d", w.Unsynced())
+		}
+	})
+
+	t.Run("Always", func(t *testing.T) {
+		w := open(t, wal.SyncPolicy{Mode: wal.SyncAlways})
+		appendRows(t, w, 3)
+		if w.Unsynced() != 0 {
+			t.Errorf("expected every record to be synced, got %d unsynced", w.Unsynced())
+		}
+	})
+
+	t.Run("EveryN", func(t *testing.T) {
+		w := open(t, wal.SyncPolicy{Mode: wal.SyncEveryN, N: 3})
+		appendRows(t, w, 2)
+		if w.Unsynced() != 2 {
+			t.Errorf("expected 2 unsynced records, got %d", w.Unsynced())
+		}
+		appendRows(t, w, 1)
+		if w.Unsynced() != 0 {
+			t.Errorf("expected the third record to trigger a sync, got %d unsynced", w.Unsynced())
+		}
+	})
+
+	t.Run("Interval", func(t *testing.T) {
+		w := open(t, wal.SyncPolicy{Mode: wal.SyncInterval, Interval: 5 * time.Millisecond})
+		appendRows(t, w, 3)
+
+		deadline := time.Now().Add(2 * time.Second)
+		for w.Unsynced() != 0 && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		if w.Unsynced() != 0 {
+			t.Errorf("expected the background syncer to sync, got %d unsynced", w.Unsynced())
+		}
+	})
+
+	t.Run("GroupCommit", func(t *testing.T) {
+		w := open(t, wal.SyncPolicy{Mode: wal.SyncGroupCommit})
+
+		var wg sync.WaitGroup
+		for g := 0; g < 16; g++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 20; i++ {
+					if err := w.AppendRow(map[string]any{"ts": int64(i), "val": 1.0}); err != nil {
+						t.Error(err)
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+
+		if w.Unsynced() != 0 {
+			t.Errorf("expected every committed record to be synced, got %d unsynced", w.Unsynced())
+		}
+
+		loader := &batchRecorder{}
+		if _, err := w.ReplayTable(loader, 0); err != nil {
+			t.Fatal(err)
+		}
+		if len(loader.batches) != 320 {
+			t.Errorf("expected 320 records, got %d", len(loader.batches))
+		}
+	})
+
+	// the fsync runs without the lock, new segments close the file it syncs meanwhile
+	t.Run("GroupCommitSegments", func(t *testing.T) {
+		w := open(t, wal.SyncPolicy{Mode: wal.SyncGroupCommit})
+		if err := w.SetSegmentSize(256); err != nil {
+			t.Fatal(err)
+		}
+
+		var wg sync.WaitGroup
+		for g := 0; g < 8; g++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 20; i++ {
+					if err := w.AppendRow(map[string]any{"ts": int64(i), "val": 1.0}); err != nil {
+						t.Error(err)
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+
+		if w.Unsynced() != 0 {
+			t.Errorf("expected every committed record to be synced, got %d unsynced", w.Unsynced())
+		}
+		if len(w.Segments()) < 2 {
+			t.Errorf("expected the log to span segments, got %v", w.Segments())
+		}
+	})
+}
+
+type batchRecorder struct {
+	batches [][]map[string]any
+	lsns    []uint64
+}
+
+func (b *batchRecorder) LoadBatchNoWAL(rows []map[string]any, lsn uint64) error {
+	b.batches = append(b.batches, rows)
+	b.lsns = append(b.lsns, lsn)
+	return nil
 }
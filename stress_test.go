@@ -4,6 +4,8 @@ import (
 	"backtraceDB/internal/db"
 	"backtraceDB/internal/schema"
 	"fmt"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -16,10 +18,19 @@ func TestOOMStress(t *testing.T) {
 		},
 	}
 
-	database, _ := db.Open("stress_db")
+	os.RemoveAll(filepath.Join("_data_internal", "stress_db"))
+	defer os.RemoveAll(filepath.Join("_data_internal", "stress_db"))
+
+	database, err := db.Open("stress_db", db.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
 	defer database.Close()
 
-	tbl, _ := database.CreateTable(s)
+	tbl, err := database.CreateTable(s)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	fmt.Println("🚀 Starting OOM Stress Test. Watch your RAM!")
 